@@ -9,6 +9,7 @@ import (
 // Statistics about code size in a program.
 type ProgramSize struct {
 	Packages map[string]*PackageSize
+	Symbols  []*SymbolSize // sorted by address
 	Sum      *PackageSize
 	Code     uint64
 	Data     uint64
@@ -44,6 +45,32 @@ func (ps *PackageSize) RAM() uint64 {
 	return ps.Data + ps.BSS
 }
 
+// The size of a single symbol, calculated from the linked object file.
+type SymbolSize struct {
+	Name    string
+	Package string
+	Section string // name of the ELF section, like .text
+	Kind    string // code, rodata, data or bss
+	Address uint64
+	Size    uint64
+}
+
+// Flash usage of the symbol: the code or data it stores in flash.
+func (s *SymbolSize) Flash() uint64 {
+	if s.Kind == "bss" {
+		return 0
+	}
+	return s.Size
+}
+
+// Static RAM usage of the symbol.
+func (s *SymbolSize) RAM() uint64 {
+	if s.Kind == "data" || s.Kind == "bss" {
+		return s.Size
+	}
+	return 0
+}
+
 type symbolList []elf.Symbol
 
 func (l symbolList) Len() int {
@@ -116,6 +143,7 @@ func Sizes(path string) (*ProgramSize, error) {
 	sort.Sort(symbolList(symbols))
 
 	sizes := map[string]*PackageSize{}
+	var symbolSizes []*SymbolSize
 	var lastSymbolValue uint64
 	for _, symbol := range symbols {
 		symType := elf.ST_TYPE(symbol.Info)
@@ -133,17 +161,30 @@ func Sizes(path string) (*ProgramSize, error) {
 			sizes[pkgName] = pkgSize
 		}
 		if lastSymbolValue != symbol.Value || lastSymbolValue == 0 {
+			var kind string
 			if symType == elf.STT_FUNC {
 				pkgSize.Code += symbol.Size
+				kind = "code"
 			} else if section.Flags&elf.SHF_WRITE != 0 {
 				if section.Type == elf.SHT_NOBITS {
 					pkgSize.BSS += symbol.Size
+					kind = "bss"
 				} else {
 					pkgSize.Data += symbol.Size
+					kind = "data"
 				}
 			} else {
 				pkgSize.ROData += symbol.Size
+				kind = "rodata"
 			}
+			symbolSizes = append(symbolSizes, &SymbolSize{
+				Name:    symbol.Name,
+				Package: pkgName,
+				Section: section.Name,
+				Kind:    kind,
+				Address: symbol.Value,
+				Size:    symbol.Size,
+			})
 		}
 		lastSymbolValue = symbol.Value
 	}
@@ -156,5 +197,42 @@ func Sizes(path string) (*ProgramSize, error) {
 		sum.BSS += pkg.BSS
 	}
 
-	return &ProgramSize{Packages: sizes, Code: sumCode, Data: sumData, BSS: sumBSS, Sum: sum}, nil
+	return &ProgramSize{Packages: sizes, Symbols: symbolSizes, Code: sumCode, Data: sumData, BSS: sumBSS, Sum: sum}, nil
+}
+
+// Memory usage of a linked program, calculated from the ELF segments and
+// sections. It includes everything the linker reserved, so also the stack.
+type MemoryUsage struct {
+	Flash uint64 // code, read-only data and initial values of globals
+	RAM   uint64 // globals and the stack
+	Stack uint64 // stack reserved by the linker script, 0 if unknown
+}
+
+// Calculate the flash and RAM usage of an ELF file, as loaded on a
+// microcontroller.
+func ReadMemoryUsage(path string) (*MemoryUsage, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	usage := &MemoryUsage{}
+	for _, prog := range file.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		// Everything in the file is stored in flash, including the initial
+		// values of globals that are copied to RAM at startup.
+		usage.Flash += prog.Filesz
+		// Segments that are writable, are loaded from a different address
+		// (like .data) or are not stored in the file (like .bss) are in RAM.
+		if prog.Flags&elf.PF_W != 0 || prog.Vaddr != prog.Paddr || prog.Memsz > prog.Filesz {
+			usage.RAM += prog.Memsz
+		}
+	}
+	if section := file.Section(".stack"); section != nil {
+		usage.Stack = section.Size
+	}
+	return usage, nil
 }
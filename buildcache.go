@@ -1,14 +1,42 @@
 package main
 
+// This file implements the build cache, usually in ~/.cache/tinygo. The cache
+// is content-addressed: the name of a cached file contains a hash of
+// everything that was used to create it (the contents of the source files, the
+// TinyGo version and a config key with the compiler version and flags), so a
+// change in any of them results in a new cache entry instead of a stale one.
+//
+// The cache is bounded in size. Each time a file is stored, the least recently
+// used files are removed until the cache is below the maximum size again. This
+// size can be changed with the TINYGOCACHESIZE environment variable. Files that
+// were used recently are never removed this way, as a build (possibly in
+// another process) may still be using a file it loaded from the cache.
+
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
-// Get the cache directory, usually ~/.cache/tinygo
+// The default maximum size of the cache directory.
+const defaultCacheMaxSize = 1 << 30 // 1GB
+
+// cacheMinAge is how long a file is kept in the cache after it was last used,
+// even if the cache is too big. It is much longer than a build takes.
+const cacheMinAge = time.Hour
+
+// Get the cache directory, usually ~/.cache/tinygo. It can be changed with the
+// TINYGOCACHE environment variable.
 func cacheDir() string {
+	if dir := os.Getenv("TINYGOCACHE"); dir != "" {
+		return dir
+	}
 	dir, err := os.UserCacheDir()
 	if err != nil {
 		panic("could not find cache dir: " + err.Error())
@@ -16,82 +44,200 @@ func cacheDir() string {
 	return filepath.Join(dir, "tinygo")
 }
 
-// Return the newest timestamp of all the file paths passed in. Used to check
-// for stale caches.
-func cacheTimestamp(paths []string) (time.Time, error) {
-	var timestamp time.Time
-	for _, path := range paths {
-		st, err := os.Stat(path)
+// cacheMaxSize returns the maximum size of the cache directory in bytes, as set
+// in TINYGOCACHESIZE (with an optional k/m/g suffix) or the default.
+func cacheMaxSize() int64 {
+	if s := os.Getenv("TINYGOCACHESIZE"); s != "" {
+		if size, err := parseSize(s); err == nil {
+			return size
+		}
+	}
+	return defaultCacheMaxSize
+}
+
+// cacheKey returns a hash of the TinyGo version, the config key and the names
+// and contents of all source files. It is used to identify a cache entry.
+func cacheKey(configKey string, sourceFiles []string) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, "tinygo "+version+"\x00")
+	io.WriteString(h, configKey+"\x00")
+	for _, path := range sourceFiles {
+		f, err := os.Open(path)
 		if err != nil {
-			return time.Time{}, err
+			return "", err
 		}
-		if timestamp.IsZero() {
-			timestamp = st.ModTime()
-		} else if timestamp.Before(st.ModTime()) {
-			timestamp = st.ModTime()
+		io.WriteString(h, filepath.Base(path)+"\x00")
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
 		}
+		io.WriteString(h, "\x00")
 	}
-	return timestamp, nil
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cachePath returns the path in the cache where the file with the given name
+// and cache key is stored. The key is inserted before the file extension, so
+// that the file type can still be recognized.
+func cachePath(name, key string) string {
+	ext := filepath.Ext(name)
+	return filepath.Join(cacheDir(), strings.TrimSuffix(name, ext)+"-"+key[:32]+ext)
 }
 
 // Try to load a given file from the cache. Return "", nil if no cached file can
-// be found (or the file is stale), return the absolute path if there is a cache
-// and return an error on I/O errors.
+// be found, return the absolute path if there is a cache and return an error on
+// I/O errors.
 //
-// TODO: the configKey is currently ignored. It is supposed to be used as extra
-// data for the cache key, like the compiler version and arguments.
+// The configKey must describe everything apart from the source files that
+// influences the output, like the compiler version and flags.
 func cacheLoad(name, configKey string, sourceFiles []string) (string, error) {
-	dir := cacheDir()
-	cachepath := filepath.Join(dir, name)
-	cacheStat, err := os.Stat(cachepath)
-	if os.IsNotExist(err) {
-		return "", nil // does not exist
-	} else if err != nil {
-		return "", err // cannot stat cache file
-	}
-
-	sourceTimestamp, err := cacheTimestamp(sourceFiles)
+	key, err := cacheKey(configKey, sourceFiles)
 	if err != nil {
-		return "", err // cannot stat source files
+		return "", err // cannot read source files
 	}
+	cachepath := cachePath(name, key)
 
-	if cacheStat.ModTime().After(sourceTimestamp) {
-		return cachepath, nil
-	} else {
-		os.Remove(cachepath)
-		// stale cache
-		return "", nil
+	// Mark this file as recently used, so that it is not evicted while it is
+	// used (see cacheEvict). This also checks whether it exists, so that a
+	// file that has just been evicted by another process is not returned.
+	now := time.Now()
+	err = os.Chtimes(cachepath, now, now)
+	if os.IsNotExist(err) {
+		return "", nil // does not exist
+	} else if err != nil {
+		// The cache may be read-only, so check whether the file exists.
+		_, err = os.Stat(cachepath)
+		if os.IsNotExist(err) {
+			return "", nil // does not exist
+		} else if err != nil {
+			return "", err // cannot stat cache file
+		}
 	}
+	return cachepath, nil
 }
 
 // Store the file located at tmppath in the cache with the given name. The
-// tmppath may or may not be gone afterwards.
-//
-// Note: the configKey is ignored, see cacheLoad.
+// tmppath may or may not be gone afterwards. Old files are evicted from the
+// cache if it grows too big.
 func cacheStore(tmppath, name, configKey string, sourceFiles []string) (string, error) {
-	// get the last modified time
 	if len(sourceFiles) == 0 {
 		panic("cache: no source files")
 	}
+	key, err := cacheKey(configKey, sourceFiles)
+	if err != nil {
+		return "", err
+	}
 
-	// TODO: check the config key
-
-	dir := cacheDir()
-	err := os.MkdirAll(dir, 0777)
+	err = os.MkdirAll(cacheDir(), 0777)
 	if err != nil {
 		return "", err
 	}
-	cachepath := filepath.Join(dir, name)
+	cachepath := cachePath(name, key)
 	err = moveFile(tmppath, cachepath)
 	if err != nil {
 		return "", err
 	}
+	err = cacheEvict(cacheMaxSize(), cachepath)
+	if err != nil {
+		return "", err
+	}
 	return cachepath, nil
 }
 
+// cacheEntries returns all files in the cache directory, least recently used
+// first. Temporary files that are still being written are left out.
+func cacheEntries() ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(cacheDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []os.FileInfo
+	for _, info := range infos {
+		if info.IsDir() || strings.HasSuffix(info.Name(), ".tmp") {
+			continue
+		}
+		entries = append(entries, info)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+	return entries, nil
+}
+
+// cacheEvict removes the least recently used files from the cache until its
+// total size is at most maxSize. The file at keep (if any) and files used in
+// the last cacheMinAge are never removed.
+func cacheEvict(maxSize int64, keep string) error {
+	entries, err := cacheEntries()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size()
+	}
+	dir := cacheDir()
+	limit := time.Now().Add(-cacheMinAge)
+	for _, entry := range entries {
+		if total <= maxSize {
+			break
+		}
+		path := filepath.Join(dir, entry.Name())
+		if path == keep {
+			continue
+		}
+		// Check the time of last use again, as the file may have been loaded
+		// since the cache directory was read.
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			// Another process has removed it already.
+			total -= entry.Size()
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !info.ModTime().Before(limit) {
+			continue
+		}
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			// Another process might have removed it already.
+			return err
+		}
+		total -= entry.Size()
+	}
+	return nil
+}
+
+// cacheClean removes all files from the cache that have not been used for the
+// given duration.
+func cacheClean(olderThan time.Duration) error {
+	entries, err := cacheEntries()
+	if err != nil {
+		return err
+	}
+	limit := time.Now().Add(-olderThan)
+	dir := cacheDir()
+	for _, entry := range entries {
+		if !entry.ModTime().Before(limit) {
+			// Entries are sorted by time, so all following entries are newer.
+			break
+		}
+		err := os.Remove(filepath.Join(dir, entry.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 // moveFile renames the file from src to dst. If renaming doesn't work (for
 // example, the rename crosses a filesystem boundary), the file is copied and
-// the old file is removed.
+// the old file is removed. In both cases dst is replaced atomically, so other
+// processes never see a partially written file.
 func moveFile(src, dst string) error {
 	err := os.Rename(src, dst)
 	if err == nil {
@@ -105,22 +251,42 @@ func moveFile(src, dst string) error {
 		return err
 	}
 	defer inf.Close()
-	outpath := dst + ".tmp"
-	outf, err := os.Create(outpath)
+	outf, err := ioutil.TempFile(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
 	if err != nil {
 		return err
 	}
+	outpath := outf.Name()
 
 	_, err = io.Copy(outf, inf)
+	if err != nil {
+		outf.Close()
+		os.Remove(outpath)
+		return err
+	}
+	err = outf.Close()
 	if err != nil {
 		os.Remove(outpath)
 		return err
 	}
 
-	err = os.Rename(dst+".tmp", dst)
+	err = os.Rename(outpath, dst)
 	if err != nil {
+		os.Remove(outpath)
 		return err
 	}
 
-	return outf.Close()
+	os.Remove(src)
+	return nil
+}
+
+// packageCache stores the bitcode of separately compiled packages in the build
+// cache. It implements compiler.PackageCache.
+type packageCache struct{}
+
+func (packageCache) Load(name, key string, sourceFiles []string) (string, error) {
+	return cacheLoad(name, key, sourceFiles)
+}
+
+func (packageCache) Store(tmppath, name, key string, sourceFiles []string) (string, error) {
+	return cacheStore(tmppath, name, key, sourceFiles)
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// setTestCacheDir points TINYGOCACHE to a new temporary directory, and returns
+// a function that restores the previous cache directory and removes the
+// temporary one.
+func setTestCacheDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "tinygo-cache-test")
+	if err != nil {
+		t.Fatal("could not create temporary directory:", err)
+	}
+	old, hadOld := os.LookupEnv("TINYGOCACHE")
+	os.Setenv("TINYGOCACHE", dir)
+	return func() {
+		if hadOld {
+			os.Setenv("TINYGOCACHE", old)
+		} else {
+			os.Unsetenv("TINYGOCACHE")
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// writeCacheFile creates a file in the cache directory with the given size,
+// which was last used the given duration ago.
+func writeCacheFile(t *testing.T, name string, size int, age time.Duration) {
+	path := filepath.Join(cacheDir(), name)
+	err := ioutil.WriteFile(path, make([]byte, size), 0666)
+	if err != nil {
+		t.Fatal("could not write cache file:", err)
+	}
+	used := time.Now().Add(-age)
+	err = os.Chtimes(path, used, used)
+	if err != nil {
+		t.Fatal("could not set time of cache file:", err)
+	}
+}
+
+// cacheFileNames returns the sorted names of all files in the cache directory.
+func cacheFileNames(t *testing.T) []string {
+	infos, err := ioutil.ReadDir(cacheDir())
+	if err != nil {
+		t.Fatal("could not read cache directory:", err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkCacheFiles(t *testing.T, expected ...string) {
+	t.Helper()
+	actual := cacheFileNames(t)
+	if len(actual) != len(expected) {
+		t.Errorf("expected cache files %v, got %v", expected, actual)
+		return
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			t.Errorf("expected cache files %v, got %v", expected, actual)
+			return
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	defer setTestCacheDir(t)()
+	dir := cacheDir()
+	a := filepath.Join(dir, "a.c")
+	b := filepath.Join(dir, "b.c")
+	ioutil.WriteFile(a, []byte("int a;"), 0666)
+	ioutil.WriteFile(b, []byte("int b;"), 0666)
+
+	key := func(configKey string, sourceFiles ...string) string {
+		key, err := cacheKey(configKey, sourceFiles)
+		if err != nil {
+			t.Fatal("could not calculate cache key:", err)
+		}
+		return key
+	}
+	base := key("config", a, b)
+	if key("config", a, b) != base {
+		t.Error("cache key is not stable")
+	}
+	if key("other config", a, b) == base {
+		t.Error("cache key does not depend on the config key")
+	}
+	if key("config", b, a) == base {
+		t.Error("cache key does not depend on the order of the source files")
+	}
+	if key("config", a) == base {
+		t.Error("cache key does not depend on the list of source files")
+	}
+	ioutil.WriteFile(b, []byte("int b = 1;"), 0666)
+	if key("config", a, b) == base {
+		t.Error("cache key does not depend on the contents of the source files")
+	}
+	if _, err := cacheKey("config", []string{filepath.Join(dir, "missing.c")}); err == nil {
+		t.Error("expected an error for a missing source file")
+	}
+}
+
+func TestCacheEvict(t *testing.T) {
+	defer setTestCacheDir(t)()
+	writeCacheFile(t, "oldest.a", 100, 4*time.Hour)
+	writeCacheFile(t, "old.a", 100, 3*time.Hour)
+	writeCacheFile(t, "kept.a", 100, 5*time.Hour)
+	writeCacheFile(t, "recent.a", 100, time.Minute)
+	writeCacheFile(t, "new.a", 100, 0)
+	writeCacheFile(t, "partial.a.123.tmp", 1000, 5*time.Hour)
+
+	// The least recently used files are removed first, and temporary files
+	// are neither removed nor counted.
+	err := cacheEvict(400, filepath.Join(cacheDir(), "kept.a"))
+	if err != nil {
+		t.Fatal("could not evict files:", err)
+	}
+	checkCacheFiles(t, "kept.a", "new.a", "old.a", "partial.a.123.tmp", "recent.a")
+
+	// Recently used files are not removed, even when the cache is too big.
+	err = cacheEvict(0, filepath.Join(cacheDir(), "kept.a"))
+	if err != nil {
+		t.Fatal("could not evict files:", err)
+	}
+	checkCacheFiles(t, "kept.a", "new.a", "partial.a.123.tmp", "recent.a")
+}
+
+func TestCacheClean(t *testing.T) {
+	defer setTestCacheDir(t)()
+	writeCacheFile(t, "old.a", 100, 3*time.Hour)
+	writeCacheFile(t, "new.a", 100, time.Minute)
+	writeCacheFile(t, "partial.a.123.tmp", 100, 3*time.Hour)
+
+	err := cacheClean(time.Hour)
+	if err != nil {
+		t.Fatal("could not clean cache:", err)
+	}
+	checkCacheFiles(t, "new.a", "partial.a.123.tmp")
+
+	err = cacheClean(0)
+	if err != nil {
+		t.Fatal("could not clean cache:", err)
+	}
+	checkCacheFiles(t, "partial.a.123.tmp")
+}
+
+func TestCacheLoad(t *testing.T) {
+	defer setTestCacheDir(t)()
+	source := filepath.Join(cacheDir(), "source.c.tmp")
+	ioutil.WriteFile(source, []byte("int a;"), 0666)
+	sources := []string{source}
+
+	path, err := cacheLoad("lib.a", "config", sources)
+	if path != "" || err != nil {
+		t.Fatalf("expected a cache miss, got %q, %v", path, err)
+	}
+	tmpfile := filepath.Join(cacheDir(), "lib.a.tmp")
+	ioutil.WriteFile(tmpfile, []byte("archive"), 0666)
+	stored, err := cacheStore(tmpfile, "lib.a", "config", sources)
+	if err != nil {
+		t.Fatal("could not store file:", err)
+	}
+
+	// Loading a file marks it as recently used, so that it is not evicted
+	// while a build uses it.
+	old := time.Now().Add(-2 * cacheMinAge)
+	os.Chtimes(stored, old, old)
+	path, err = cacheLoad("lib.a", "config", sources)
+	if path != stored || err != nil {
+		t.Fatalf("expected a cache hit at %q, got %q, %v", stored, path, err)
+	}
+	err = cacheEvict(0, "")
+	if err != nil {
+		t.Fatal("could not evict files:", err)
+	}
+	if _, err := os.Stat(stored); err != nil {
+		t.Error("file that was just loaded was evicted:", err)
+	}
+
+	// A file that was evicted by another process is a cache miss.
+	os.Remove(stored)
+	path, err = cacheLoad("lib.a", "config", sources)
+	if path != "" || err != nil {
+		t.Errorf("expected a cache miss after eviction, got %q, %v", path, err)
+	}
+}
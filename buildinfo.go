@@ -0,0 +1,185 @@
+package main
+
+// This file implements -buildinfo, which embeds a GNU build ID and a note with
+// information about the build (TinyGo version, target, VCS revision and build
+// flags) in the program, and `tinygo version -m` which reads them back. Both
+// notes are stored in flash, so they are also part of .hex, .bin and .uf2
+// files and can be read from a device.
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+const (
+	buildInfoSection  = ".note.tinygo"
+	buildInfoNoteName = "TinyGo"
+	buildInfoNoteType = 1
+	buildIDNoteName   = "GNU"
+	buildIDNoteType   = 3 // NT_GNU_BUILD_ID
+)
+
+// BuildInfo is the information about a build that is embedded with
+// -buildinfo.
+type BuildInfo struct {
+	BuildID  string      // GNU build ID as a hexadecimal string, empty if there is none
+	Settings [][2]string // key and value of each line in the build information note
+}
+
+// buildInfo returns the contents of the build information note for the given
+// package: a line with a key and value separated by a tab for each setting.
+func buildInfo(pkgName string, spec *TargetSpec, config *BuildConfig) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	add := func(key, value string) {
+		fmt.Fprintf(buf, "%s\t%s\n", key, value)
+	}
+	add("tinygo", version)
+	add("target", spec.Name)
+	add("build", "-opt="+config.opt)
+	if config.gc != "" {
+		add("build", "-gc="+config.gc)
+	}
+	add("build", "-panic="+config.panicStrategy)
+	if config.tags != "" {
+		add("build", "-tags="+config.tags)
+	}
+	if !config.debug {
+		add("build", "-no-debug")
+	}
+	if config.trimPath {
+		add("build", "-trimpath")
+	}
+
+	// The VCS revision of the main package, if it is in a git repository. The
+	// directory of the main package is found the same way as in `tinygo list`.
+	c, err := newEnvCompiler(pkgName, spec, config)
+	if err != nil {
+		return nil, err
+	}
+	mainPkg, _, err := c.LoadPackages(pkgName)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = mainPkg.Package.Dir
+	if revision, err := cmd.Output(); err == nil {
+		add("vcs.revision", strings.TrimSpace(string(revision)))
+		cmd := exec.Command("git", "status", "--porcelain", "--untracked-files=no")
+		cmd.Dir = mainPkg.Package.Dir
+		if status, err := cmd.Output(); err == nil {
+			add("vcs.modified", fmt.Sprint(len(status) != 0))
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// linkerFlag returns the given flag in a form the linker accepts: as is for a
+// linker like ld.lld, or prefixed with -Wl, for a compiler used as a linker.
+func linkerFlag(linker, flag string) string {
+	if linker == "ld" || linker == "ld.lld" || strings.HasSuffix(linker, "-ld") {
+		return flag
+	}
+	return "-Wl," + flag
+}
+
+// ReadBuildInfo reads the build ID and the build information embedded with
+// -buildinfo from the given ELF file. The notes are searched in the segments
+// that are stored in the firmware image (see ExtractROM), as they are usually
+// not in a separate section.
+func ReadBuildInfo(path string) (*BuildInfo, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var buildID, desc []byte
+	for _, prog := range romSegments(f) {
+		data, err := ioutil.ReadAll(prog.Open())
+		if err != nil {
+			return nil, err
+		}
+		if buildID == nil {
+			buildID = findNote(data, f.ByteOrder, buildIDNoteName, buildIDNoteType)
+		}
+		if desc == nil {
+			desc = findNote(data, f.ByteOrder, buildInfoNoteName, buildInfoNoteType)
+		}
+	}
+	if desc == nil {
+		return nil, errors.New("no build information found in " + path + " (was it built with -buildinfo?)")
+	}
+
+	info := &BuildInfo{BuildID: hex.EncodeToString(buildID)}
+	for _, line := range strings.Split(strings.TrimSuffix(string(desc), "\n"), "\n") {
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			return nil, errors.New("invalid build information in " + path)
+		}
+		info.Settings = append(info.Settings, [2]string{fields[0], fields[1]})
+	}
+	return info, nil
+}
+
+// findNote returns the description of the first ELF note with the given name
+// and type in data, or nil if there is no such note.
+func findNote(data []byte, byteOrder binary.ByteOrder, name string, noteType uint32) []byte {
+	nameBytes := append([]byte(name), 0)
+	offset := 0
+	for {
+		i := bytes.Index(data[offset:], nameBytes)
+		if i < 0 {
+			return nil
+		}
+		i += offset
+		offset = i + 1
+
+		// The name follows a header with the name size, description size and
+		// type, and is padded to 4 bytes.
+		if i < 12 {
+			continue
+		}
+		header := data[i-12 : i]
+		if byteOrder.Uint32(header[0:]) != uint32(len(nameBytes)) || byteOrder.Uint32(header[8:]) != noteType {
+			continue
+		}
+		start := i + (len(nameBytes)+3)&^3
+		end := start + int(byteOrder.Uint32(header[4:]))
+		if end > len(data) || end < start {
+			continue
+		}
+		return data[start:end]
+	}
+}
+
+// printBuildInfo prints the build information of the given file, in a format
+// similar to `go version -m`.
+func printBuildInfo(path string) error {
+	info, err := ReadBuildInfo(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s:", path)
+	for _, setting := range info.Settings {
+		if setting[0] == "tinygo" {
+			fmt.Printf(" tinygo %s", setting[1])
+		}
+	}
+	fmt.Println()
+	if info.BuildID != "" {
+		fmt.Printf("\tbuild-id\t%s\n", info.BuildID)
+	}
+	for _, setting := range info.Settings {
+		if setting[0] != "tinygo" {
+			fmt.Printf("\t%s\t%s\n", setting[0], setting[1])
+		}
+	}
+	return nil
+}
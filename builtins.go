@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/blakesmith/ar"
@@ -167,13 +168,26 @@ func builtinFiles(target string) []string {
 	return builtins
 }
 
+// builtinsCFlags returns the flags used to compile the builtins for the given
+// target, apart from the input and output files.
+func builtinsCFlags(target string) []string {
+	return []string{"-c", "-Oz", "-g", "-Werror", "-Wall", "-std=c11", "-fshort-enums", "-nostdlibinc", "-ffunction-sections", "-fdata-sections", "--target=" + target}
+}
+
 // builtinsDir returns the directory where the sources for compiler-rt are kept.
 func builtinsDir() string {
 	return filepath.Join(sourceDir(), "lib", "compiler-rt", "lib", "builtins")
 }
 
+// builtinsLock makes sure the builtins archive is only built once when
+// multiple test binaries are built at the same time.
+var builtinsLock sync.Mutex
+
 // Get the builtins archive, possibly generating it as needed.
 func loadBuiltins(target string) (path string, err error) {
+	builtinsLock.Lock()
+	defer builtinsLock.Unlock()
+
 	// Try to load a precompiled compiler-rt library.
 	precompiledPath := filepath.Join(sourceDir(), "pkg", target, "compiler-rt.a")
 	if _, err := os.Stat(precompiledPath); err == nil {
@@ -191,13 +205,20 @@ func loadBuiltins(target string) (path string, err error) {
 		srcs[i] = filepath.Join(builtinsDir, name)
 	}
 
-	if path, err := cacheLoad(outfile, commands["clang"][0], srcs); path != "" || err != nil {
+	// The cached archive depends on the exact compiler and flags used.
+	clangVersion, err := commandVersion(commands["clang"])
+	if err != nil {
+		return "", err
+	}
+	configKey := clangVersion + "\n" + strings.Join(builtinsCFlags(target), " ")
+
+	if path, err := cacheLoad(outfile, configKey, srcs); path != "" || err != nil {
 		return path, err
 	}
 
 	var cachepath string
 	err = compileBuiltins(target, func(path string) error {
-		path, err := cacheStore(path, outfile, commands["clang"][0], srcs)
+		path, err := cacheStore(path, outfile, configKey, srcs)
 		cachepath = path
 		return err
 	})
@@ -239,7 +260,8 @@ func compileBuiltins(target string, callback func(path string) error) error {
 		// Note: -fdebug-prefix-map is necessary to make the output archive
 		// reproducible. Otherwise the temporary directory is stored in the
 		// archive itself, which varies each run.
-		err := execCommand(commands["clang"], "-c", "-Oz", "-g", "-Werror", "-Wall", "-std=c11", "-fshort-enums", "-nostdlibinc", "-ffunction-sections", "-fdata-sections", "--target="+target, "-fdebug-prefix-map="+dir+"="+remapDir, "-o", objpath, srcpath)
+		args := append(builtinsCFlags(target), "-fdebug-prefix-map="+dir+"="+remapDir, "-o", objpath, srcpath)
+		err := execCommand(commands["clang"], args...)
 		if err != nil {
 			return &commandError{"failed to build", srcpath, err}
 		}
@@ -43,3 +43,21 @@ func execCommand(cmdNames []string, args ...string) error {
 	}
 	return errors.New("none of these commands were found in your $PATH: " + strings.Join(cmdNames, " "))
 }
+
+// commandVersion returns the output of the first command in cmdNames that
+// exists when run with --version. It is used as part of cache keys, so that a
+// compiler upgrade invalidates cached files.
+func commandVersion(cmdNames []string) (string, error) {
+	for _, cmdName := range cmdNames {
+		out, err := exec.Command(cmdName, "--version").Output()
+		if err != nil {
+			if err, ok := err.(*exec.Error); ok && err.Err == exec.ErrNotFound {
+				// this command was not found, try the next
+				continue
+			}
+			return "", err
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	return "", errors.New("none of these commands were found in your $PATH: " + strings.Join(cmdNames, " "))
+}
@@ -0,0 +1,157 @@
+package compiler
+
+// This file implements the sync/atomic package as compiler builtins, using
+// LLVM atomic instructions. On targets without (sufficiently wide) atomic
+// instructions, the implementations in the runtime are called instead, which
+// disable interrupts around the operation.
+
+import (
+	"go/types"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/tools/go/ssa"
+	"tinygo.org/x/go-llvm"
+)
+
+/*
+#include <llvm-c/Core.h>
+*/
+import "C"
+
+// emitAtomic emits the sync/atomic function with the given name (without the
+// package prefix) as atomic instructions. It returns false when this is not
+// possible on the current target, in which case a regular call must be made.
+func (c *Compiler) emitAtomic(frame *Frame, name string, instr *ssa.CallCommon) (llvm.Value, bool) {
+	switch {
+	case strings.HasPrefix(name, "Add"), strings.HasPrefix(name, "Swap"), strings.HasPrefix(name, "CompareAndSwap"), strings.HasPrefix(name, "Load"), strings.HasPrefix(name, "Store"):
+	default:
+		// Not an atomic operation, for example runtime_procPin.
+		return llvm.Value{}, false
+	}
+
+	// The first parameter is always the address, and the second (if present)
+	// is always the value to use in the operation. Check whether these fit in
+	// a native atomic operation before emitting any code.
+	valueType := c.getLLVMType(instr.Args[0].Type().Underlying().(*types.Pointer).Elem())
+	if !c.hasAtomics(c.targetData.TypeAllocSize(valueType) * 8) {
+		return llvm.Value{}, false
+	}
+	isPointer := valueType.TypeKind() == llvm.PointerTypeKind
+
+	ptr := c.getValue(frame, instr.Args[0])
+	c.emitNilCheck(frame, ptr, "deref")
+	align := int(c.targetData.TypeAllocSize(valueType))
+
+	// atomicrmw and cmpxchg only operate on integers, so cast pointers to
+	// uintptr first.
+	if isPointer {
+		ptr = c.builder.CreateBitCast(ptr, llvm.PointerType(c.uintptrType, 0), "")
+	}
+	getOperand := func(index int) llvm.Value {
+		value := c.getValue(frame, instr.Args[index])
+		if isPointer {
+			value = c.builder.CreatePtrToInt(value, c.uintptrType, "")
+		}
+		return value
+	}
+	getResult := func(value llvm.Value) llvm.Value {
+		if isPointer {
+			value = c.builder.CreateIntToPtr(value, valueType, "")
+		}
+		return value
+	}
+
+	switch {
+	case strings.HasPrefix(name, "Add"):
+		delta := getOperand(1)
+		oldValue := c.createAtomicRMW(C.LLVMAtomicRMWBinOpAdd, ptr, delta)
+		// atomicrmw returns the old value, while AddT returns the new value.
+		return c.builder.CreateAdd(oldValue, delta, ""), true
+	case strings.HasPrefix(name, "Swap"):
+		newValue := getOperand(1)
+		oldValue := c.createAtomicRMW(C.LLVMAtomicRMWBinOpXchg, ptr, newValue)
+		return getResult(oldValue), true
+	case strings.HasPrefix(name, "CompareAndSwap"):
+		oldValue := getOperand(1)
+		newValue := getOperand(2)
+		tuple := c.createAtomicCmpXchg(ptr, oldValue, newValue)
+		return c.builder.CreateExtractValue(tuple, 1, "swapped"), true
+	case strings.HasPrefix(name, "Load"):
+		value := c.builder.CreateLoad(ptr, "")
+		setSequentiallyConsistent(value)
+		value.SetAlignment(align)
+		return getResult(value), true
+	case strings.HasPrefix(name, "Store"):
+		store := c.builder.CreateStore(getOperand(1), ptr)
+		setSequentiallyConsistent(store)
+		store.SetAlignment(align)
+		return llvm.Value{}, true
+	default:
+		panic("unreachable")
+	}
+}
+
+// The atomic instructions are not available in the Go bindings of LLVM, so
+// they are created through the LLVM C API directly. All operations use
+// sequentially consistent ordering, as required by the Go memory model.
+
+// createAtomicRMW creates an atomicrmw instruction with the given operation,
+// which returns the old value.
+func (c *Compiler) createAtomicRMW(op C.LLVMAtomicRMWBinOp, ptr, value llvm.Value) llvm.Value {
+	inst := C.LLVMBuildAtomicRMW(llvmBuilderRef(c.builder), op, llvmValueRef(ptr), llvmValueRef(value), C.LLVMAtomicOrderingSequentiallyConsistent, 0)
+	return wrapLLVMValue(inst)
+}
+
+// createAtomicCmpXchg creates a cmpxchg instruction, which returns a tuple of
+// the old value and whether the swap succeeded.
+func (c *Compiler) createAtomicCmpXchg(ptr, oldValue, newValue llvm.Value) llvm.Value {
+	inst := C.LLVMBuildAtomicCmpXchg(llvmBuilderRef(c.builder), llvmValueRef(ptr), llvmValueRef(oldValue), llvmValueRef(newValue), C.LLVMAtomicOrderingSequentiallyConsistent, C.LLVMAtomicOrderingSequentiallyConsistent, 0)
+	return wrapLLVMValue(inst)
+}
+
+// setSequentiallyConsistent makes the given load or store instruction atomic.
+func setSequentiallyConsistent(inst llvm.Value) {
+	C.LLVMSetOrdering(llvmValueRef(inst), C.LLVMAtomicOrderingSequentiallyConsistent)
+}
+
+// llvmBuilderRef, llvmValueRef and wrapLLVMValue convert between the types of
+// the Go bindings and the C API, which are the same pointers.
+func llvmBuilderRef(b llvm.Builder) C.LLVMBuilderRef {
+	return C.LLVMBuilderRef(unsafe.Pointer(b.C))
+}
+
+func llvmValueRef(v llvm.Value) C.LLVMValueRef {
+	return C.LLVMValueRef(unsafe.Pointer(v.C))
+}
+
+func wrapLLVMValue(ref C.LLVMValueRef) llvm.Value {
+	return *(*llvm.Value)(unsafe.Pointer(&ref))
+}
+
+// hasAtomics returns whether the target supports atomic operations of the
+// given size (in bits) natively.
+func (c *Compiler) hasAtomics(bits uint64) bool {
+	switch {
+	case strings.HasPrefix(c.Triple, "avr"), strings.HasPrefix(c.Triple, "armv6m"):
+		// No atomic instructions at all (except for plain loads and stores).
+		return false
+	case strings.HasPrefix(c.Triple, "armv7m"), strings.HasPrefix(c.Triple, "armv7em"):
+		// ARMv7-M has ldrex/strex, but no 64-bit variants.
+		return bits <= 32
+	case strings.HasPrefix(c.Triple, "riscv32"):
+		// Atomic instructions are part of the optional A extension.
+		for _, feature := range c.Features {
+			if feature == "+a" {
+				return bits <= 32
+			}
+		}
+		return false
+	case strings.HasPrefix(c.Triple, "wasm"):
+		// WebAssembly atomics are a separate proposal, which requires shared
+		// memory. Without threads, the runtime implementation is fine.
+		return false
+	default:
+		return true
+	}
+}
@@ -12,24 +12,17 @@ import (
 )
 
 // emitMakeChan returns a new channel value for the given channel type.
-func (c *Compiler) emitMakeChan(expr *ssa.MakeChan) (llvm.Value, error) {
-	chanType := c.getLLVMType(expr.Type())
-	size := c.targetData.TypeAllocSize(chanType.ElementType())
-	sizeValue := llvm.ConstInt(c.uintptrType, size, false)
-	ptr := c.createRuntimeCall("alloc", []llvm.Value{sizeValue}, "chan.alloc")
-	ptr = c.builder.CreateBitCast(ptr, chanType, "chan")
-	// Set the elementSize field
-	elementSizePtr := c.builder.CreateGEP(ptr, []llvm.Value{
-		llvm.ConstInt(c.ctx.Int32Type(), 0, false),
-		llvm.ConstInt(c.ctx.Int32Type(), 0, false),
-	}, "")
-	elementSize := c.targetData.TypeAllocSize(c.getLLVMType(expr.Type().(*types.Chan).Elem()))
+func (c *Compiler) emitMakeChan(frame *Frame, expr *ssa.MakeChan) (llvm.Value, error) {
+	elementSize := c.targetData.TypeAllocSize(c.getLLVMType(expr.Type().Underlying().(*types.Chan).Elem()))
 	if elementSize > 0xffff {
-		return ptr, c.makeError(expr.Pos(), fmt.Sprintf("element size is %d bytes, which is bigger than the maximum of %d bytes", elementSize, 0xffff))
+		return llvm.Value{}, c.makeError(expr.Pos(), fmt.Sprintf("element size is %d bytes, which is bigger than the maximum of %d bytes", elementSize, 0xffff))
 	}
-	elementSizeValue := llvm.ConstInt(c.ctx.Int16Type(), elementSize, false)
-	c.builder.CreateStore(elementSizeValue, elementSizePtr)
-	return ptr, nil
+	elementSizeValue := llvm.ConstInt(c.uintptrType, elementSize, false)
+	bufSize, err := c.parseConvert(expr.Size.Type(), types.Typ[types.Uintptr], c.getValue(frame, expr.Size), expr.Pos())
+	if err != nil {
+		return llvm.Value{}, err
+	}
+	return c.createRuntimeCall("chanMake", []llvm.Value{elementSizeValue, bufSize}, "chan"), nil
 }
 
 // emitChanSend emits a pseudo chan send operation. It is lowered to the actual
@@ -180,18 +173,16 @@ func (c *Compiler) emitSelect(frame *Frame, expr *ssa.Select) llvm.Value {
 	}, "select.states")
 	statesLen := llvm.ConstInt(c.uintptrType, uint64(len(selectStates)), false)
 
-	// Convert the 'blocking' flag on this select into a LLVM value.
-	blockingInt := uint64(0)
+	// Do the select in the runtime. A blocking select is implemented with
+	// runtime.chanSelectBlocking, which may park the current goroutine and is
+	// therefore lowered as a blocking call during goroutine lowering.
+	fnName := "chanSelect"
 	if expr.Blocking {
-		blockingInt = 1
+		fnName = "chanSelectBlocking"
 	}
-	blockingValue := llvm.ConstInt(c.ctx.Int1Type(), blockingInt, false)
-
-	// Do the select in the runtime.
-	results := c.createRuntimeCall("chanSelect", []llvm.Value{
+	results := c.createRuntimeCall(fnName, []llvm.Value{
 		recvbuf,
 		statesPtr, statesLen, statesLen, // []chanSelectState
-		blockingValue,
 	}, "")
 
 	// The result value does not include all the possible received values,
@@ -44,16 +44,23 @@ type Config struct {
 	ClangHeaders  string   // Clang built-in header include path
 	DumpSSA       bool     // dump Go SSA, for compiler debugging
 	Debug         bool     // add debug symbols for gdb
+	Symtab        bool     // add a symbol table for runtime.Caller and panic tracebacks (requires Debug)
+	StackSizes    bool     // emit the stack size of every function in a .stack_sizes section
+	TrimPath      bool     // replace the directory of source files with the import path of their package
 	GOROOT        string   // GOROOT
 	TINYGOROOT    string   // GOROOT for TinyGo
 	GOPATH        string   // GOPATH, like `go env GOPATH`
 	BuildTags     []string // build tags for TinyGo (empty means {Config.GOOS/Config.GOARCH})
 	TestConfig    TestConfig
+	PackageCache  PackageCache // cache for per-package bitcode (nil disables incremental compilation)
 }
 
 type TestConfig struct {
 	CompileTestBinary bool
-	// TODO: Filter the test functions to run, include verbose flag, etc
+	Verbose           bool   // print all test results and log messages (-v)
+	Run               string // regular expression selecting the tests to run (-run)
+	Bench             string // regular expression selecting the benchmarks to run (-bench)
+	BenchTime         string // run time or number of iterations of each benchmark (-benchtime)
 }
 
 type Compiler struct {
@@ -64,6 +71,7 @@ type Compiler struct {
 	dibuilder               *llvm.DIBuilder
 	cu                      llvm.Metadata
 	difiles                 map[string]llvm.Metadata
+	packageDirs             map[string]string // import path of the package in each directory, for TrimPath
 	machine                 llvm.TargetMachine
 	targetData              llvm.TargetData
 	intType                 llvm.Type
@@ -72,9 +80,14 @@ type Compiler struct {
 	uintptrType             llvm.Type
 	initFuncs               []llvm.Value
 	interfaceInvokeWrappers []interfaceInvokeWrapper
+	unwindBlocks            map[llvm.Value]llvm.BasicBlock
+	typeCodeTypes           map[string]types.Type // Go types of type code globals, for the reflect side tables
+	symtabFuncs             []string              // functions in runtime.symtab, in order, to fill in their size
+	ownedPackages           map[*ssa.Package]bool // packages with globals defined in the current module (nil means all)
 	ir                      *ir.Program
 	diagnostics             []error
 	astComments             map[string]*ast.CommentGroup
+	goroutineStarts         []string // functions started with a go statement, for the stack usage analysis
 }
 
 type Frame struct {
@@ -92,6 +105,7 @@ type Frame struct {
 	deferInvokeFuncs  map[string]int
 	deferClosureFuncs map[*ir.Function]int
 	selectRecvBuf     map[*ssa.Select]llvm.Value
+	panicRecord       llvm.Value // deferred call claimed for recover(), see emitRecover
 }
 
 type Phi struct {
@@ -107,8 +121,10 @@ func NewCompiler(pkgName string, config Config) (*Compiler, error) {
 		config.BuildTags = []string{config.GOOS, config.GOARCH}
 	}
 	c := &Compiler{
-		Config:  config,
-		difiles: make(map[string]llvm.Metadata),
+		Config:        config,
+		difiles:       make(map[string]llvm.Metadata),
+		unwindBlocks:  make(map[llvm.Value]llvm.BasicBlock),
+		typeCodeTypes: make(map[string]types.Type),
 	}
 
 	target, err := llvm.GetTargetFromTriple(config.Triple)
@@ -120,9 +136,19 @@ func NewCompiler(pkgName string, config Config) (*Compiler, error) {
 		features = strings.Join(config.Features, `,`)
 	}
 	c.machine = target.CreateTargetMachine(config.Triple, config.CPU, features, llvm.CodeGenLevelDefault, llvm.RelocStatic, llvm.CodeModelDefault)
+	if config.StackSizes {
+		setEmitStackSizeSection(c.machine)
+	}
 	c.targetData = c.machine.CreateTargetData()
 
-	c.ctx = llvm.NewContext()
+	if config.PackageCache != nil {
+		// Bitcode files from the package cache are always loaded in the
+		// global context, and they can only be linked with modules in the
+		// same context.
+		c.ctx = llvm.GlobalContext()
+	} else {
+		c.ctx = llvm.NewContext()
+	}
 	c.mod = c.ctx.NewModule(pkgName)
 	c.mod.SetTarget(config.Triple)
 	c.mod.SetDataLayout(c.targetData.String())
@@ -173,9 +199,15 @@ func (c *Compiler) selectGC() string {
 	return "conservative"
 }
 
-// Compile the given package path or .go file path. Return an error when this
-// fails (in any stage).
-func (c *Compiler) Compile(mainPath string) []error {
+// AllBuildTags returns all build tags that are used to select the source files
+// of packages: the tags of the target plus the tags set by the compiler.
+func (c *Compiler) AllBuildTags() []string {
+	return append([]string{"tinygo", "gc." + c.selectGC()}, c.BuildTags...)
+}
+
+// loadProgram creates a loader for the program and imports the given package
+// path or .go file path and the runtime, without their dependencies.
+func (c *Compiler) loadProgram(mainPath string) (*loader.Program, error) {
 	// Prefix the GOPATH with the system GOROOT, as GOROOT is already set to
 	// the TinyGo root.
 	overlayGopath := c.GOPATH
@@ -187,7 +219,7 @@ func (c *Compiler) Compile(mainPath string) []error {
 
 	wd, err := os.Getwd()
 	if err != nil {
-		return []error{err}
+		return nil, err
 	}
 	lprogram := &loader.Program{
 		Build: &build.Context{
@@ -198,7 +230,7 @@ func (c *Compiler) Compile(mainPath string) []error {
 			CgoEnabled:  true,
 			UseAllFiles: false,
 			Compiler:    "gc", // must be one of the recognized compilers
-			BuildTags:   append([]string{"tinygo", "gc." + c.selectGC()}, c.BuildTags...),
+			BuildTags:   c.AllBuildTags(),
 		},
 		OverlayBuild: &build.Context{
 			GOARCH:      c.GOARCH,
@@ -208,7 +240,7 @@ func (c *Compiler) Compile(mainPath string) []error {
 			CgoEnabled:  true,
 			UseAllFiles: false,
 			Compiler:    "gc", // must be one of the recognized compilers
-			BuildTags:   append([]string{"tinygo", "gc." + c.selectGC()}, c.BuildTags...),
+			BuildTags:   c.AllBuildTags(),
 		},
 		OverlayPath: func(path string) string {
 			// Return the (overlay) import path when it should be overlaid, and
@@ -241,25 +273,56 @@ func (c *Compiler) Compile(mainPath string) []error {
 				MaxAlign: int64(c.targetData.PrefTypeAlignment(c.i8ptrType)),
 			},
 		},
-		Dir:          wd,
-		TINYGOROOT:   c.TINYGOROOT,
-		CFlags:       c.CFlags,
-		ClangHeaders: c.ClangHeaders,
+		Dir:           wd,
+		TINYGOROOT:    c.TINYGOROOT,
+		CFlags:        c.CFlags,
+		ClangHeaders:  c.ClangHeaders,
+		TestVerbose:   c.TestConfig.Verbose,
+		TestRun:       c.TestConfig.Run,
+		TestBench:     c.TestConfig.Bench,
+		TestBenchTime: c.TestConfig.BenchTime,
 	}
 
 	if strings.HasSuffix(mainPath, ".go") {
 		_, err = lprogram.ImportFile(mainPath)
 		if err != nil {
-			return []error{err}
+			return nil, err
 		}
 	} else {
 		_, err = lprogram.Import(mainPath, wd)
 		if err != nil {
-			return []error{err}
+			return nil, err
 		}
 	}
 
 	_, err = lprogram.Import("runtime", "")
+	if err != nil {
+		return nil, err
+	}
+
+	return lprogram, nil
+}
+
+// LoadPackages loads the given package path or .go file path and all the
+// packages it depends on (including the runtime), without parsing them. It
+// returns the main package and all packages, sorted in a way that no package
+// comes before the packages it depends on.
+func (c *Compiler) LoadPackages(mainPath string) (*loader.Package, []*loader.Package, error) {
+	lprogram, err := c.loadProgram(mainPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = lprogram.Load(c.TestConfig.CompileTestBinary)
+	if err != nil {
+		return nil, nil, err
+	}
+	return lprogram.MainPkg(), lprogram.Sorted(), nil
+}
+
+// Compile the given package path or .go file path. Return an error when this
+// fails (in any stage).
+func (c *Compiler) Compile(mainPath string) []error {
+	lprogram, err := c.loadProgram(mainPath)
 	if err != nil {
 		return []error{err}
 	}
@@ -269,6 +332,18 @@ func (c *Compiler) Compile(mainPath string) []error {
 		return []error{err}
 	}
 
+	if c.TrimPath {
+		c.packageDirs = make(map[string]string)
+		for _, pkg := range lprogram.Sorted() {
+			if strings.HasSuffix(pkg.ImportPath, ".go") || strings.HasPrefix(pkg.ImportPath, "_/") {
+				// A .go file or a directory outside GOPATH, which doesn't
+				// have a real import path.
+				continue
+			}
+			c.packageDirs[filepath.Clean(pkg.Package.Dir)] = pkg.ImportPath
+		}
+	}
+
 	c.ir = ir.NewProgram(lprogram, mainPath)
 
 	// Run a simple dead code elimination pass.
@@ -278,40 +353,51 @@ func (c *Compiler) Compile(mainPath string) []error {
 	if c.Debug {
 		c.cu = c.dibuilder.CreateCompileUnit(llvm.DICompileUnit{
 			Language:  0xb, // DW_LANG_C99 (0xc, off-by-one?)
-			File:      mainPath,
+			File:      c.trimPath(mainPath),
 			Dir:       "",
 			Producer:  "TinyGo",
 			Optimized: true,
 		})
 	}
 
-	var frames []*Frame
-
 	c.loadASTComments(lprogram)
 
-	// Declare all functions.
-	for _, f := range c.ir.Functions {
-		frames = append(frames, c.parseFuncDecl(f))
-	}
+	if c.PackageCache != nil {
+		// Compile each package separately, or load it from the cache.
+		err := c.compilePackages()
+		if err != nil {
+			return []error{err}
+		}
+	} else {
+		var frames []*Frame
 
-	// Add definitions to declarations.
-	for _, frame := range frames {
-		if frame.fn.Synthetic == "package initializer" {
-			c.initFuncs = append(c.initFuncs, frame.fn.LLVMFn)
+		// Declare all functions.
+		for _, f := range c.ir.Functions {
+			frames = append(frames, c.parseFuncDecl(f))
 		}
-		if frame.fn.CName() != "" {
-			continue
+
+		// Add definitions to declarations.
+		for _, frame := range frames {
+			if frame.fn.CName() != "" {
+				continue
+			}
+			if frame.fn.Blocks == nil {
+				continue // external function
+			}
+			c.parseFunc(frame)
 		}
-		if frame.fn.Blocks == nil {
-			continue // external function
+
+		// Define the already declared functions that wrap methods for use in
+		// interfaces.
+		for _, state := range c.interfaceInvokeWrappers {
+			c.createInterfaceInvokeWrapper(state)
 		}
-		c.parseFunc(frame)
 	}
 
-	// Define the already declared functions that wrap methods for use in
-	// interfaces.
-	for _, state := range c.interfaceInvokeWrappers {
-		c.createInterfaceInvokeWrapper(state)
+	for _, f := range c.ir.Functions {
+		if f.Synthetic == "package initializer" {
+			c.initFuncs = append(c.initFuncs, f.LLVMFn)
+		}
 	}
 
 	// After all packages are imported, add a synthetic initializer function
@@ -331,6 +417,12 @@ func (c *Compiler) Compile(mainPath string) []error {
 	}
 	c.builder.CreateRetVoid()
 
+	// Insert the checks necessary to unwind the stack after a panic.
+	c.lowerPanics()
+
+	// Decide whether the symbol table is used by the runtime.
+	c.lowerSymtabCheck()
+
 	// Conserve for goroutine lowering. Without marking these as external, they
 	// would be optimized away.
 	realMain := c.mod.NamedFunction(c.ir.MainPkg().Pkg.Path() + ".main")
@@ -343,6 +435,15 @@ func (c *Compiler) Compile(mainPath string) []error {
 	c.mod.NamedFunction("runtime.activateTask").SetLinkage(llvm.ExternalLinkage)
 	c.mod.NamedFunction("runtime.scheduler").SetLinkage(llvm.ExternalLinkage)
 
+	// Keep the reflect side tables alive until they are filled in by the
+	// interface lowering pass. This also makes sure they are not read at
+	// compile time (by interp or by constant propagation) before that.
+	for _, name := range reflectSidetables {
+		if global := c.mod.NamedGlobal(name); !global.IsNil() {
+			global.SetLinkage(llvm.ExternalLinkage)
+		}
+	}
+
 	// Load some attributes
 	getAttr := func(attrName string) llvm.Attribute {
 		attrKind := llvm.AttributeKindID(attrName)
@@ -380,28 +481,38 @@ func (c *Compiler) Compile(mainPath string) []error {
 		fn.AddAttributeAtIndex(2, readonly)
 	}
 
-	// see: https://reviews.llvm.org/D18355
 	if c.Debug {
-		c.mod.AddNamedMetadataOperand("llvm.module.flags",
-			c.ctx.MDNode([]llvm.Metadata{
-				llvm.ConstInt(c.ctx.Int32Type(), 1, false).ConstantAsMetadata(), // Error on mismatch
-				llvm.GlobalContext().MDString("Debug Info Version"),
-				llvm.ConstInt(c.ctx.Int32Type(), 3, false).ConstantAsMetadata(), // DWARF version
-			}),
-		)
-		c.mod.AddNamedMetadataOperand("llvm.module.flags",
-			c.ctx.MDNode([]llvm.Metadata{
-				llvm.ConstInt(c.ctx.Int32Type(), 1, false).ConstantAsMetadata(),
-				llvm.GlobalContext().MDString("Dwarf Version"),
-				llvm.ConstInt(c.ctx.Int32Type(), 4, false).ConstantAsMetadata(),
-			}),
-		)
+		// With incremental compilation, the module flags have already been
+		// added before linking the package modules.
+		if c.PackageCache == nil {
+			c.addDebugInfoFlags()
+		}
 		c.dibuilder.Finalize()
 	}
 
 	return c.diagnostics
 }
 
+// addDebugInfoFlags adds the module flags that are necessary for the debug
+// information in the current module to be used.
+// see: https://reviews.llvm.org/D18355
+func (c *Compiler) addDebugInfoFlags() {
+	c.mod.AddNamedMetadataOperand("llvm.module.flags",
+		c.ctx.MDNode([]llvm.Metadata{
+			llvm.ConstInt(c.ctx.Int32Type(), 1, false).ConstantAsMetadata(), // Error on mismatch
+			c.ctx.MDString("Debug Info Version"),
+			llvm.ConstInt(c.ctx.Int32Type(), 3, false).ConstantAsMetadata(), // DWARF version
+		}),
+	)
+	c.mod.AddNamedMetadataOperand("llvm.module.flags",
+		c.ctx.MDNode([]llvm.Metadata{
+			llvm.ConstInt(c.ctx.Int32Type(), 1, false).ConstantAsMetadata(),
+			c.ctx.MDString("Dwarf Version"),
+			llvm.ConstInt(c.ctx.Int32Type(), 4, false).ConstantAsMetadata(),
+		}),
+	)
+}
+
 // getRuntimeType obtains a named type from the runtime package and returns it
 // as a Go type.
 func (c *Compiler) getRuntimeType(name string) types.Type {
@@ -810,7 +921,7 @@ func (c *Compiler) attachDebugInfo(f *ir.Function) llvm.Metadata {
 
 func (c *Compiler) attachDebugInfoRaw(f *ir.Function, llvmFn llvm.Value, suffix, filename string, line int) llvm.Metadata {
 	if _, ok := c.difiles[filename]; !ok {
-		dir, file := filepath.Split(filename)
+		dir, file := filepath.Split(c.trimPath(filename))
 		if dir != "" {
 			dir = dir[:len(dir)-1]
 		}
@@ -840,9 +951,31 @@ func (c *Compiler) attachDebugInfoRaw(f *ir.Function, llvmFn llvm.Value, suffix,
 		Optimized:    true,
 	})
 	llvmFn.SetSubprogram(difunc)
+	if c.hasSymtab() {
+		c.setSymtabEntry(llvmFn, symtabEntry{
+			name: f.RelString(nil) + suffix,
+			file: c.trimPath(filename),
+			line: line,
+		})
+	}
 	return difunc
 }
 
+// trimPath returns the file name to store in the debug information and the
+// symbol table. With TrimPath, the directory of a source file is replaced with
+// the import path of its package (or removed if it isn't part of a package), so
+// that the output doesn't depend on where the source code is stored.
+func (c *Compiler) trimPath(filename string) string {
+	if !c.TrimPath || filename == "" {
+		return filename
+	}
+	dir, file := filepath.Split(filename)
+	if importPath, ok := c.packageDirs[filepath.Clean(dir)]; ok {
+		return importPath + "/" + file
+	}
+	return file
+}
+
 func (c *Compiler) parseFunc(frame *Frame) {
 	if c.DumpSSA {
 		fmt.Printf("\nfunc %s:\n", frame.fn.Function)
@@ -852,7 +985,11 @@ func (c *Compiler) parseFunc(frame *Frame) {
 		return
 	}
 	if !frame.fn.IsExported() {
-		frame.fn.LLVMFn.SetLinkage(llvm.InternalLinkage)
+		if c.PackageCache == nil {
+			// With incremental compilation, other package modules may call
+			// this function. It is made internal after linking them.
+			frame.fn.LLVMFn.SetLinkage(llvm.InternalLinkage)
+		}
 		frame.fn.LLVMFn.SetUnnamedAddr(true)
 	}
 	if frame.fn.IsInterrupt() && strings.HasPrefix(c.Triple, "avr") {
@@ -1000,6 +1137,9 @@ func (c *Compiler) parseFunc(frame *Frame) {
 			phi.llvm.AddIncoming([]llvm.Value{llvmVal}, []llvm.BasicBlock{llvmBlock})
 		}
 	}
+
+	// Add the block that is used when unwinding the stack after a panic.
+	c.emitUnwindBlock(frame)
 }
 
 func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) {
@@ -1030,36 +1170,7 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) {
 	case *ssa.Defer:
 		c.emitDefer(frame, instr)
 	case *ssa.Go:
-		if instr.Call.IsInvoke() {
-			c.addError(instr.Pos(), "todo: go on method receiver")
-			return
-		}
-		callee := instr.Call.StaticCallee()
-		if callee == nil {
-			c.addError(instr.Pos(), "todo: go on non-direct function (function pointer, etc.)")
-			return
-		}
-		calleeFn := c.ir.GetFunction(callee)
-
-		// Mark this function as a 'go' invocation and break invalid
-		// interprocedural optimizations. For example, heap-to-stack
-		// transformations are not sound as goroutines can outlive their parent.
-		calleeType := calleeFn.LLVMFn.Type()
-		calleeValue := c.builder.CreateBitCast(calleeFn.LLVMFn, c.i8ptrType, "")
-		calleeValue = c.createRuntimeCall("makeGoroutine", []llvm.Value{calleeValue}, "")
-		calleeValue = c.builder.CreateBitCast(calleeValue, calleeType, "")
-
-		// Get all function parameters to pass to the goroutine.
-		var params []llvm.Value
-		for _, param := range instr.Call.Args {
-			params = append(params, c.getValue(frame, param))
-		}
-		if !calleeFn.IsExported() {
-			params = append(params, llvm.Undef(c.i8ptrType)) // context parameter
-			params = append(params, llvm.Undef(c.i8ptrType)) // parent coroutine handle
-		}
-
-		c.createCall(calleeValue, params, "")
+		c.emitGo(frame, instr)
 	case *ssa.If:
 		cond := c.getValue(frame, instr.Cond)
 		block := instr.Block()
@@ -1094,7 +1205,7 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) {
 			c.builder.CreateRet(retVal)
 		}
 	case *ssa.RunDefers:
-		c.emitRunDefers(frame)
+		c.emitRunDefers(frame, llvm.Value{})
 	case *ssa.Send:
 		c.emitChanSend(frame, instr)
 	case *ssa.Store:
@@ -1125,7 +1236,8 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string,
 		elemsLen := c.builder.CreateExtractValue(elems, 1, "append.elemsLen")
 		elemType := srcBuf.Type().ElementType()
 		elemSize := llvm.ConstInt(c.uintptrType, c.targetData.TypeAllocSize(elemType), false)
-		result := c.createRuntimeCall("sliceAppend", []llvm.Value{srcPtr, elemsPtr, srcLen, srcCap, elemsLen, elemSize}, "append.new")
+		elemLayout := c.getObjectLayout(elemType)
+		result := c.createRuntimeCall("sliceAppend", []llvm.Value{srcPtr, elemsPtr, srcLen, srcCap, elemsLen, elemSize, elemLayout}, "append.new")
 		newPtr := c.builder.CreateExtractValue(result, 0, "append.newPtr")
 		newBuf := c.builder.CreateBitCast(newPtr, srcBuf.Type(), "append.newBuf")
 		newLen := c.builder.CreateExtractValue(result, 1, "append.newLen")
@@ -1140,9 +1252,7 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string,
 		var llvmCap llvm.Value
 		switch args[0].Type().(type) {
 		case *types.Chan:
-			// Channel. Buffered channels haven't been implemented yet so always
-			// return 0.
-			llvmCap = llvm.ConstInt(c.intType, 0, false)
+			llvmCap = c.createRuntimeCall("chanCap", []llvm.Value{value}, "cap")
 		case *types.Slice:
 			llvmCap = c.builder.CreateExtractValue(value, 2, "cap")
 		default:
@@ -1198,9 +1308,7 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string,
 			// string or slice
 			llvmLen = c.builder.CreateExtractValue(value, 1, "len")
 		case *types.Chan:
-			// Channel. Buffered channels haven't been implemented yet so always
-			// return 0.
-			llvmLen = llvm.ConstInt(c.intType, 0, false)
+			llvmLen = c.createRuntimeCall("chanLen", []llvm.Value{value}, "len")
 		case *types.Map:
 			llvmLen = c.createRuntimeCall("hashmapLen", []llvm.Value{value}, "len")
 		default:
@@ -1271,7 +1379,7 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string,
 		cplx := c.getValue(frame, args[0])
 		return c.builder.CreateExtractValue(cplx, 0, "real"), nil
 	case "recover":
-		return c.createRuntimeCall("_recover", nil, ""), nil
+		return c.emitRecover(frame), nil
 	case "ssa:wrapnilchk":
 		// TODO: do an actual nil check?
 		return c.getValue(frame, args[0]), nil
@@ -1301,6 +1409,7 @@ func (c *Compiler) parseFunctionCall(frame *Frame, args []ssa.Value, llvmFn, con
 func (c *Compiler) parseCall(frame *Frame, instr *ssa.CallCommon) (llvm.Value, error) {
 	if instr.IsInvoke() {
 		fnCast, args := c.getInvokeCall(frame, instr)
+		c.emitForwardDeferredCall(frame, fnCast)
 		return c.createCall(fnCast, args, ""), nil
 	}
 
@@ -1322,6 +1431,10 @@ func (c *Compiler) parseCall(frame *Frame, instr *ssa.CallCommon) (llvm.Value, e
 			return c.emitVolatileLoad(frame, instr)
 		case strings.HasPrefix(name, "runtime/volatile.Store"):
 			return c.emitVolatileStore(frame, instr)
+		case strings.HasPrefix(name, "sync/atomic."):
+			if val, ok := c.emitAtomic(frame, name[len("sync/atomic."):], instr); ok {
+				return val, nil
+			}
 		}
 
 		targetFunc := c.ir.GetFunction(fn)
@@ -1341,6 +1454,7 @@ func (c *Compiler) parseCall(frame *Frame, instr *ssa.CallCommon) (llvm.Value, e
 		default:
 			panic("StaticCallee returned an unexpected value")
 		}
+		c.emitForwardDeferredCall(frame, targetFunc.LLVMFn)
 		return c.parseFunctionCall(frame, instr.Args, targetFunc.LLVMFn, context, targetFunc.IsExported()), nil
 	}
 
@@ -1408,7 +1522,8 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 				return llvm.Value{}, c.makeError(expr.Pos(), fmt.Sprintf("value is too big (%v bytes)", size))
 			}
 			sizeValue := llvm.ConstInt(c.uintptrType, size, false)
-			buf := c.createRuntimeCall("alloc", []llvm.Value{sizeValue}, expr.Comment)
+			layoutValue := c.getObjectLayout(typ)
+			buf := c.createRuntimeCall("alloc", []llvm.Value{sizeValue, layoutValue}, expr.Comment)
 			buf = c.builder.CreateBitCast(buf, llvm.PointerType(typ, 0), "")
 			return buf, nil
 		} else {
@@ -1607,7 +1722,7 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 			panic("unknown lookup type: " + expr.String())
 		}
 	case *ssa.MakeChan:
-		return c.emitMakeChan(expr)
+		return c.emitMakeChan(frame, expr)
 	case *ssa.MakeClosure:
 		return c.parseMakeClosure(frame, expr)
 	case *ssa.MakeInterface:
@@ -1658,7 +1773,8 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 			return llvm.Value{}, err
 		}
 		sliceSize := c.builder.CreateBinOp(llvm.Mul, elemSizeValue, sliceCapCast, "makeslice.cap")
-		slicePtr := c.createRuntimeCall("alloc", []llvm.Value{sliceSize}, "makeslice.buf")
+		layoutValue := c.getObjectLayout(llvmElemType)
+		slicePtr := c.createRuntimeCall("alloc", []llvm.Value{sliceSize, layoutValue}, "makeslice.buf")
 		slicePtr = c.builder.CreateBitCast(slicePtr, llvm.PointerType(llvmElemType, 0), "makeslice.array")
 
 		// Extend or truncate if necessary. This is safe as we've already done
@@ -2702,7 +2818,12 @@ func (c *Compiler) EmitObject(path string) error {
 	if err != nil {
 		return err
 	}
-	return c.writeFile(llvmBuf.Bytes(), path)
+	data := llvmBuf.Bytes()
+	err = c.patchSymtabSizes(data)
+	if err != nil {
+		return err
+	}
+	return c.writeFile(data, path)
 }
 
 // Emit LLVM bitcode file (.bc).
@@ -56,10 +56,11 @@ func (c *Compiler) emitDefer(frame *Frame, instr *ssa.Defer) {
 
 		// Collect all values to be put in the struct (starting with
 		// runtime._defer fields, followed by the call parameters).
+		// The whole interface is stored (not just the receiver) as the
+		// typecode is needed to find the method to call.
 		itf := c.getValue(frame, instr.Call.Value) // interface
-		receiverValue := c.builder.CreateExtractValue(itf, 1, "invoke.func.receiver")
-		values = []llvm.Value{callback, next, receiverValue}
-		valueTypes = append(valueTypes, c.i8ptrType)
+		values = []llvm.Value{callback, next, itf}
+		valueTypes = append(valueTypes, itf.Type())
 		for _, arg := range instr.Call.Args {
 			val := c.getValue(frame, arg)
 			values = append(values, val)
@@ -139,8 +140,11 @@ func (c *Compiler) emitDefer(frame *Frame, instr *ssa.Defer) {
 	c.builder.CreateStore(allocaCast, frame.deferPtr)
 }
 
-// emitRunDefers emits code to run all deferred functions.
-func (c *Compiler) emitRunDefers(frame *Frame) {
+// emitRunDefers emits code to run all deferred functions. When called from a
+// landing pad, record is the runtime.panicRecord of the panic and each deferred
+// call is registered in it before the call, so that recover() can check it is
+// called directly by a deferred function. Otherwise, record is nil.
+func (c *Compiler) emitRunDefers(frame *Frame, record llvm.Value) {
 	// Add a loop like the following:
 	//     for stack != nil {
 	//         _stack := stack
@@ -203,17 +207,20 @@ func (c *Compiler) emitRunDefers(frame *Frame) {
 			}
 
 			// Get the real defer struct type and cast to it.
-			valueTypes := []llvm.Type{c.uintptrType, llvm.PointerType(c.getLLVMRuntimeType("_defer"), 0), c.i8ptrType}
+			valueTypes := []llvm.Type{c.uintptrType, llvm.PointerType(c.getLLVMRuntimeType("_defer"), 0), c.getLLVMType(callback.Value.Type())}
 			for _, arg := range callback.Args {
 				valueTypes = append(valueTypes, c.getLLVMType(arg.Type()))
 			}
 			deferFrameType := c.ctx.StructType(valueTypes, false)
 			deferFramePtr := c.builder.CreateBitCast(deferData, llvm.PointerType(deferFrameType, 0), "deferFrame")
 
-			// Extract the params from the struct (including receiver).
-			forwardParams := []llvm.Value{}
+			// Extract the interface and the params from the struct.
 			zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
-			for i := 2; i < len(valueTypes); i++ {
+			itfGEP := c.builder.CreateInBoundsGEP(deferFramePtr, []llvm.Value{zero, llvm.ConstInt(c.ctx.Int32Type(), 2, false)}, "gep")
+			itf := c.builder.CreateLoad(itfGEP, "itf")
+			fnPtr, receiverValue := c.getInvokeFunc(itf, callback)
+			forwardParams := []llvm.Value{receiverValue}
+			for i := 3; i < len(valueTypes); i++ {
 				gep := c.builder.CreateInBoundsGEP(deferFramePtr, []llvm.Value{zero, llvm.ConstInt(c.ctx.Int32Type(), uint64(i), false)}, "gep")
 				forwardParam := c.builder.CreateLoad(gep, "param")
 				forwardParams = append(forwardParams, forwardParam)
@@ -227,7 +234,9 @@ func (c *Compiler) emitRunDefers(frame *Frame) {
 			// Parent coroutine handle.
 			forwardParams = append(forwardParams, llvm.Undef(c.i8ptrType))
 
-			fnPtr, _ := c.getInvokeCall(frame, callback)
+			if !record.IsNil() {
+				c.createRuntimeCall("startDeferredCall", []llvm.Value{record, c.deferredCallID(fnPtr)}, "")
+			}
 			c.createCall(fnPtr, forwardParams, "")
 
 		case *ir.Function:
@@ -258,6 +267,9 @@ func (c *Compiler) emitRunDefers(frame *Frame) {
 			forwardParams = append(forwardParams, llvm.Undef(c.i8ptrType))
 
 			// Call real function.
+			if !record.IsNil() {
+				c.createRuntimeCall("startDeferredCall", []llvm.Value{record, c.deferredCallID(callback.LLVMFn)}, "")
+			}
 			c.createCall(callback.LLVMFn, forwardParams, "")
 
 		case *ssa.MakeClosure:
@@ -285,6 +297,9 @@ func (c *Compiler) emitRunDefers(frame *Frame) {
 			forwardParams = append(forwardParams, llvm.Undef(c.i8ptrType))
 
 			// Call deferred function.
+			if !record.IsNil() {
+				c.createRuntimeCall("startDeferredCall", []llvm.Value{record, c.deferredCallID(fn.LLVMFn)}, "")
+			}
 			c.createCall(fn.LLVMFn, forwardParams, "")
 
 		default:
@@ -223,7 +223,13 @@ func (c *Compiler) LowerFuncValues() {
 				nilBlock := llvm.InsertBasicBlock(nextBlock, "func.nil")
 				c.builder.SetInsertPointAtEnd(nilBlock)
 				c.createRuntimeCall("nilPanic", nil, "")
-				c.builder.CreateUnreachable()
+				if c.panicsMayReturn() {
+					// Continue in the next block, which starts unwinding the
+					// stack right after the call. See panic.go.
+					c.builder.CreateBr(nextBlock)
+				} else {
+					c.builder.CreateUnreachable()
+				}
 				sw.AddCase(llvm.ConstInt(c.uintptrType, 0, false), nilBlock)
 
 				// Gather the list of parameters for every call we're going to
@@ -251,6 +257,10 @@ func (c *Compiler) LowerFuncValues() {
 				// next block (after the split). This is only necessary when the
 				// call produced a value.
 				if funcCall.Type().TypeKind() != llvm.VoidTypeKind {
+					if c.panicsMayReturn() {
+						phiBlocks = append(phiBlocks, nilBlock)
+						phiValues = append(phiValues, llvm.Undef(funcCall.Type()))
+					}
 					c.builder.SetInsertPointBefore(nextBlock.FirstInstruction())
 					phi := c.builder.CreatePHI(funcCall.Type(), "")
 					phi.AddIncoming(phiValues, phiBlocks)
@@ -60,7 +60,7 @@ func (c *Compiler) createFuncValue(funcPtr, context llvm.Value, sig *types.Signa
 			funcValueWithSignatureGlobal = llvm.AddGlobal(c.mod, funcValueWithSignatureType, funcValueWithSignatureGlobalName)
 			funcValueWithSignatureGlobal.SetInitializer(funcValueWithSignature)
 			funcValueWithSignatureGlobal.SetGlobalConstant(true)
-			funcValueWithSignatureGlobal.SetLinkage(llvm.InternalLinkage)
+			c.setHelperLinkage(funcValueWithSignatureGlobal, llvm.InternalLinkage)
 		}
 		funcValueScalar = llvm.ConstPtrToInt(funcValueWithSignatureGlobal, c.uintptrType)
 	default:
@@ -84,7 +84,7 @@ func (c *Compiler) getFuncSignature(sig *types.Signature) llvm.Value {
 		sigGlobal = llvm.AddGlobal(c.mod, c.ctx.Int8Type(), sigGlobalName)
 		sigGlobal.SetInitializer(llvm.Undef(c.ctx.Int8Type()))
 		sigGlobal.SetGlobalConstant(true)
-		sigGlobal.SetLinkage(llvm.InternalLinkage)
+		c.setHelperLinkage(sigGlobal, llvm.InternalLinkage)
 	}
 	return sigGlobal
 }
@@ -4,6 +4,7 @@ package compiler
 // garbage collectors.
 
 import (
+	"fmt"
 	"go/token"
 	"math/big"
 
@@ -448,6 +449,66 @@ func (c *Compiler) getPointerBitmap(typ llvm.Type, name string) *big.Int {
 	}
 }
 
+// getObjectLayout returns the layout of a heap object of the given type (or of
+// an array of this type) as an uintptr value, to be passed to runtime.alloc. See
+// src/runtime/layout.go for a description of the format.
+func (c *Compiler) getObjectLayout(t llvm.Type) llvm.Value {
+	ptrSize := uint64(c.targetData.PointerSize())
+	if uint64(c.targetData.PrefTypeAlignment(c.i8ptrType)) != ptrSize {
+		// Pointers may be stored at any offset (for example on AVR), so a
+		// layout cannot be described in words.
+		return llvm.ConstInt(c.uintptrType, 0, false)
+	}
+
+	// An array repeats the layout of its element type, so describing the
+	// element type is enough.
+	for t.TypeKind() == llvm.ArrayTypeKind && c.targetData.TypeAllocSize(t.ElementType())%ptrSize == 0 {
+		t = t.ElementType()
+	}
+
+	bitmap := c.getPointerBitmap(t, "heap object")
+	if bitmap.BitLen() == 0 {
+		// No pointers: a single word without pointer.
+		return llvm.ConstInt(c.uintptrType, 1<<1|1, false)
+	}
+	size := c.targetData.TypeAllocSize(t)
+	if size%ptrSize != 0 {
+		// This should not happen: a type with pointers is always a multiple
+		// of the pointer size. Fall back to a conservative scan.
+		return llvm.ConstInt(c.uintptrType, 0, false)
+	}
+	words := size / ptrSize
+
+	// Store the layout directly in the value if it fits.
+	sizeBits := 4 + ptrSize/4
+	if words < 1<<sizeBits && words <= ptrSize*8-1-sizeBits {
+		layout := 1 | words<<1 | bitmap.Uint64()<<(1+sizeBits)
+		return llvm.ConstInt(c.uintptrType, layout, false)
+	}
+
+	// Otherwise, store the layout in a global. Equal layouts share a global.
+	name := fmt.Sprintf("runtime/gc.layout:%d-%s", words, bitmap.Text(16))
+	global := c.mod.NamedGlobal(name)
+	if global.IsNil() {
+		bitmapValues := make([]llvm.Value, (words+7)/8)
+		for i := range bitmapValues {
+			b := new(big.Int).Rsh(bitmap, uint(i*8)).Uint64() & 0xff
+			bitmapValues[i] = llvm.ConstInt(c.ctx.Int8Type(), b, false)
+		}
+		initializer := c.ctx.ConstStruct([]llvm.Value{
+			llvm.ConstInt(c.uintptrType, words, false),
+			llvm.ConstArray(c.ctx.Int8Type(), bitmapValues),
+		}, false)
+		global = llvm.AddGlobal(c.mod, initializer.Type(), name)
+		global.SetInitializer(initializer)
+		c.setHelperLinkage(global, llvm.InternalLinkage)
+		global.SetGlobalConstant(true)
+		global.SetUnnamedAddr(true)
+		global.SetAlignment(int(ptrSize))
+	}
+	return llvm.ConstPtrToInt(global, c.uintptrType)
+}
+
 // markParentFunctions traverses all parent function calls (recursively) and
 // adds them to the set of marked functions. It only considers function calls:
 // any other uses of such a function is ignored.
@@ -88,6 +88,16 @@ package compiler
 //
 // The real LLVM code is more complicated, but this is the general idea.
 //
+// Note that runtime.makeGoroutine is always called with a known function. Go
+// statements on interface methods and func values are wrapped in a new function
+// during IR construction (see goroutine.go) that does the dynamic call, and
+// this wrapper is started as a goroutine instead. When a func value refers to a
+// blocking function, the wrapper calls it without a parent coroutine so that it
+// continues as an independent goroutine when it blocks. Other calls through a
+// function pointer that may call a blocking function are turned into a direct
+// call of that function when the pointer refers to it, which is then awaited
+// like any other blocking call (see lowerAsyncFuncValueCalls).
+//
 // The LLVM coroutine passes will then process this file further transforming
 // these three functions into coroutines. Most of the actual work is done by the
 // scheduler, which runs in the background scheduling all coroutines.
@@ -110,6 +120,12 @@ type asyncFunc struct {
 // into one where all blocking functions are turned into goroutines and blocking
 // calls into await calls.
 func (c *Compiler) LowerGoroutines() error {
+	// Call main.main through a wrapper, so that the scheduler knows when it
+	// has returned. The wrapper must exist before looking for async functions,
+	// so that it becomes async when main.main is.
+	realMain := c.mod.NamedFunction(c.ir.MainPkg().Pkg.Path() + ".main")
+	mainWrapper := c.createMainWrapper(realMain)
+
 	needsScheduler, err := c.markAsyncFunctions()
 	if err != nil {
 		return err
@@ -121,11 +137,10 @@ func (c *Compiler) LowerGoroutines() error {
 	}
 	mainCall := uses[0]
 
-	// Replace call of runtime.callMain() with a real call to main.main(),
-	// optionally followed by a call to runtime.scheduler().
+	// Replace call of runtime.callMain() with a call to main.main() (through
+	// its wrapper), optionally followed by a call to runtime.scheduler().
 	c.builder.SetInsertPointBefore(mainCall)
-	realMain := c.mod.NamedFunction(c.ir.MainPkg().Pkg.Path() + ".main")
-	c.builder.CreateCall(realMain, []llvm.Value{llvm.Undef(c.i8ptrType), llvm.ConstPointerNull(c.i8ptrType)}, "")
+	c.builder.CreateCall(mainWrapper, []llvm.Value{llvm.Undef(c.i8ptrType), llvm.ConstPointerNull(c.i8ptrType)}, "")
 	if needsScheduler {
 		c.createRuntimeCall("scheduler", nil, "")
 	}
@@ -154,6 +169,25 @@ func (c *Compiler) LowerGoroutines() error {
 	return nil
 }
 
+// createMainWrapper creates runtime.runMain, which calls main.main and then
+// sets runtime.mainExited. Active timers only keep the scheduler running until
+// then.
+func (c *Compiler) createMainWrapper(realMain llvm.Value) llvm.Value {
+	fn := llvm.AddFunction(c.mod, "runtime.runMain", realMain.Type().ElementType())
+	fn.SetLinkage(llvm.InternalLinkage)
+	fn.Param(0).SetName("context")
+	fn.Param(1).SetName("parentHandle")
+	entry := c.ctx.AddBasicBlock(fn, "entry")
+	c.builder.SetInsertPointAtEnd(entry)
+	c.builder.SetCurrentDebugLocation(0, 0, llvm.Metadata{}, llvm.Metadata{})
+	c.builder.CreateCall(realMain, []llvm.Value{fn.Param(0), fn.Param(1)}, "")
+	if mainExited := c.mod.NamedGlobal("runtime.mainExited"); !mainExited.IsNil() {
+		c.builder.CreateStore(llvm.ConstInt(c.ctx.Int1Type(), 1, false), mainExited)
+	}
+	c.builder.CreateRetVoid()
+	return fn
+}
+
 // markAsyncFunctions does the bulk of the work of lowering goroutines. It
 // determines whether a scheduler is needed, and if it is, it transforms
 // blocking operations into goroutines and blocking calls into await calls.
@@ -185,6 +219,10 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 	if !chanRecv.IsNil() {
 		worklist = append(worklist, chanRecv)
 	}
+	parkTask := c.mod.NamedFunction("runtime.parkTask")
+	if !parkTask.IsNil() {
+		worklist = append(worklist, parkTask)
+	}
 
 	if len(worklist) == 0 {
 		// There are no blocking operations, so no need to transform anything.
@@ -198,6 +236,7 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 	// the work items are then grey objects.
 	asyncFuncs := make(map[llvm.Value]*asyncFunc)
 	asyncList := make([]llvm.Value, 0, 4)
+	var asyncFuncValues []llvm.Value // async functions that are used as func value
 	for len(worklist) != 0 {
 		// Pick the topmost.
 		f := worklist[len(worklist)-1]
@@ -222,11 +261,13 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 				// starting a goroutine is not a blocking operation.
 				continue
 			}
-			if use.IsACallInst().IsNil() {
-				// Not a call instruction. Maybe a store to a global? In any
-				// case, this requires support for async calls across function
-				// pointers which is not yet supported.
-				return false, errors.New("async function " + f.Name() + " used as function pointer")
+			if use.IsACallInst().IsNil() || use.CalledValue() != f {
+				// Not a call of this function. It is most likely used in a
+				// func value, which is handled below.
+				if len(asyncFuncValues) == 0 || asyncFuncValues[len(asyncFuncValues)-1] != f {
+					asyncFuncValues = append(asyncFuncValues, f)
+				}
+				continue
 			}
 			parent := use.InstructionParent().Parent()
 			for i := 0; i < use.OperandsCount()-1; i++ {
@@ -236,10 +277,26 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 			}
 			worklist = append(worklist, parent)
 		}
+
+		// The function may be called through a func value, which the caller
+		// must then await. Make these calls direct calls of this function
+		// when the function pointer refers to it, so that the functions
+		// doing these calls become async as well.
+		if len(asyncFuncValues) != 0 && asyncFuncValues[len(asyncFuncValues)-1] == f {
+			for _, call := range c.lowerAsyncFuncValueCalls(f) {
+				worklist = append(worklist, call.InstructionParent().Parent())
+			}
+		}
 	}
 
-	// Check whether a scheduler is needed.
+	// Remember which functions are started as a goroutine, before the
+	// go statements are lowered.
 	makeGoroutine := c.mod.NamedFunction("runtime.makeGoroutine")
+	for _, use := range getUses(makeGoroutine) {
+		c.goroutineStarts = append(c.goroutineStarts, use.Operand(0).Operand(0).Name())
+	}
+
+	// Check whether a scheduler is needed.
 	if c.GOOS == "js" && strings.HasPrefix(c.Triple, "wasm") {
 		// JavaScript always needs a scheduler, as in general no blocking
 		// operations are possible. Blocking operations block the browser UI,
@@ -261,12 +318,27 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 				break
 			}
 		}
+
+		// An async function used as func value may be started as a goroutine
+		// by the wrapper of a go statement.
+		if len(asyncFuncValues) != 0 {
+			needsScheduler = true
+		}
+
+		// Timers are run by the scheduler, so a program that starts timers
+		// (for example with time.NewTimer) and blocks needs one, even when it
+		// does not start any goroutines.
+		startTimer := c.mod.NamedFunction("time.startTimer")
+		if !startTimer.IsNil() && len(getUses(startTimer)) != 0 {
+			needsScheduler = true
+		}
 	}
 
 	if !needsScheduler {
 		// No scheduler is needed. Do not transform all functions here.
 		// However, make sure that all go calls (which are all non-async) are
 		// transformed into regular calls.
+		c.lowerParkTaskWithoutScheduler()
 		return false, c.lowerMakeGoroutineCalls()
 	}
 
@@ -292,7 +364,7 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 
 	// Transform all async functions into coroutines.
 	for _, f := range asyncList {
-		if f == sleep || f == deadlockStub || f == chanSend || f == chanRecv {
+		if f == sleep || f == deadlockStub || f == chanSend || f == chanRecv || f == parkTask {
 			continue
 		}
 
@@ -309,7 +381,7 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 			for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
 				if !inst.IsACallInst().IsNil() {
 					callee := inst.CalledValue()
-					if _, ok := asyncFuncs[callee]; !ok || callee == sleep || callee == deadlockStub || callee == chanSend || callee == chanRecv {
+					if _, ok := asyncFuncs[callee]; !ok || callee == sleep || callee == deadlockStub || callee == chanSend || callee == chanRecv || callee == parkTask {
 						continue
 					}
 					asyncCalls = append(asyncCalls, inst)
@@ -335,7 +407,10 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 		} else if c.targetData.TypeAllocSize(size.Type()) < c.targetData.TypeAllocSize(c.uintptrType) {
 			size = c.builder.CreateZExt(size, c.uintptrType, "task.size.uintptr")
 		}
-		data := c.createRuntimeCall("alloc", []llvm.Value{size}, "task.data")
+		// The layout of the coroutine frame is only known to LLVM, so it must
+		// be scanned conservatively.
+		layoutValue := llvm.ConstInt(c.uintptrType, 0, false)
+		data := c.createRuntimeCall("alloc", []llvm.Value{size, layoutValue}, "task.data")
 		if c.needsStackObjects() {
 			c.trackPointer(data)
 		}
@@ -494,6 +569,26 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 		sleepCall.EraseFromParentAsInstruction()
 	}
 
+	// Transform calls to runtime.parkTask into coroutine suspends. The task is
+	// resumed when another task activates it again.
+	for _, parkCall := range getUses(parkTask) {
+		// parkCall must be a call instruction.
+		frame := asyncFuncs[parkCall.InstructionParent().Parent()]
+
+		// Yield to scheduler.
+		c.builder.SetInsertPointBefore(parkCall)
+		continuePoint := c.builder.CreateCall(coroSuspendFunc, []llvm.Value{
+			llvm.ConstNull(c.ctx.TokenType()),
+			llvm.ConstInt(c.ctx.Int1Type(), 0, false),
+		}, "")
+		wakeup := c.splitBasicBlock(parkCall, llvm.NextBasicBlock(c.builder.GetInsertBlock()), "task.unpark")
+		c.builder.SetInsertPointBefore(parkCall)
+		sw := c.builder.CreateSwitch(continuePoint, frame.suspendBlock, 2)
+		sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 0, false), wakeup)
+		sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 1, false), frame.cleanupBlock)
+		parkCall.EraseFromParentAsInstruction()
+	}
+
 	// Transform calls to runtime.deadlockStub into coroutine suspends (without
 	// resume).
 	for _, deadlockCall := range getUses(deadlockStub) {
@@ -552,6 +647,106 @@ func (c *Compiler) markAsyncFunctions() (needsScheduler bool, err error) {
 	return true, c.lowerMakeGoroutineCalls()
 }
 
+// lowerAsyncFuncValueCalls changes all calls through a function pointer that
+// may call the async function f into a check whether the function pointer
+// refers to f. If it does, f is called directly, so that the call can be
+// awaited like other async calls. Otherwise, the function pointer is called as
+// before. It returns the new direct calls of f.
+//
+// Calls in the wrapper of a go statement (see createGoroutineWrapper) are left
+// as they are, as they start the callee as a new goroutine and do not wait for
+// it. The same goes for the calls of go statements themselves, which are
+// lowered later in lowerMakeGoroutineCalls.
+func (c *Compiler) lowerAsyncFuncValueCalls(f llvm.Value) []llvm.Value {
+	var calls []llvm.Value
+	for fn := c.mod.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+		if strings.HasSuffix(fn.Name(), "$gowrapper") {
+			continue
+		}
+		for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+			for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+				if inst.IsACallInst().IsNil() {
+					continue
+				}
+				callee := inst.CalledValue()
+				if callee.IsConstant() || !callee.IsAInlineAsm().IsNil() || callee.Type() != f.Type() {
+					continue
+				}
+				if !callee.IsABitCastInst().IsNil() {
+					start := callee.Operand(0)
+					if !start.IsACallInst().IsNil() && start.CalledValue().Name() == "runtime.makeGoroutine" {
+						continue
+					}
+				}
+				calls = append(calls, inst)
+			}
+		}
+	}
+
+	var directCalls []llvm.Value
+	for _, call := range calls {
+		fn := call.InstructionParent().Parent()
+		var params []llvm.Value
+		for i := 0; i < call.OperandsCount()-1; i++ {
+			params = append(params, call.Operand(i))
+		}
+
+		// Split the basic block after the call. The new blocks are added at
+		// the end of the function, so that there always is a block to insert
+		// the continuation before.
+		asyncBlock := c.ctx.AddBasicBlock(fn, "funcvalue.async")
+		otherBlock := c.ctx.AddBasicBlock(fn, "funcvalue.other")
+		nextBlock := c.splitBasicBlock(call, asyncBlock, "funcvalue.next")
+
+		// Setting the insert point before the call also takes its debug
+		// location, which is used for all new instructions.
+		c.builder.SetInsertPointBefore(call)
+		callee := call.CalledValue()
+		isAsync := c.builder.CreateICmp(llvm.IntEQ, callee, f, "funcvalue.isasync")
+		c.builder.CreateCondBr(isAsync, asyncBlock, otherBlock)
+
+		c.builder.SetInsertPointAtEnd(asyncBlock)
+		directCall := c.builder.CreateCall(f, params, "")
+		c.builder.CreateBr(nextBlock)
+		c.builder.SetInsertPointAtEnd(otherBlock)
+		otherCall := c.builder.CreateCall(callee, params, "")
+		c.builder.CreateBr(nextBlock)
+
+		if call.Type().TypeKind() != llvm.VoidTypeKind {
+			c.builder.SetInsertPointBefore(nextBlock.FirstInstruction())
+			result := c.builder.CreatePHI(call.Type(), "")
+			result.AddIncoming([]llvm.Value{directCall, otherCall}, []llvm.BasicBlock{asyncBlock, otherBlock})
+			call.ReplaceAllUsesWith(result)
+		}
+		call.EraseFromParentAsInstruction()
+		directCalls = append(directCalls, directCall)
+	}
+	return directCalls
+}
+
+// lowerParkTaskWithoutScheduler replaces calls to runtime.parkTask with calls
+// to runtime.deadlock when there is no scheduler. Without scheduler, there are
+// no other goroutines that could wake up a parked task.
+func (c *Compiler) lowerParkTaskWithoutScheduler() {
+	deadlock := c.mod.NamedFunction("runtime.deadlock")
+	for _, parkCall := range getUses(c.mod.NamedFunction("runtime.parkTask")) {
+		// There is no current coroutine. The runtime checks for a nil
+		// coroutine and calls runtime.deadlock before parking the task.
+		fn := parkCall.InstructionParent().Parent()
+		for _, getCoroutineCall := range getUses(c.mod.NamedFunction("runtime.getCoroutine")) {
+			if getCoroutineCall.InstructionParent().Parent() == fn {
+				getCoroutineCall.ReplaceAllUsesWith(llvm.ConstPointerNull(getCoroutineCall.Type()))
+				getCoroutineCall.EraseFromParentAsInstruction()
+			}
+		}
+
+		// This call is unreachable, but it must still be replaced as
+		// runtime.parkTask has no implementation. runtime.deadlock has the
+		// same signature.
+		parkCall.SetOperand(parkCall.OperandsCount()-1, deadlock)
+	}
+}
+
 // Lower runtime.makeGoroutine calls to regular call instructions. This is done
 // after the regular goroutine transformations. The started goroutines are
 // either non-blocking (in which case they can be called directly) or blocking,
@@ -0,0 +1,148 @@
+package compiler
+
+// This file implements the 'go' keyword in Go. The resulting pseudo-calls are
+// lowered to coroutines (or regular calls) in goroutine-lowering.go.
+
+import (
+	"go/types"
+
+	"golang.org/x/tools/go/ssa"
+	"tinygo.org/x/go-llvm"
+)
+
+// emitGo starts a new goroutine. The function is called through the pointer
+// returned by runtime.makeGoroutine, which marks it as a goroutine start for
+// LowerGoroutines.
+//
+// The goroutine lowering must know statically which function is started, so
+// interface method calls and calls on func values are done in a wrapper
+// function that is started as a goroutine instead.
+func (c *Compiler) emitGo(frame *Frame, instr *ssa.Go) {
+	var fn llvm.Value
+	var params []llvm.Value
+	context := llvm.Undef(c.i8ptrType)
+	exported := false
+
+	var args []llvm.Value
+	for _, arg := range instr.Call.Args {
+		args = append(args, c.getValue(frame, arg))
+	}
+
+	if instr.Call.IsInvoke() {
+		// Method call on an interface, like:
+		//     go itf.Method(args...)
+		itf := c.getValue(frame, instr.Call.Value)
+		params = append([]llvm.Value{itf}, args...)
+		fn = c.createGoroutineWrapper(frame, instr, params, func(params []llvm.Value) {
+			fnPtr, receiverValue := c.getInvokeFunc(params[0], &instr.Call)
+			callParams := append([]llvm.Value{receiverValue}, params[1:]...)
+			callParams = append(callParams, llvm.Undef(c.i8ptrType)) // context parameter
+			callParams = append(callParams, llvm.Undef(c.i8ptrType)) // parent coroutine handle
+			c.createCall(fnPtr, callParams, "")
+		})
+	} else if callee := instr.Call.StaticCallee(); callee != nil {
+		// Direct call of a function or closure, like:
+		//     go foo(args...)
+		//     go value.Method(args...)
+		//     go func() { ... }()
+		calleeFn := c.ir.GetFunction(callee)
+		fn = calleeFn.LLVMFn
+		exported = calleeFn.IsExported()
+		params = args
+		if closure, ok := instr.Call.Value.(*ssa.MakeClosure); ok {
+			context = c.extractFuncContext(c.getValue(frame, closure))
+		}
+	} else if _, ok := instr.Call.Value.(*ssa.Builtin); ok {
+		c.addError(instr.Pos(), "todo: go on builtin function")
+		return
+	} else {
+		// Call on a func value, like:
+		//     go fn(args...)
+		funcValue := c.getValue(frame, instr.Call.Value)
+		sig := instr.Call.Value.Type().Underlying().(*types.Signature)
+		if c.funcImplementation() == funcValueDoubleword {
+			// A nil func value must panic in the calling goroutine. This
+			// check is part of the lowered call with funcValueSwitch.
+			funcPtr, _ := c.decodeFuncValue(funcValue, sig)
+			c.emitNilCheck(frame, funcPtr, "fpcall")
+		}
+		params = append([]llvm.Value{funcValue}, args...)
+		fn = c.createGoroutineWrapper(frame, instr, params, func(params []llvm.Value) {
+			// The called function may be blocking, in which case it must not
+			// resume this wrapper when it is done: it is an independent
+			// goroutine. Therefore, the parent coroutine handle is nil, like
+			// in a go statement on a known function.
+			funcPtr, context := c.decodeFuncValue(params[0], sig)
+			callParams := append([]llvm.Value{}, params[1:]...)
+			callParams = append(callParams, context)                            // context parameter
+			callParams = append(callParams, llvm.ConstPointerNull(c.i8ptrType)) // parent coroutine handle
+			c.createCall(funcPtr, callParams, "")
+		})
+	}
+
+	// Mark this function as a 'go' invocation and break invalid
+	// interprocedural optimizations. For example, heap-to-stack
+	// transformations are not sound as goroutines can outlive their parent.
+	calleeType := fn.Type()
+	calleeValue := c.builder.CreateBitCast(fn, c.i8ptrType, "")
+	calleeValue = c.createRuntimeCall("makeGoroutine", []llvm.Value{calleeValue}, "")
+	calleeValue = c.builder.CreateBitCast(calleeValue, calleeType, "")
+
+	if !exported {
+		params = append(params, context)                 // context parameter
+		params = append(params, llvm.Undef(c.i8ptrType)) // parent coroutine handle
+	}
+	c.createCall(calleeValue, params, "")
+
+	// The goroutine may have panicked before blocking.
+	c.createRuntimeCall("goroutineStarted", nil, "")
+}
+
+// createGoroutineWrapper creates a new function that takes the given values as
+// parameters (followed by the usual context and parent coroutine handle) and
+// calls createBody to fill in the function body. The resulting function can be
+// started as a goroutine.
+func (c *Compiler) createGoroutineWrapper(frame *Frame, instr *ssa.Go, values []llvm.Value, createBody func(params []llvm.Value)) llvm.Value {
+	var paramTypes []llvm.Type
+	for _, value := range values {
+		paramTypes = append(paramTypes, c.expandFormalParamType(value.Type())...)
+	}
+	paramTypes = append(paramTypes, c.i8ptrType, c.i8ptrType) // context, parent coroutine handle
+	fnType := llvm.FunctionType(c.ctx.VoidType(), paramTypes, false)
+	wrapper := llvm.AddFunction(c.mod, frame.fn.LinkName()+"$gowrapper", fnType)
+	wrapper.SetLinkage(llvm.InternalLinkage)
+	wrapper.SetUnnamedAddr(true)
+	wrapper.LastParam().SetName("parentHandle")
+
+	// Save the current position of the IR builder, to continue after the
+	// wrapper has been created.
+	currentBlock := c.builder.GetInsertBlock()
+	var currentLocation llvm.DebugLoc
+	if c.Debug {
+		currentLocation = c.builder.GetCurrentDebugLocation()
+		pos := c.ir.Program.Fset.Position(instr.Pos())
+		difunc := c.attachDebugInfoRaw(frame.fn, wrapper, "$gowrapper", pos.Filename, pos.Line)
+		c.builder.SetCurrentDebugLocation(uint(pos.Line), uint(pos.Column), difunc, llvm.Metadata{})
+	}
+
+	block := c.ctx.AddBasicBlock(wrapper, "entry")
+	c.builder.SetInsertPointAtEnd(block)
+	var params []llvm.Value
+	paramIndex := 0
+	for _, value := range values {
+		var fields []llvm.Value
+		for range c.expandFormalParamType(value.Type()) {
+			fields = append(fields, wrapper.Param(paramIndex))
+			paramIndex++
+		}
+		params = append(params, c.collapseFormalParam(value.Type(), fields))
+	}
+	createBody(params)
+	c.builder.CreateRetVoid()
+
+	c.builder.SetInsertPointAtEnd(currentBlock)
+	if c.Debug {
+		c.builder.SetCurrentDebugLocation(currentLocation.Line, currentLocation.Col, currentLocation.Scope, currentLocation.InlinedAt)
+	}
+	return wrapper
+}
@@ -24,13 +24,25 @@ func (c *Compiler) emitReadRegister(name string, args []ssa.Value) (llvm.Value,
 	var asm string
 	switch name {
 	case "device/arm.ReadRegister":
-		asm = "mov $0, " + regname
+		switch regname {
+		case "primask", "basepri", "faultmask", "control", "msp", "psp":
+			// Special registers can only be read with mrs.
+			asm = "mrs $0, " + regname
+		default:
+			asm = "mov $0, " + regname
+		}
 	case "device/riscv.ReadRegister":
-		asm = "mv $0, " + regname
+		switch regname {
+		case "mstatus", "mie", "mip", "mtvec", "mscratch", "mepc", "mcause", "mtval":
+			// Control and status registers can only be read with csrr.
+			asm = "csrr $0, " + regname
+		default:
+			asm = "mv $0, " + regname
+		}
 	default:
 		panic("unknown architecture")
 	}
-	target := llvm.InlineAsm(fnType, asm, "=r", false, false, 0)
+	target := llvm.InlineAsm(fnType, asm, "=r", true, false, 0)
 	return c.builder.CreateCall(target, nil, ""), nil
 }
 
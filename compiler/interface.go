@@ -32,7 +32,7 @@ func (c *Compiler) parseMakeInterface(val llvm.Value, typ types.Type, pos token.
 		itfConcreteTypeGlobal = llvm.AddGlobal(c.mod, typeInInterface, "typeInInterface:"+itfTypeCodeGlobal.Name())
 		itfConcreteTypeGlobal.SetInitializer(llvm.ConstNamedStruct(typeInInterface, []llvm.Value{itfTypeCodeGlobal, itfMethodSetGlobal}))
 		itfConcreteTypeGlobal.SetGlobalConstant(true)
-		itfConcreteTypeGlobal.SetLinkage(llvm.PrivateLinkage)
+		c.setHelperLinkage(itfConcreteTypeGlobal, llvm.PrivateLinkage)
 	}
 	itfTypeCode := c.builder.CreatePtrToInt(itfConcreteTypeGlobal, c.uintptrType, "")
 	itf := llvm.Undef(c.getLLVMRuntimeType("_interface"))
@@ -50,6 +50,7 @@ func (c *Compiler) getTypeCode(typ types.Type) llvm.Value {
 	if global.IsNil() {
 		global = llvm.AddGlobal(c.mod, c.getLLVMRuntimeType("typecodeID"), globalName)
 		global.SetGlobalConstant(true)
+		c.typeCodeTypes[globalName] = typ
 	}
 	return global
 }
@@ -142,7 +143,19 @@ func getTypeCodeName(t types.Type) string {
 			panic("cgo unions are not allowed in interfaces")
 		}
 		for i := 0; i < t.NumFields(); i++ {
-			elems[i] = getTypeCodeName(t.Field(i).Type())
+			// Field names and tags are part of the type identity, and are
+			// needed for the reflect side tables.
+			field := t.Field(i)
+			elems[i] = field.Name() + " " + getTypeCodeName(field.Type())
+			if !field.Exported() {
+				elems[i] = field.Pkg().Path() + "." + elems[i]
+			}
+			if field.Embedded() {
+				elems[i] = "embedded " + elems[i]
+			}
+			if t.Tag(i) != "" {
+				elems[i] += " " + strconv.Quote(t.Tag(i))
+			}
 		}
 		return "struct:" + name + "{" + strings.Join(elems, ",") + "}"
 	default:
@@ -188,7 +201,7 @@ func (c *Compiler) getTypeMethodSet(typ types.Type) llvm.Value {
 	global = llvm.AddGlobal(c.mod, arrayType, typ.String()+"$methodset")
 	global.SetInitializer(value)
 	global.SetGlobalConstant(true)
-	global.SetLinkage(llvm.PrivateLinkage)
+	c.setHelperLinkage(global, llvm.PrivateLinkage)
 	return llvm.ConstGEP(global, []llvm.Value{zero, zero})
 }
 
@@ -214,7 +227,7 @@ func (c *Compiler) getInterfaceMethodSet(typ *types.Named) llvm.Value {
 	global = llvm.AddGlobal(c.mod, value.Type(), typ.String()+"$interface")
 	global.SetInitializer(value)
 	global.SetGlobalConstant(true)
-	global.SetLinkage(llvm.PrivateLinkage)
+	c.setHelperLinkage(global, llvm.PrivateLinkage)
 	return llvm.ConstGEP(global, []llvm.Value{zero, zero})
 }
 
@@ -316,22 +329,11 @@ func (c *Compiler) parseTypeAssert(frame *Frame, expr *ssa.TypeAssert) llvm.Valu
 }
 
 // getInvokeCall creates and returns the function pointer and parameters of an
-// interface call. It can be used in a call or defer instruction.
+// interface call.
 func (c *Compiler) getInvokeCall(frame *Frame, instr *ssa.CallCommon) (llvm.Value, []llvm.Value) {
 	// Call an interface method with dynamic dispatch.
 	itf := c.getValue(frame, instr.Value) // interface
-
-	llvmFnType := c.getRawFuncType(instr.Method.Type().(*types.Signature))
-
-	typecode := c.builder.CreateExtractValue(itf, 0, "invoke.typecode")
-	values := []llvm.Value{
-		typecode,
-		c.getInterfaceMethodSet(instr.Value.Type().(*types.Named)),
-		c.getMethodSignature(instr.Method),
-	}
-	fn := c.createRuntimeCall("interfaceMethod", values, "invoke.func")
-	fnCast := c.builder.CreateIntToPtr(fn, llvmFnType, "invoke.func.cast")
-	receiverValue := c.builder.CreateExtractValue(itf, 1, "invoke.func.receiver")
+	fnCast, receiverValue := c.getInvokeFunc(itf, instr)
 
 	args := []llvm.Value{receiverValue}
 	for _, arg := range instr.Args {
@@ -346,6 +348,23 @@ func (c *Compiler) getInvokeCall(frame *Frame, instr *ssa.CallCommon) (llvm.Valu
 	return fnCast, args
 }
 
+// getInvokeFunc returns the function pointer of the method called in this
+// interface method call, and the receiver value to pass to it.
+func (c *Compiler) getInvokeFunc(itf llvm.Value, instr *ssa.CallCommon) (fnCast, receiverValue llvm.Value) {
+	llvmFnType := c.getRawFuncType(instr.Method.Type().(*types.Signature))
+
+	typecode := c.builder.CreateExtractValue(itf, 0, "invoke.typecode")
+	values := []llvm.Value{
+		typecode,
+		c.getInterfaceMethodSet(instr.Value.Type().(*types.Named)),
+		c.getMethodSignature(instr.Method),
+	}
+	fn := c.createRuntimeCall("interfaceMethod", values, "invoke.func")
+	fnCast = c.builder.CreateIntToPtr(fn, llvmFnType, "invoke.func.cast")
+	receiverValue = c.builder.CreateExtractValue(itf, 1, "invoke.func.receiver")
+	return
+}
+
 // interfaceInvokeWrapper keeps some state between getInterfaceInvokeWrapper and
 // createInterfaceInvokeWrapper. The former is called during IR construction
 // itself and the latter is called when finishing up the IR.
@@ -413,6 +432,9 @@ func (c *Compiler) createInterfaceInvokeWrapper(state interfaceInvokeWrapper) {
 	block := c.ctx.AddBasicBlock(wrapper, "entry")
 	c.builder.SetInsertPointAtEnd(block)
 
+	// The wrapper may be called as a deferred call, see compiler/panic.go.
+	c.createRuntimeCall("forwardDeferredCall", []llvm.Value{c.deferredCallID(wrapper), c.deferredCallID(fn.LLVMFn)}, "")
+
 	receiverValue := c.emitPointerUnpack(wrapper.Param(0), []llvm.Type{receiverType})[0]
 	params := append(c.expandFormalParam(receiverValue), wrapper.Params()[1:]...)
 	if fn.LLVMFn.Type().ElementType().ReturnType().TypeKind() == llvm.VoidTypeKind {
@@ -0,0 +1,10 @@
+// +build !byollvm
+
+package compiler
+
+/*
+#cgo linux  CPPFLAGS: -I/usr/lib/llvm-8/include -D__STDC_CONSTANT_MACROS -D__STDC_LIMIT_MACROS
+#cgo darwin CPPFLAGS: -I/usr/local/opt/llvm/include -D__STDC_CONSTANT_MACROS -D__STDC_LIMIT_MACROS
+#cgo CXXFLAGS: -std=c++11
+*/
+import "C"
@@ -0,0 +1,48 @@
+package compiler
+
+// This file adds ELF notes to the program, like the build information of
+// -buildinfo. The linker scripts of baremetal targets place .note.* sections in
+// flash, so that notes end up in the firmware image too.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"tinygo.org/x/go-llvm"
+)
+
+// AddNote adds an ELF note with the given name, type and description to the
+// program, in the given section which should start with ".note". The note is
+// not referenced by the program, it is only meant to be read by other tools. It
+// returns the name of the symbol of the note, which can be used to keep it when
+// linking with --gc-sections.
+func (c *Compiler) AddNote(section, name string, noteType uint32, desc []byte) string {
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	if c.targetData.ByteOrder() == llvm.BigEndian {
+		byteOrder = binary.BigEndian
+	}
+
+	// A note is a header with the size of the name and description and the
+	// type, followed by the name (including the terminating NUL byte) and the
+	// description. The name and description are padded to 4 bytes.
+	buf := &bytes.Buffer{}
+	binary.Write(buf, byteOrder, [3]uint32{uint32(len(name) + 1), uint32(len(desc)), noteType})
+	buf.WriteString(name)
+	buf.WriteByte(0)
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+	buf.Write(desc)
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+
+	value := c.ctx.ConstString(buf.String(), false)
+	global := llvm.AddGlobal(c.mod, value.Type(), "tinygo"+strings.Replace(section, ".", "_", -1))
+	global.SetInitializer(value)
+	global.SetGlobalConstant(true)
+	global.SetSection(section)
+	global.SetAlignment(4)
+	return global.Name()
+}
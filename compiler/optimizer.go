@@ -122,6 +122,10 @@ func (c *Compiler) Optimize(optLevel, sizeLevel int, inlinerThreshold uint) erro
 		}
 	}
 
+	// The symbol table refers to the final set of functions, so it can only
+	// be created after all optimizations have run.
+	c.emitSymtab()
+
 	return nil
 }
 
@@ -0,0 +1,505 @@
+package compiler
+
+// This file implements incremental compilation: each package (except for the
+// main package and packages using cgo) is compiled into its own LLVM module,
+// which is stored as bitcode in a cache. On the next build, packages that did
+// not change are loaded from the cache instead of being compiled again. All
+// package modules are linked together before the whole-program passes (interp,
+// interface lowering, goroutine lowering, etc.) run, so the resulting program
+// is the same as when compiling everything at once.
+//
+// A few things need special care when a program is split over multiple
+// modules:
+//   * Unexported functions and globals get internal linkage once all modules
+//     have been linked. Before that, they must be visible to other modules.
+//   * Globals are only defined in the module of the package they belong to.
+//     All other modules only declare them.
+//   * Some globals (like method sets and function signatures) are created on
+//     demand in every module that needs them and are identified by name in
+//     later passes. They have linkonce_odr linkage while modules are compiled
+//     separately, so that the linker merges them.
+//   * Information that is gathered while generating code, like the Go types of
+//     type codes, must be recovered for packages that were loaded from the
+//     cache. Symbol table entries are stored as attributes of each function
+//     instead, so that they are part of the bitcode.
+//
+// The cache key of a package includes the contents of its files, the keys of
+// all packages it imports, the compiler configuration, and the functions of the
+// package that survived dead code elimination (as that depends on the whole
+// program).
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tinygo-org/tinygo/ir"
+	"github.com/tinygo-org/tinygo/loader"
+	"golang.org/x/tools/go/ssa"
+	"tinygo.org/x/go-llvm"
+)
+
+// PackageCache stores the LLVM bitcode of compiled packages between builds.
+// It is set in the compiler config to enable incremental compilation.
+type PackageCache interface {
+	// Load returns the path to the cached bitcode file with the given name and
+	// key, or "" if it is not cached.
+	Load(name, key string, sourceFiles []string) (string, error)
+
+	// Store moves the bitcode file at tmppath into the cache and returns its
+	// new path.
+	Store(tmppath, name, key string, sourceFiles []string) (string, error)
+}
+
+// setHelperLinkage sets the linkage of a global that is created on demand in
+// every module that uses it, and that is identified by its name. When packages
+// are compiled separately, these globals must be merged when linking the
+// package modules, so they get linkonce_odr linkage until then.
+func (c *Compiler) setHelperLinkage(global llvm.Value, linkage llvm.Linkage) {
+	if c.PackageCache != nil {
+		linkage = llvm.LinkOnceODRLinkage
+	}
+	global.SetLinkage(linkage)
+}
+
+// ownsGlobal returns whether the given global must be defined in the current
+// module. Other globals are only declared.
+func (c *Compiler) ownsGlobal(g *ssa.Global) bool {
+	return c.ownedPackages == nil || c.ownedPackages[g.Pkg]
+}
+
+// isCacheablePackage returns whether this package can be compiled separately
+// and stored in the package cache. The main package is excluded as it is the
+// one most likely to change, and cgo packages are excluded as they depend on
+// C headers.
+func (c *Compiler) isCacheablePackage(pkgInfo *loader.Package) bool {
+	if pkgInfo.Pkg.Path() == c.ir.MainPkg().Pkg.Path() {
+		return false
+	}
+	if pkgInfo.Package != nil && len(pkgInfo.CgoFiles) != 0 {
+		return false
+	}
+	return len(pkgInfo.Files) != 0
+}
+
+// packageConfigKey returns a string with all parts of the configuration that
+// influence the generated code of a package.
+func (c *Compiler) packageConfigKey() string {
+	return strings.Join([]string{
+		"triple=" + c.Triple,
+		"cpu=" + c.CPU,
+		"features=" + strings.Join(c.Features, ","),
+		"goos=" + c.GOOS,
+		"goarch=" + c.GOARCH,
+		"gc=" + c.selectGC(),
+		"panic=" + c.PanicStrategy,
+		"debug=" + strconv.FormatBool(c.Debug),
+		"symtab=" + strconv.FormatBool(c.hasSymtab()),
+		"trimpath=" + strconv.FormatBool(c.TrimPath),
+		"tags=" + strings.Join(c.BuildTags, " "),
+		"test=" + strconv.FormatBool(c.TestConfig.CompileTestBinary),
+	}, "\n")
+}
+
+// packageSourceFiles returns the paths of all Go files of the package.
+func (c *Compiler) packageSourceFiles(pkgInfo *loader.Package) []string {
+	files := make([]string, len(pkgInfo.Files))
+	for i, file := range pkgInfo.Files {
+		files[i] = c.ir.Program.Fset.File(file.Pos()).Name()
+	}
+	return files
+}
+
+// packageKey returns the cache key for the given package. The keys of all
+// imported packages must already be present in the keys map.
+func (c *Compiler) packageKey(pkgInfo *loader.Package, funcs []*ir.Function, configKey string, keys map[string]string) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, configKey+"\x00")
+	io.WriteString(h, pkgInfo.Pkg.Path()+"\x00")
+	for _, path := range c.packageSourceFiles(pkgInfo) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, path+"\x00"+strconv.Itoa(len(data))+"\x00")
+		h.Write(data)
+	}
+
+	// Changes in imported packages (like the layout of a struct or the value
+	// of a constant) may result in different code for this package.
+	imports := make([]string, 0, len(pkgInfo.Imports))
+	for path := range pkgInfo.Imports {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	for _, path := range imports {
+		io.WriteString(h, "import "+path+" "+keys[path]+"\x00")
+	}
+
+	// Which functions are compiled depends on which functions are used in the
+	// rest of the program.
+	names := make([]string, len(funcs))
+	for i, f := range funcs {
+		names[i] = f.LinkName()
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		io.WriteString(h, "func "+name+"\x00")
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// compilePackages compiles all functions in the program, loading packages from
+// the package cache where possible. Cached and newly compiled packages are
+// linked into the main module, which contains the remaining functions.
+func (c *Compiler) compilePackages() error {
+	// Sort functions per package.
+	pkgFuncs := make(map[*ssa.Package][]*ir.Function)
+	for _, f := range c.ir.Functions {
+		pkgFuncs[f.Pkg] = append(pkgFuncs[f.Pkg], f)
+	}
+
+	// Compile each cacheable package into its own module, or load it from the
+	// cache.
+	configKey := c.packageConfigKey()
+	keys := make(map[string]string)
+	cached := make(map[*ssa.Package]bool)
+	var bitcodeFiles []string
+	for _, pkgInfo := range c.ir.LoaderProgram.Sorted() {
+		pkg := c.ir.Program.ImportedPackage(pkgInfo.Pkg.Path())
+		if pkg == nil {
+			continue
+		}
+		funcs := pkgFuncs[pkg]
+		key, err := c.packageKey(pkgInfo, funcs, configKey, keys)
+		if err != nil {
+			return err
+		}
+		keys[pkgInfo.Pkg.Path()] = key
+		if !c.isCacheablePackage(pkgInfo) {
+			continue
+		}
+		cached[pkg] = true
+
+		name := strings.Replace(pkgInfo.Pkg.Path(), "/", "_", -1) + ".bc"
+		sourceFiles := c.packageSourceFiles(pkgInfo)
+		path, err := c.PackageCache.Load(name, key, sourceFiles)
+		if err != nil {
+			return err
+		}
+		if path != "" {
+			bitcodeFiles = append(bitcodeFiles, path)
+			continue
+		}
+
+		// Not cached, so compile it now.
+		numDiagnostics := len(c.diagnostics)
+		mod := c.compilePackage(pkg, funcs)
+		if len(c.diagnostics) != numDiagnostics {
+			// Don't store a broken package in the cache. The errors will be
+			// reported after compilation.
+			mod.Dispose()
+			continue
+		}
+		f, err := ioutil.TempFile("", "tinygo-*.bc")
+		if err != nil {
+			mod.Dispose()
+			return err
+		}
+		err = llvm.WriteBitcodeToFile(mod, f)
+		f.Close()
+		mod.Dispose()
+		if err != nil {
+			os.Remove(f.Name())
+			return err
+		}
+		path, err = c.PackageCache.Store(f.Name(), name, key, sourceFiles)
+		if err != nil {
+			os.Remove(f.Name())
+			return err
+		}
+		bitcodeFiles = append(bitcodeFiles, path)
+	}
+
+	// Compile the remaining functions (from uncacheable packages and synthetic
+	// functions shared between packages) into the main module. Globals of
+	// uncacheable packages may be used by any other package, so define all of
+	// them.
+	c.ownedPackages = make(map[*ssa.Package]bool)
+	for _, f := range c.ir.Functions {
+		c.parseFuncDecl(f)
+	}
+	for _, pkg := range c.ir.Program.AllPackages() {
+		if !cached[pkg] {
+			c.ownedPackages[pkg] = true
+			c.defineGlobals(pkg)
+		}
+	}
+	for _, f := range c.ir.Functions {
+		if f.Pkg == nil || !cached[f.Pkg] {
+			c.compileFunction(f)
+		}
+	}
+	for _, state := range c.interfaceInvokeWrappers {
+		c.createInterfaceInvokeWrapper(state)
+	}
+	c.interfaceInvokeWrappers = nil
+	c.ownedPackages = nil
+
+	// Link all package modules into the main module. The module flags for debug
+	// information must be added first, as they are also present in the package
+	// modules.
+	if c.Debug {
+		c.addDebugInfoFlags()
+	}
+	anchor := c.anchorRuntimeTypes()
+	for _, path := range bitcodeFiles {
+		mod, err := llvm.ParseBitcodeFile(path)
+		if err != nil {
+			return err
+		}
+		err = llvm.LinkModules(c.mod, mod)
+		if err != nil {
+			return err
+		}
+	}
+	anchor.EraseFromParentAsGlobal()
+	c.finishLinkedModule()
+	return nil
+}
+
+// compilePackage compiles the given functions of a single package into a new
+// module. The returned module must be disposed by the caller.
+func (c *Compiler) compilePackage(pkg *ssa.Package, funcs []*ir.Function) llvm.Module {
+	mainMod, mainDIBuilder, mainCU, mainDIFiles := c.mod, c.dibuilder, c.cu, c.difiles
+	defer func() {
+		c.mod, c.dibuilder, c.cu, c.difiles = mainMod, mainDIBuilder, mainCU, mainDIFiles
+		c.ownedPackages = nil
+		c.interfaceInvokeWrappers = nil
+	}()
+
+	mod := c.ctx.NewModule(pkg.Pkg.Path())
+	mod.SetTarget(c.Triple)
+	mod.SetDataLayout(c.targetData.String())
+	c.mod = mod
+	c.ownedPackages = map[*ssa.Package]bool{pkg: true}
+	c.difiles = make(map[string]llvm.Metadata)
+	c.interfaceInvokeWrappers = nil
+	if c.Debug {
+		c.dibuilder = llvm.NewDIBuilder(mod)
+		c.cu = c.dibuilder.CreateCompileUnit(llvm.DICompileUnit{
+			Language:  0xb, // DW_LANG_C99 (0xc, off-by-one?)
+			File:      pkg.Pkg.Path(),
+			Dir:       "",
+			Producer:  "TinyGo",
+			Optimized: true,
+		})
+	}
+
+	// Declare all functions, as any of them may be called from this package.
+	// Unused declarations are removed again below.
+	for _, f := range c.ir.Functions {
+		c.parseFuncDecl(f)
+	}
+
+	// Globals of this package may be used from other packages, so define all
+	// of them.
+	c.defineGlobals(pkg)
+
+	for _, f := range funcs {
+		c.compileFunction(f)
+	}
+	for _, state := range c.interfaceInvokeWrappers {
+		c.createInterfaceInvokeWrapper(state)
+	}
+
+	// Remove declarations that are not used, to keep the bitcode small.
+	for fn := mod.FirstFunction(); !fn.IsNil(); {
+		next := llvm.NextFunction(fn)
+		if fn.IsDeclaration() && fn.FirstUse().IsNil() {
+			fn.EraseFromParentAsFunction()
+		}
+		fn = next
+	}
+
+	if c.Debug {
+		c.addDebugInfoFlags()
+		c.dibuilder.Finalize()
+		c.dibuilder.Destroy()
+	}
+	return mod
+}
+
+// compileFunction adds the definition of the given function, which must
+// already be declared in the current module.
+func (c *Compiler) compileFunction(f *ir.Function) {
+	if f.CName() != "" {
+		return
+	}
+	if f.Blocks == nil {
+		return // external function
+	}
+	frame := c.parseFuncDecl(f)
+	c.parseFunc(frame)
+}
+
+// defineGlobals defines all globals of the given package in the current module.
+func (c *Compiler) defineGlobals(pkg *ssa.Package) {
+	names := make([]string, 0, len(pkg.Members))
+	for name, member := range pkg.Members {
+		if _, ok := member.(*ssa.Global); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names) // for deterministic output
+	for _, name := range names {
+		c.getGlobal(pkg.Members[name].(*ssa.Global))
+	}
+}
+
+// anchorRuntimeTypes adds a global to the main module that refers to all named
+// struct types of the runtime. Package modules loaded from bitcode have their
+// own copies of these types (with a suffix like .0), which the linker only
+// maps to the original types if they are used in the main module. The returned
+// global must be removed after linking.
+func (c *Compiler) anchorRuntimeTypes() llvm.Value {
+	scope := c.ir.Program.ImportedPackage("runtime").Pkg.Scope()
+	var fieldTypes []llvm.Type
+	for _, name := range scope.Names() {
+		typeName, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		if _, ok := typeName.Type().Underlying().(*types.Struct); !ok {
+			continue
+		}
+		fieldTypes = append(fieldTypes, llvm.PointerType(c.getLLVMType(typeName.Type()), 0))
+	}
+	anchorType := c.ctx.StructType(fieldTypes, false)
+	anchor := llvm.AddGlobal(c.mod, anchorType, "tinygo.runtimeTypes")
+	anchor.SetInitializer(c.getZeroValue(anchorType))
+	anchor.SetLinkage(llvm.InternalLinkage)
+	return anchor
+}
+
+// finishLinkedModule fixes up the main module after all package modules have
+// been linked into it, so that it looks like it would have looked if all
+// packages had been compiled into a single module.
+func (c *Compiler) finishLinkedModule() {
+	// Functions have been replaced by the linker, so look them up again.
+	for _, f := range c.ir.Functions {
+		f.LLVMFn = c.mod.NamedFunction(f.LinkName())
+		if !f.IsExported() && !f.LLVMFn.IsDeclaration() {
+			f.LLVMFn.SetLinkage(llvm.InternalLinkage)
+		}
+	}
+
+	// Globals of the Go program are internal, unless they are defined
+	// elsewhere.
+	for _, pkg := range c.ir.Program.AllPackages() {
+		for _, member := range pkg.Members {
+			g, ok := member.(*ssa.Global)
+			if !ok {
+				continue
+			}
+			info := c.getGlobalInfo(g)
+			if info.extern {
+				continue
+			}
+			if global := c.mod.NamedGlobal(info.linkName); !global.IsNil() && !global.IsDeclaration() {
+				global.SetLinkage(llvm.InternalLinkage)
+			}
+		}
+	}
+
+	// Globals that have been merged by the linker.
+	for global := c.mod.FirstGlobal(); !global.IsNil(); global = llvm.NextGlobal(global) {
+		if global.Linkage() == llvm.LinkOnceODRLinkage {
+			global.SetLinkage(llvm.InternalLinkage)
+		}
+	}
+
+	c.loadTypeCodeTypes()
+}
+
+// loadTypeCodeTypes finds the Go types of type codes that were created in
+// packages loaded from the cache. This is done by walking all types used in
+// the program until all type codes are known.
+func (c *Compiler) loadTypeCodeTypes() {
+	missing := 0
+	for global := c.mod.FirstGlobal(); !global.IsNil(); global = llvm.NextGlobal(global) {
+		name := global.Name()
+		if strings.HasPrefix(name, "type:") {
+			if _, ok := c.typeCodeTypes[name]; !ok {
+				missing++
+			}
+		}
+	}
+	if missing == 0 {
+		return
+	}
+
+	visited := make(map[types.Type]bool)
+	var walk func(t types.Type)
+	walk = func(t types.Type) {
+		if t == nil || visited[t] {
+			return
+		}
+		visited[t] = true
+		switch t := t.(type) {
+		case *types.Basic:
+			if t.Info()&types.IsUntyped != 0 || t.Kind() == types.Invalid {
+				return
+			}
+		case *types.Tuple:
+			for i := 0; i < t.Len(); i++ {
+				walk(t.At(i).Type())
+			}
+			return
+		case *types.Array:
+			walk(t.Elem())
+		case *types.Chan:
+			walk(t.Elem())
+		case *types.Map:
+			walk(t.Key())
+			walk(t.Elem())
+		case *types.Named:
+			walk(t.Underlying())
+		case *types.Pointer:
+			walk(t.Elem())
+		case *types.Signature:
+			walk(t.Params())
+			walk(t.Results())
+		case *types.Slice:
+			walk(t.Elem())
+		case *types.Struct:
+			for i := 0; i < t.NumFields(); i++ {
+				walk(t.Field(i).Type())
+			}
+		}
+		name := "type:" + getTypeCodeName(t)
+		if _, ok := c.typeCodeTypes[name]; !ok && !c.mod.NamedGlobal(name).IsNil() {
+			c.typeCodeTypes[name] = t
+			missing--
+		}
+	}
+	for _, pkgInfo := range c.ir.LoaderProgram.Sorted() {
+		for _, tv := range pkgInfo.Types {
+			walk(tv.Type)
+		}
+		for _, obj := range pkgInfo.Defs {
+			if obj != nil {
+				walk(obj.Type())
+			}
+		}
+		if missing == 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package compiler
+
+// This file implements stack unwinding for panics, so that deferred calls are
+// run and recover() works.
+//
+// Unwinding is implemented without any target-specific support (like
+// setjmp/longjmp or DWARF unwind tables), which makes it work on all targets
+// including WebAssembly. It works as follows:
+//   * runtime._panic sets the global runtime.panicking flag and returns.
+//   * After every call, the flag is checked. If it is set, the calling
+//     function jumps to its unwind block.
+//   * For a function with deferred calls, the unwind block is a landing pad
+//     that runs all deferred calls. If one of them called recover(), the
+//     function returns normally (through the ssa Recover block). Otherwise,
+//     the function returns with the flag still set.
+//   * Before each deferred call, the landing pad passes the runtime.panicRecord
+//     and the function pointer of the called function to the runtime. A
+//     function that calls recover() claims the panic record at its start if it
+//     is that function, and passes the claimed record to recover() which fails
+//     without one. This way, only functions called directly by the landing pad
+//     can recover, and each deferred call can be claimed only once.
+//     Method wrappers pass on the deferred call to the method they wrap.
+//   * For other functions, the unwind block simply returns.
+//   * For exported functions, which have no Go caller, the unwind block prints
+//     the panic message and aborts.
+// Blocking functions return to the scheduler first, which resumes the parent
+// coroutine right away to continue unwinding (see runtime.activateTask).
+//
+// The checks are only inserted when the program contains at least one deferred
+// call. Without deferred calls there is no way to observe the difference and
+// runtime._panic aborts right away.
+
+import (
+	"tinygo.org/x/go-llvm"
+)
+
+// emitUnwindBlock creates the block that is jumped to when a call in this
+// function returns while panicking. It must be called after the function body
+// has been generated.
+func (c *Compiler) emitUnwindBlock(frame *Frame) {
+	if c.Debug {
+		pos := c.ir.Program.Fset.Position(frame.fn.Pos())
+		c.builder.SetCurrentDebugLocation(uint(pos.Line), uint(pos.Column), frame.difunc, llvm.Metadata{})
+	}
+
+	var unwind llvm.BasicBlock
+	if frame.fn.Recover != nil {
+		// Landing pad: run all deferred calls, and continue in the Recover
+		// block if any of them recovered the panic.
+		unwind = c.ctx.AddBasicBlock(frame.fn.LLVMFn, "unwind.landing")
+		c.builder.SetInsertPointAtEnd(unwind)
+		record := c.createEntryBlockAlloca(c.getLLVMRuntimeType("panicRecord"), "unwind.record")
+		c.createRuntimeCall("beginUnwind", []llvm.Value{record}, "")
+		c.emitRunDefers(frame, record)
+		recovered := c.createRuntimeCall("endUnwind", []llvm.Value{record}, "unwind.recovered")
+		resume := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "unwind.resume")
+		c.builder.CreateCondBr(recovered, frame.blockEntries[frame.fn.Recover], resume)
+		c.builder.SetInsertPointAtEnd(resume)
+	} else if frame.fn.IsExported() {
+		unwind = c.ctx.AddBasicBlock(frame.fn.LLVMFn, "unwind.abort")
+		c.builder.SetInsertPointAtEnd(unwind)
+	} else {
+		// A regular function without deferred calls. The unwind block is
+		// created on demand in lowerPanics as it does not need any debug
+		// information.
+		return
+	}
+
+	if frame.fn.IsExported() {
+		// There is no Go caller to continue unwinding in.
+		c.createRuntimeCall("unrecoveredPanic", nil, "")
+		c.builder.CreateUnreachable()
+	} else {
+		c.createUnwindReturn(frame.fn.LLVMFn)
+	}
+	c.unwindBlocks[frame.fn.LLVMFn] = unwind
+}
+
+// deferredCallID returns the value that identifies a deferred call of the
+// given function pointer in runtime.startDeferredCall and the functions that
+// check it: the function pointer itself.
+func (c *Compiler) deferredCallID(fn llvm.Value) llvm.Value {
+	return c.builder.CreatePtrToInt(fn, c.uintptrType, "")
+}
+
+// emitRecover emits a call to recover() in the current function. The first
+// call claims the deferred call at the start of the function, see
+// runtime.claimDeferredCall.
+func (c *Compiler) emitRecover(frame *Frame) llvm.Value {
+	if frame.panicRecord.IsNil() {
+		// Setting the insert point before an instruction also changes the
+		// debug location, so the location of the recover() call is restored
+		// afterwards.
+		currentBlock := c.builder.GetInsertBlock()
+		var currentLocation llvm.DebugLoc
+		if c.Debug {
+			currentLocation = c.builder.GetCurrentDebugLocation()
+		}
+		entryBlock := frame.fn.LLVMFn.EntryBasicBlock()
+		if entryBlock.FirstInstruction().IsNil() {
+			c.builder.SetInsertPointAtEnd(entryBlock)
+		} else {
+			c.builder.SetInsertPointBefore(entryBlock.FirstInstruction())
+		}
+		if c.Debug {
+			c.builder.SetCurrentDebugLocation(currentLocation.Line, currentLocation.Col, currentLocation.Scope, currentLocation.InlinedAt)
+		}
+		frame.panicRecord = c.createRuntimeCall("claimDeferredCall", []llvm.Value{c.deferredCallID(frame.fn.LLVMFn)}, "recover.record")
+		c.builder.SetInsertPointAtEnd(currentBlock)
+	}
+	return c.createRuntimeCall("_recover", []llvm.Value{frame.panicRecord}, "")
+}
+
+// emitForwardDeferredCall passes on the deferred call to the called function
+// when the current function is a method wrapper (like for promoted methods)
+// that is called as a deferred call. It must be called right before the call
+// of the wrapped method.
+func (c *Compiler) emitForwardDeferredCall(frame *Frame, callee llvm.Value) {
+	if frame.fn.Synthetic == "" || frame.fn.Signature.Recv() == nil {
+		return
+	}
+	c.createRuntimeCall("forwardDeferredCall", []llvm.Value{c.deferredCallID(frame.fn.LLVMFn), c.deferredCallID(callee)}, "")
+}
+
+// createUnwindReturn returns from the current function with an undefined
+// return value, to be used while the stack is unwound.
+func (c *Compiler) createUnwindReturn(fn llvm.Value) {
+	returnType := fn.Type().ElementType().ReturnType()
+	if returnType.TypeKind() == llvm.VoidTypeKind {
+		c.builder.CreateRetVoid()
+	} else {
+		c.builder.CreateRet(llvm.Undef(returnType))
+	}
+}
+
+// lowerPanics replaces calls to runtime.supportsRecover with a constant and,
+// when unwinding is supported, inserts a check for runtime.panicking after
+// every call that may panic.
+func (c *Compiler) lowerPanics() {
+	// Landing pads call runtime.beginUnwind, so this checks whether there are
+	// any deferred calls in the program.
+	hasDefers := len(getUses(c.mod.NamedFunction("runtime.beginUnwind"))) != 0
+	supportsRecover := llvm.ConstInt(c.ctx.Int1Type(), 0, false)
+	if c.PanicStrategy != "trap" && hasDefers {
+		supportsRecover = llvm.ConstInt(c.ctx.Int1Type(), 1, false)
+	}
+
+	fn := c.mod.NamedFunction("runtime.supportsRecover")
+	for _, use := range getUses(fn) {
+		if use.IsACallInst().IsNil() || use.CalledValue() != fn {
+			panic("expected use of runtime.supportsRecover to be a call")
+		}
+		use.ReplaceAllUsesWith(supportsRecover)
+		use.EraseFromParentAsInstruction()
+	}
+	if supportsRecover.ZExtValue() == 0 {
+		// Unwind blocks are never used. See panicsMayReturn.
+		c.unwindBlocks = nil
+		return
+	}
+
+	// These functions are part of the unwinding machinery itself.
+	skipCallees := map[string]bool{
+		"runtime.beginUnwind":         true,
+		"runtime.endUnwind":           true,
+		"runtime.unrecoveredPanic":    true,
+		"runtime.goroutineStarted":    true,
+		"runtime._recover":            true,
+		"runtime.startDeferredCall":   true,
+		"runtime.forwardDeferredCall": true,
+		"runtime.claimDeferredCall":   true,
+	}
+	// The scheduler does its own unwinding of blocking functions.
+	skipCallers := map[string]bool{
+		"runtime.scheduler": true,
+	}
+
+	// The instructions inserted below do not need a debug location.
+	c.builder.SetCurrentDebugLocation(0, 0, llvm.Metadata{}, llvm.Metadata{})
+
+	panicking := c.mod.NamedGlobal("runtime.panicking")
+	callMain := c.mod.NamedFunction("runtime.callMain")
+	for fn := c.mod.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+		if fn.IsDeclaration() || skipCallers[fn.Name()] {
+			continue
+		}
+
+		// Collect all calls that may panic. Calls to external functions
+		// cannot panic, except for runtime.callMain which is replaced with a
+		// call to main.main later on.
+		var calls []llvm.Value
+		for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+			for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+				if inst.IsACallInst().IsNil() {
+					continue
+				}
+				callee := inst.CalledValue()
+				if !callee.IsAFunction().IsNil() {
+					if skipCallees[callee.Name()] || (callee.IsDeclaration() && callee != callMain) {
+						continue
+					}
+				} else if !callee.IsAInlineAsm().IsNil() {
+					continue
+				} else if isGoroutineStart(callee) {
+					// Checked by runtime.goroutineStarted instead.
+					continue
+				}
+				calls = append(calls, inst)
+			}
+		}
+		if len(calls) == 0 {
+			continue
+		}
+
+		unwind, ok := c.unwindBlocks[fn]
+		if !ok {
+			unwind = c.ctx.AddBasicBlock(fn, "unwind")
+			c.builder.SetInsertPointAtEnd(unwind)
+			c.createUnwindReturn(fn)
+		}
+
+		// Check the panicking flag after each call:
+		//     call @foo()
+		//     %panicking = load i1 @runtime.panicking
+		//     br i1 %panicking, label %unwind, label %cont
+		for _, call := range calls {
+			cont := c.splitBasicBlock(call, llvm.NextBasicBlock(call.InstructionParent()), "unwind.cont")
+			c.builder.SetInsertPointAtEnd(call.InstructionParent())
+			flag := c.builder.CreateLoad(panicking, "unwind.panicking")
+			c.builder.CreateCondBr(flag, unwind, cont)
+		}
+	}
+}
+
+// panicsMayReturn returns whether calls to runtime panic functions may return
+// to start unwinding the stack. This is only valid after lowerPanics has run.
+// Code created after that point must make sure a call to a panic function is
+// followed by a check of runtime.panicking.
+func (c *Compiler) panicsMayReturn() bool {
+	return c.unwindBlocks != nil
+}
+
+// isGoroutineStart returns whether the called value is the function pointer
+// returned by runtime.makeGoroutine, see the *ssa.Go case in parseInstr.
+func isGoroutineStart(callee llvm.Value) bool {
+	if callee.IsABitCastInst().IsNil() {
+		return false
+	}
+	call := callee.Operand(0)
+	return !call.IsACallInst().IsNil() && call.CalledValue().Name() == "runtime.makeGoroutine"
+}
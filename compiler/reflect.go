@@ -1,29 +1,85 @@
 package compiler
 
+// This file assigns type codes as expected by the reflect package, and creates
+// the side tables with extra type information that doesn't fit in a type code.
+// See src/reflect/type.go for a description of the encoding.
+
 import (
+	"encoding/binary"
+	"go/types"
 	"math/big"
-	"strings"
+
+	"tinygo.org/x/go-llvm"
 )
 
-var basicTypes = map[string]int64{
-	"bool":       1,
-	"int":        2,
-	"int8":       3,
-	"int16":      4,
-	"int32":      5,
-	"int64":      6,
-	"uint":       7,
-	"uint8":      8,
-	"uint16":     9,
-	"uint32":     10,
-	"uint64":     11,
-	"uintptr":    12,
-	"float32":    13,
-	"float64":    14,
-	"complex64":  15,
-	"complex128": 16,
-	"string":     17,
-	"unsafeptr":  18,
+var basicTypes = map[types.BasicKind]int64{
+	types.Bool:          1,
+	types.Int:           2,
+	types.Int8:          3,
+	types.Int16:         4,
+	types.Int32:         5,
+	types.Int64:         6,
+	types.Uint:          7,
+	types.Uint8:         8,
+	types.Uint16:        9,
+	types.Uint32:        10,
+	types.Uint64:        11,
+	types.Uintptr:       12,
+	types.Float32:       13,
+	types.Float64:       14,
+	types.Complex64:     15,
+	types.Complex128:    16,
+	types.String:        17,
+	types.UnsafePointer: 18,
+}
+
+// reflectSidetables lists the globals in the reflect package that are replaced
+// with the real side tables in assignTypeCodes.
+var reflectSidetables = []string{
+	"reflect.namedNonBasicTypesSidetable",
+	"reflect.arrayTypesSidetable",
+	"reflect.mapTypesSidetable",
+	"reflect.structTypesSidetable",
+	"reflect.structNamesSidetable",
+}
+
+// typeCodeAssignmentState keeps track of the type codes that have been
+// assigned so far and the side tables that are being built.
+type typeCodeAssignmentState struct {
+	// Interface and function types are simply numbered. The reflect package
+	// can't do much with these types anyway.
+	fallbackIndex int
+	fallbackTypes map[string]int
+
+	// Named basic types store the name number in the upper bits of the type
+	// code.
+	namedBasicTypes map[string]int
+
+	// Named non-basic types store an index into a side table, which contains
+	// the type code of the underlying type.
+	namedNonBasicTypes          map[string]int
+	namedNonBasicTypesSidetable []uint64
+
+	// Array types store an index into a side table, which contains the type
+	// code of the element type and the length for each array type.
+	arrayTypes          map[string]int
+	arrayTypesSidetable []uint64
+
+	// Map types store an index into a side table, which contains the type code
+	// of the key and the element type for each map type.
+	mapTypes          map[string]int
+	mapTypesSidetable []uint64
+
+	// Struct types store an offset into a side table. At that offset, the
+	// struct side table contains the struct size, the number of fields and
+	// information about each field (see src/reflect/type.go).
+	structTypes          map[string]int
+	structTypesSidetable []uint64
+
+	// Field names, tags and package paths are stored in a separate side table
+	// as a uvarint length followed by the string bytes.
+	structNames          map[string]int
+	structNamesSidetable []byte
 }
 
 func (c *Compiler) assignTypeCodes(typeSlice typeInfoSlice) {
@@ -34,17 +90,35 @@ func (c *Compiler) assignTypeCodes(typeSlice typeInfoSlice) {
 		for i, t := range typeSlice {
 			t.num = uint64(i + 1)
 		}
+		// The side tables (if any) are unused, so let them be optimized away.
+		for _, name := range reflectSidetables {
+			if global := c.mod.NamedGlobal(name); !global.IsNil() {
+				global.SetLinkage(llvm.InternalLinkage)
+			}
+		}
 		return
 	}
 
 	// Assign typecodes the way the reflect package expects.
-	fallbackIndex := 1
-	namedTypes := make(map[string]int)
+	state := &typeCodeAssignmentState{
+		fallbackIndex:      1,
+		fallbackTypes:      make(map[string]int),
+		namedBasicTypes:    make(map[string]int),
+		namedNonBasicTypes: make(map[string]int),
+		arrayTypes:         make(map[string]int),
+		mapTypes:           make(map[string]int),
+		structTypes:        make(map[string]int),
+		// The empty string is at index 0, for fields without tag or package
+		// path.
+		structNames:          map[string]int{"": 0},
+		structNamesSidetable: []byte{0},
+	}
 	for _, t := range typeSlice {
-		if t.name[:5] != "type:" {
-			panic("expected type name to start with 'type:'")
+		typ, ok := c.typeCodeTypes[t.name]
+		if !ok {
+			panic("unknown type code: " + t.name)
 		}
-		num := c.getTypeCodeNum(t.name[5:], &fallbackIndex, namedTypes)
+		num := c.getTypeCodeNum(typ, state)
 		if num.BitLen() > c.uintptrType.IntTypeWidth() || !num.IsUint64() {
 			// TODO: support this in some way, using a side table for example.
 			// That's less efficient but better than not working at all.
@@ -54,92 +128,219 @@ func (c *Compiler) assignTypeCodes(typeSlice typeInfoSlice) {
 		}
 		t.num = num.Uint64()
 	}
+
+	// Replace the side tables in the reflect package with the real ones.
+	structNames := make([]uint64, len(state.structNamesSidetable))
+	for i, b := range state.structNamesSidetable {
+		structNames[i] = uint64(b)
+	}
+	c.replaceReflectSidetable("reflect.namedNonBasicTypesSidetable", c.uintptrType, state.namedNonBasicTypesSidetable)
+	c.replaceReflectSidetable("reflect.arrayTypesSidetable", c.uintptrType, state.arrayTypesSidetable)
+	c.replaceReflectSidetable("reflect.mapTypesSidetable", c.uintptrType, state.mapTypesSidetable)
+	c.replaceReflectSidetable("reflect.structTypesSidetable", c.uintptrType, state.structTypesSidetable)
+	c.replaceReflectSidetable("reflect.structNamesSidetable", c.ctx.Int8Type(), structNames)
 }
 
 // getTypeCodeNum returns the typecode for a given type as expected by the
 // reflect package. Also see getTypeCodeName, which serializes types to a string
-// based on a types.Type value for this function.
-func (c *Compiler) getTypeCodeNum(id string, fallbackIndex *int, namedTypes map[string]int) *big.Int {
+// that is used to deduplicate types in the side tables.
+func (c *Compiler) getTypeCodeNum(typ types.Type, state *typeCodeAssignmentState) *big.Int {
 	// Note: see src/reflect/type.go for bit allocations.
-	// A type can be named or unnamed. Example of both:
-	//     basic:~foo:uint64
-	//     basic:uint64
-	// Extract the class (basic, slice, pointer, etc.), the name, and the
-	// contents of this type ID string. Allocate bits based on that, as
-	// src/runtime/types.go expects.
-	class := id[:strings.IndexByte(id, ':')]
-	value := id[len(class)+1:]
+	// A type can be named or unnamed. Allocate bits based on the class (basic,
+	// slice, pointer, etc.) and the name, as src/reflect/type.go expects.
 	name := ""
-	if value[0] == '~' {
-		name = value[1:strings.IndexByte(value, ':')]
-		value = value[len(name)+2:]
+	if named, ok := typ.(*types.Named); ok {
+		name = named.String()
 	}
-	if class == "basic" {
+	if basic, ok := typ.Underlying().(*types.Basic); ok {
 		// Basic types follow the following bit pattern:
 		//    ...xxxxx0
 		// where xxxxx is allocated for the 18 possible basic types and all the
 		// upper bits are used to indicate the named type.
-		num, ok := basicTypes[value]
+		num, ok := basicTypes[basic.Kind()]
 		if !ok {
-			panic("invalid basic type: " + id)
+			panic("invalid basic type: " + typ.String())
 		}
 		if name != "" {
 			// This type is named, set the upper bits to the name ID.
-			num |= int64(getNamedTypeNum(namedTypes, name)) << 5
+			num |= int64(getNamedTypeNum(state.namedBasicTypes, name)) << 5
 		}
 		return big.NewInt(num << 1)
-	} else {
-		// Complex types use the following bit pattern:
-		//    ...nxxx1
-		// where xxx indicates the complex type (any non-basic type). The upper
-		// bits contain whatever the type contains. Types that wrap a single
-		// other type (channel, interface, pointer, slice) just contain the bits
-		// of the wrapped type. Other types (like struct) have a different
-		// method of encoding the contents of the type.
-		var num *big.Int
-		var classNumber int64
-		switch class {
-		case "chan":
-			num = c.getTypeCodeNum(value, fallbackIndex, namedTypes)
-			classNumber = 0
-		case "interface":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 1
-		case "pointer":
-			num = c.getTypeCodeNum(value, fallbackIndex, namedTypes)
-			classNumber = 2
-		case "slice":
-			num = c.getTypeCodeNum(value, fallbackIndex, namedTypes)
-			classNumber = 3
-		case "array":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 4
-		case "func":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 5
-		case "map":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 6
-		case "struct":
-			num = big.NewInt(int64(*fallbackIndex))
-			*fallbackIndex++
-			classNumber = 7
-		default:
-			panic("unknown type kind: " + id)
+	}
+
+	// Complex types use the following bit pattern:
+	//    ...nxxx1
+	// where xxx indicates the complex type (any non-basic type). The upper
+	// bits contain whatever the type contains. Types that wrap a single
+	// other type (channel, pointer, slice) just contain the bits of the
+	// wrapped type. Other types (like struct) refer to a side table or are
+	// simply numbered.
+	var classNumber int64
+	switch typ.Underlying().(type) {
+	case *types.Chan:
+		classNumber = 0
+	case *types.Interface:
+		classNumber = 1
+	case *types.Pointer:
+		classNumber = 2
+	case *types.Slice:
+		classNumber = 3
+	case *types.Array:
+		classNumber = 4
+	case *types.Signature:
+		classNumber = 5
+	case *types.Map:
+		classNumber = 6
+	case *types.Struct:
+		classNumber = 7
+	default:
+		panic("unknown type kind: " + typ.String())
+	}
+
+	if name != "" {
+		// Named types store the index into the named type side table, which
+		// contains the type code of the underlying type.
+		index, ok := state.namedNonBasicTypes[name]
+		if !ok {
+			// Reserve the index before looking at the underlying type, as the
+			// underlying type may refer back to this named type.
+			index = len(state.namedNonBasicTypesSidetable)
+			state.namedNonBasicTypes[name] = index
+			state.namedNonBasicTypesSidetable = append(state.namedNonBasicTypesSidetable, 0)
+			underlying := c.getTypeCodeNum(typ.Underlying(), state)
+			if !underlying.IsUint64() {
+				panic("compiler: could not store type code number in side table")
+			}
+			state.namedNonBasicTypesSidetable[index] = underlying.Uint64()
 		}
-		if name == "" {
-			num.Lsh(num, 5).Or(num, big.NewInt((classNumber<<1)+1))
-		} else {
-			// TODO: store num in a sidetable
-			num = big.NewInt(int64(getNamedTypeNum(namedTypes, name))<<1 | 1)
-			num.Lsh(num, 4).Or(num, big.NewInt((classNumber<<1)+1))
+		num := big.NewInt(int64(index)<<1 | 1)
+		return num.Lsh(num, 4).Or(num, big.NewInt((classNumber<<1)+1))
+	}
+
+	var num *big.Int
+	switch t := typ.(type) {
+	case *types.Chan:
+		num = c.getTypeCodeNum(t.Elem(), state)
+	case *types.Pointer:
+		num = c.getTypeCodeNum(t.Elem(), state)
+	case *types.Slice:
+		num = c.getTypeCodeNum(t.Elem(), state)
+	case *types.Interface, *types.Signature:
+		id := getTypeCodeName(t)
+		index, ok := state.fallbackTypes[id]
+		if !ok {
+			index = state.fallbackIndex
+			state.fallbackIndex++
+			state.fallbackTypes[id] = index
 		}
-		return num
+		num = big.NewInt(int64(index))
+	case *types.Array:
+		id := getTypeCodeName(t)
+		index, ok := state.arrayTypes[id]
+		if !ok {
+			elem := c.getTypeCodeSidetableNum(t.Elem(), state)
+			index = len(state.arrayTypesSidetable) / 2
+			state.arrayTypes[id] = index
+			state.arrayTypesSidetable = append(state.arrayTypesSidetable, elem, uint64(t.Len()))
+		}
+		num = big.NewInt(int64(index))
+	case *types.Map:
+		id := getTypeCodeName(t)
+		index, ok := state.mapTypes[id]
+		if !ok {
+			key := c.getTypeCodeSidetableNum(t.Key(), state)
+			elem := c.getTypeCodeSidetableNum(t.Elem(), state)
+			index = len(state.mapTypesSidetable) / 2
+			state.mapTypes[id] = index
+			state.mapTypesSidetable = append(state.mapTypesSidetable, key, elem)
+		}
+		num = big.NewInt(int64(index))
+	case *types.Struct:
+		id := getTypeCodeName(t)
+		offset, ok := state.structTypes[id]
+		if !ok {
+			// Each struct is stored as:
+			//     size, number of fields, fields...
+			// where each field is stored as:
+			//     type code, offset, name index << 1 | embedded, tag index, package path index
+			llvmType := c.getLLVMType(t)
+			entry := []uint64{c.targetData.TypeAllocSize(llvmType), uint64(t.NumFields())}
+			for i := 0; i < t.NumFields(); i++ {
+				field := t.Field(i)
+				embedded := uint64(0)
+				if field.Embedded() {
+					embedded = 1
+				}
+				pkgPath := ""
+				if !field.Exported() {
+					pkgPath = field.Pkg().Path()
+				}
+				entry = append(entry,
+					c.getTypeCodeSidetableNum(field.Type(), state),
+					c.targetData.ElementOffset(llvmType, i),
+					uint64(state.getStructName(field.Name()))<<1|embedded,
+					uint64(state.getStructName(t.Tag(i))),
+					uint64(state.getStructName(pkgPath)))
+			}
+			offset = len(state.structTypesSidetable)
+			state.structTypes[id] = offset
+			state.structTypesSidetable = append(state.structTypesSidetable, entry...)
+		}
+		num = big.NewInt(int64(offset))
+	}
+	return num.Lsh(num, 5).Or(num, big.NewInt((classNumber<<1)+1))
+}
+
+// getTypeCodeSidetableNum returns the type code for the given type, for storing
+// in a side table.
+func (c *Compiler) getTypeCodeSidetableNum(typ types.Type, state *typeCodeAssignmentState) uint64 {
+	num := c.getTypeCodeNum(typ, state)
+	if !num.IsUint64() {
+		panic("compiler: could not store type code number in side table")
+	}
+	return num.Uint64()
+}
+
+// getStructName returns the index of the given string in the struct names side
+// table, adding it if it isn't present already.
+func (s *typeCodeAssignmentState) getStructName(name string) int {
+	if index, ok := s.structNames[name]; ok {
+		return index
+	}
+	index := len(s.structNamesSidetable)
+	s.structNames[name] = index
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(len(name)))
+	s.structNamesSidetable = append(s.structNamesSidetable, buf[:n]...)
+	s.structNamesSidetable = append(s.structNamesSidetable, name...)
+	return index
+}
+
+// replaceReflectSidetable replaces a side table declared in the reflect package
+// with a global of the right size, containing the given values. Side tables are
+// only declared as a single value as their size is not known until all type
+// codes have been assigned.
+func (c *Compiler) replaceReflectSidetable(name string, elementType llvm.Type, data []uint64) {
+	global := c.mod.NamedGlobal(name)
+	if global.IsNil() {
+		// This side table is not used.
+		return
+	}
+	values := make([]llvm.Value, len(data))
+	for i, value := range data {
+		if elementType.IntTypeWidth() < 64 && value>>uint(elementType.IntTypeWidth()) != 0 {
+			panic("compiler: could not store value in side table " + name)
+		}
+		values[i] = llvm.ConstInt(elementType, value, false)
 	}
+	initializer := llvm.ConstArray(elementType, values)
+	sidetable := llvm.AddGlobal(c.mod, initializer.Type(), name+".sidetable")
+	sidetable.SetInitializer(initializer)
+	sidetable.SetGlobalConstant(true)
+	sidetable.SetLinkage(llvm.InternalLinkage)
+	global.ReplaceAllUsesWith(llvm.ConstBitCast(sidetable, global.Type()))
+	global.EraseFromParentAsGlobal()
+	sidetable.SetName(name)
 }
 
 // getNamedTypeNum returns an appropriate (unique) number for the given named
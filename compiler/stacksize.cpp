@@ -0,0 +1,14 @@
+// This file provides a C wrapper for a TargetMachine option that is not
+// available in the LLVM C API.
+
+#include <llvm-c/TargetMachine.h>
+#include <llvm/Target/TargetMachine.h>
+
+extern "C" {
+
+void tinygo_setEmitStackSizeSection(LLVMTargetMachineRef tm) {
+	llvm::TargetMachine *machine = reinterpret_cast<llvm::TargetMachine*>(tm);
+	machine->Options.EmitStackSizeSection = true;
+}
+
+} // extern "C"
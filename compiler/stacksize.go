@@ -0,0 +1,99 @@
+package compiler
+
+// This file provides the information needed for the stack usage analysis of
+// -print-stacks: the call graph of the program and the functions that start
+// with an empty stack. The stack frame size of every function is emitted by
+// LLVM in the .stack_sizes section of the object file.
+
+import (
+	"strings"
+	"unsafe"
+
+	"tinygo.org/x/go-llvm"
+)
+
+/*
+#include <llvm-c/TargetMachine.h>
+void tinygo_setEmitStackSizeSection(LLVMTargetMachineRef tm);
+*/
+import "C"
+
+// setEmitStackSizeSection makes the target machine emit a .stack_sizes section
+// with the stack frame size of each function.
+func setEmitStackSizeSection(machine llvm.TargetMachine) {
+	C.tinygo_setEmitStackSizeSection(C.LLVMTargetMachineRef(unsafe.Pointer(machine.C)))
+}
+
+// CallGraph returns the functions that are called by each function defined in
+// the module, by name. Indirect calls (through a function pointer or by
+// resuming a coroutine) are included as an empty name. Calls to LLVM
+// intrinsics are left out, except for the memory intrinsics that may be
+// lowered to a call to memcpy, memmove or memset.
+//
+// It should be called after optimization, so that the call graph is close to
+// that of the machine code.
+func (c *Compiler) CallGraph() map[string][]string {
+	graph := make(map[string][]string)
+	for fn := c.mod.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+		if fn.IsDeclaration() {
+			continue
+		}
+		callees := []string{}
+		seen := make(map[string]bool)
+		for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+			for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+				if inst.IsACallInst().IsNil() {
+					continue
+				}
+				callee := inst.CalledValue()
+				if !callee.IsAInlineAsm().IsNil() {
+					continue
+				}
+				if !callee.IsAConstantExpr().IsNil() && callee.Opcode() == llvm.BitCast {
+					callee = callee.Operand(0)
+				}
+				name := ""
+				if !callee.IsAFunction().IsNil() {
+					name = callee.Name()
+				}
+				if strings.HasPrefix(name, "llvm.") {
+					switch {
+					case strings.HasPrefix(name, "llvm.memcpy."):
+						name = "memcpy"
+					case strings.HasPrefix(name, "llvm.memmove."):
+						name = "memmove"
+					case strings.HasPrefix(name, "llvm.memset."):
+						name = "memset"
+					default:
+						continue
+					}
+				}
+				if !seen[name] {
+					seen[name] = true
+					callees = append(callees, name)
+				}
+			}
+		}
+		graph[fn.Name()] = callees
+	}
+	return graph
+}
+
+// StackRoots returns the functions that start with an empty stack: functions
+// exported with //go:export (like main and interrupt handlers) and functions
+// started as a goroutine. The goroutines are only known after optimization.
+func (c *Compiler) StackRoots() (exported, goroutines []string) {
+	for _, f := range c.ir.Functions {
+		if f.IsExported() && len(f.Blocks) != 0 {
+			exported = append(exported, f.LinkName())
+		}
+	}
+	seen := make(map[string]bool)
+	for _, name := range c.goroutineStarts {
+		if !seen[name] {
+			seen[name] = true
+			goroutines = append(goroutines, name)
+		}
+	}
+	return exported, goroutines
+}
@@ -60,9 +60,13 @@ func (c *Compiler) getGlobal(g *ssa.Global) llvm.Value {
 	if llvmGlobal.IsNil() {
 		llvmType := c.getLLVMType(g.Type().(*types.Pointer).Elem())
 		llvmGlobal = llvm.AddGlobal(c.mod, llvmType, info.linkName)
-		if !info.extern {
+		if !info.extern && c.ownsGlobal(g) {
 			llvmGlobal.SetInitializer(c.getZeroValue(llvmType))
-			llvmGlobal.SetLinkage(llvm.InternalLinkage)
+			if c.PackageCache == nil {
+				// With incremental compilation, other package modules may
+				// use this global. It is made internal after linking them.
+				llvmGlobal.SetLinkage(llvm.InternalLinkage)
+			}
 		}
 	}
 	return llvmGlobal
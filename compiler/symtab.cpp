@@ -0,0 +1,32 @@
+// This file provides a C wrapper to read the position of an instruction in the
+// function that contains it after inlining, which is not available in the LLVM
+// C API.
+
+#include <llvm-c/Core.h>
+#include <llvm/IR/DebugInfoMetadata.h>
+#include <llvm/IR/Instruction.h>
+
+extern "C" {
+
+// tinygo_getCallerDebugLoc returns the line of the debug location of an
+// instruction and stores its directory and file name. For an instruction that
+// was inlined, the location of the inlined call is returned instead. It
+// returns 0 if the instruction has no debug location.
+unsigned tinygo_getCallerDebugLoc(LLVMValueRef inst, const char **dir, unsigned *dirLength, const char **file, unsigned *fileLength) {
+	const llvm::DILocation *loc = llvm::unwrap<llvm::Instruction>(inst)->getDebugLoc().get();
+	if (!loc) {
+		return 0;
+	}
+	while (const llvm::DILocation *inlinedAt = loc->getInlinedAt()) {
+		loc = inlinedAt;
+	}
+	llvm::StringRef dirName = loc->getDirectory();
+	llvm::StringRef fileName = loc->getFilename();
+	*dir = dirName.data();
+	*dirLength = dirName.size();
+	*file = fileName.data();
+	*fileLength = fileName.size();
+	return loc->getLine();
+}
+
+} // extern "C"
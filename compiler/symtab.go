@@ -0,0 +1,434 @@
+package compiler
+
+// This file creates the symbol table used by runtime.Caller, runtime.Callers,
+// runtime.FuncForPC and the traceback printed on a panic. See
+// src/runtime/stack.go for the runtime side.
+//
+// The symbol table is derived from the debug information attached to each
+// function: it has an entry for every function with debug information,
+// containing its address, size, name and the position of its declaration.
+// Function addresses are only known after linking, so the table is not sorted
+// and the runtime does a linear search. Function sizes are only known after
+// code generation, so they are patched into the object file by
+// patchSymtabSizes.
+//
+// Next to the symbol table there is a line table, with the position of every
+// call in these functions. A bit of inline assembly after each call defines a
+// label just after the call instruction and adds the address of this label,
+// the line and an index into runtime.symtabFiles to the tinygo_lines section.
+// The linker collects these entries and defines __start_tinygo_lines and
+// __stop_tinygo_lines around them. The runtime finds the line of a return
+// address by looking for the first label at or after it within the same
+// function. This relies on ELF linkers, so the line table is left empty on
+// Darwin and the declaration line of the function is reported instead.
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"tinygo.org/x/go-llvm"
+)
+
+/*
+#include <stdlib.h>
+#include <llvm-c/Core.h>
+unsigned tinygo_getCallerDebugLoc(LLVMValueRef inst, const char **dir, unsigned *dirLength, const char **file, unsigned *fileLength);
+*/
+import "C"
+
+// symtabEntry is the information about a single function that is stored in
+// the symbol table, see runtime.Func.
+type symtabEntry struct {
+	name string
+	file string
+	line int
+}
+
+// Symbol table entries are stored as string attributes of the function they
+// describe, so that they are kept in package modules loaded from the cache (see
+// packages.go) and follow the function when it is renamed while linking.
+const (
+	symtabNameAttr = "tinygo-symtab-name"
+	symtabFileAttr = "tinygo-symtab-file"
+	symtabLineAttr = "tinygo-symtab-line"
+)
+
+// setSymtabEntry stores the symbol table entry of a function.
+func (c *Compiler) setSymtabEntry(fn llvm.Value, entry symtabEntry) {
+	fn.AddFunctionAttr(c.ctx.CreateStringAttribute(symtabNameAttr, entry.name))
+	fn.AddFunctionAttr(c.ctx.CreateStringAttribute(symtabFileAttr, entry.file))
+	fn.AddFunctionAttr(c.ctx.CreateStringAttribute(symtabLineAttr, strconv.Itoa(entry.line)))
+}
+
+// getSymtabEntry returns the symbol table entry stored with setSymtabEntry, if
+// the function has one.
+func getSymtabEntry(fn llvm.Value) (symtabEntry, bool) {
+	name, ok := getStringFunctionAttr(fn, symtabNameAttr)
+	if !ok {
+		return symtabEntry{}, false
+	}
+	file, _ := getStringFunctionAttr(fn, symtabFileAttr)
+	line, _ := getStringFunctionAttr(fn, symtabLineAttr)
+	lineNum, _ := strconv.Atoi(line)
+	return symtabEntry{name: name, file: file, line: lineNum}, true
+}
+
+// getStringFunctionAttr returns the value of a string attribute of a function.
+func getStringFunctionAttr(fn llvm.Value, key string) (string, bool) {
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+	functionIndex := ^C.LLVMAttributeIndex(0) // LLVMAttributeFunctionIndex
+	attr := C.LLVMGetStringAttributeAtIndex(llvmValueRef(fn), functionIndex, ckey, C.unsigned(len(key)))
+	if attr == nil {
+		return "", false
+	}
+	var length C.unsigned
+	value := C.LLVMGetStringAttributeValue(attr, &length)
+	return C.GoStringN(value, C.int(length)), true
+}
+
+// hasSymtab returns whether a symbol table is emitted for this program.
+func (c *Compiler) hasSymtab() bool {
+	if !c.Symtab || !c.Debug {
+		return false
+	}
+	// The runtime walks the stack using frame pointers, which is not possible
+	// on AVR and WebAssembly.
+	return !strings.HasPrefix(c.Triple, "avr") && !strings.HasPrefix(c.Triple, "wasm")
+}
+
+// hasLineTable returns whether the line table with the position of each call is
+// emitted. It depends on the linker defining the start and end of the
+// tinygo_lines section, which only ELF linkers do.
+func (c *Compiler) hasLineTable() bool {
+	return c.hasSymtab() && c.GOOS != "darwin"
+}
+
+// lowerSymtabCheck replaces calls to runtime.hasSymtab with a constant, so
+// that all code using the symbol table is optimized away when it is disabled.
+// When it is enabled, all functions are marked to keep their frame pointer so
+// that the stack can be walked.
+func (c *Compiler) lowerSymtabCheck() {
+	hasSymtab := llvm.ConstInt(c.ctx.Int1Type(), 0, false)
+	if c.hasSymtab() {
+		hasSymtab = llvm.ConstInt(c.ctx.Int1Type(), 1, false)
+		attr := c.ctx.CreateStringAttribute("no-frame-pointer-elim", "true")
+		for fn := c.mod.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+			if !fn.IsDeclaration() {
+				fn.AddFunctionAttr(attr)
+			}
+		}
+	}
+
+	fn := c.mod.NamedFunction("runtime.hasSymtab")
+	if fn.IsNil() {
+		return
+	}
+	for _, use := range getUses(fn) {
+		if use.IsACallInst().IsNil() || use.CalledValue() != fn {
+			panic("expected use of runtime.hasSymtab to be a call")
+		}
+		use.ReplaceAllUsesWith(hasSymtab)
+		use.EraseFromParentAsInstruction()
+	}
+}
+
+// emitSymtab fills in runtime.symtab and runtime.symtabLength with an entry
+// for each function that remains after optimization, and adds a line table
+// entry after each call in these functions. Without a line table, the bounds of
+// the line table are replaced with an empty global, so that the program still
+// links when the code using them was not optimized away.
+func (c *Compiler) emitSymtab() {
+	var empty llvm.Value
+	for _, name := range []string{"__start_tinygo_lines", "__stop_tinygo_lines"} {
+		bound := c.mod.NamedGlobal(name)
+		if bound.IsNil() {
+			continue
+		}
+		if c.hasLineTable() {
+			// The linker only defines these symbols if there is at least one
+			// line table entry.
+			bound.SetLinkage(llvm.ExternalWeakLinkage)
+			continue
+		}
+		// Without a line table, the start and end are the same empty global.
+		if empty.IsNil() {
+			empty = llvm.AddGlobal(c.mod, bound.Type().ElementType(), "runtime.symtabLines")
+			empty.SetInitializer(llvm.ConstNull(bound.Type().ElementType()))
+			empty.SetLinkage(llvm.InternalLinkage)
+			empty.SetGlobalConstant(true)
+		}
+		bound.ReplaceAllUsesWith(empty)
+		bound.EraseFromParentAsGlobal()
+	}
+
+	if !c.hasSymtab() {
+		return
+	}
+	symtab := c.mod.NamedGlobal("runtime.symtab")
+	if symtab.IsNil() {
+		return // not used
+	}
+
+	funcType := c.getLLVMRuntimeType("Func")
+	stringGlobals := make(map[string]llvm.Value)
+	files := make(map[string]int)
+	var entries []llvm.Value
+	c.symtabFuncs = nil
+	for fn := c.mod.FirstFunction(); !fn.IsNil(); fn = llvm.NextFunction(fn) {
+		if fn.IsDeclaration() {
+			continue
+		}
+		entry, ok := getSymtabEntry(fn)
+		if !ok {
+			// Coroutine lowering splits a function into multiple parts, with
+			// names like "main.foo.resume". Use the information of the
+			// original function for them.
+			dot := strings.LastIndexByte(fn.Name(), '.')
+			if dot < 0 {
+				continue
+			}
+			switch fn.Name()[dot+1:] {
+			case "resume", "destroy", "cleanup":
+				if original := c.mod.NamedFunction(fn.Name()[:dot]); !original.IsNil() {
+					entry, ok = getSymtabEntry(original)
+				}
+			}
+			if !ok {
+				continue
+			}
+		}
+		c.symtabFuncs = append(c.symtabFuncs, fn.Name())
+		entries = append(entries, llvm.ConstNamedStruct(funcType, []llvm.Value{
+			llvm.ConstPtrToInt(fn, c.uintptrType),
+			llvm.ConstInt(c.uintptrType, 0, false), // size, see patchSymtabSizes
+			c.getSymtabString(entry.name, stringGlobals),
+			c.getSymtabString(entry.file, stringGlobals),
+			llvm.ConstInt(c.intType, uint64(entry.line), false),
+		}))
+		if c.hasLineTable() {
+			c.emitLineTableEntries(fn, files)
+		}
+	}
+	c.replaceSymtabGlobal(symtab, llvm.ConstArray(funcType, entries))
+
+	length := c.mod.NamedGlobal("runtime.symtabLength")
+	if !length.IsNil() {
+		length.SetInitializer(llvm.ConstInt(c.uintptrType, uint64(len(entries)), false))
+		length.SetLinkage(llvm.InternalLinkage)
+		length.SetGlobalConstant(true)
+	}
+
+	fileNames := make([]llvm.Value, len(files))
+	for file, index := range files {
+		fileNames[index] = c.getSymtabString(file, stringGlobals)
+	}
+	if symtabFiles := c.mod.NamedGlobal("runtime.symtabFiles"); !symtabFiles.IsNil() {
+		c.replaceSymtabGlobal(symtabFiles, llvm.ConstArray(c.getLLVMRuntimeType("_string"), fileNames))
+	}
+}
+
+// replaceSymtabGlobal replaces the external global declared in the runtime with
+// an internal global with the given contents.
+func (c *Compiler) replaceSymtabGlobal(external, value llvm.Value) {
+	name := external.Name()
+	global := llvm.AddGlobal(c.mod, value.Type(), name+".tmp")
+	global.SetInitializer(value)
+	global.SetLinkage(llvm.InternalLinkage)
+	global.SetGlobalConstant(true)
+	external.ReplaceAllUsesWith(llvm.ConstBitCast(global, external.Type()))
+	external.EraseFromParentAsGlobal()
+	global.SetName(name)
+}
+
+// emitLineTableEntries adds a line table entry after every call in fn that has
+// a debug location. The file names are collected in files, which maps them to
+// their index in runtime.symtabFiles.
+func (c *Compiler) emitLineTableEntries(fn llvm.Value, files map[string]int) {
+	// The address is stored relative to the entry, so that position
+	// independent executables need no dynamic relocations in this section.
+	// RISC-V has no relocation for such a difference, but it is only used
+	// for bare metal programs which are not position independent.
+	address := ".long 1b - ."
+	if strings.HasPrefix(c.Triple, "riscv") {
+		address = ".long 1b"
+	}
+	asmType := llvm.FunctionType(c.ctx.VoidType(), nil, false)
+	for bb := fn.FirstBasicBlock(); !bb.IsNil(); bb = llvm.NextBasicBlock(bb) {
+		for inst := bb.FirstInstruction(); !inst.IsNil(); inst = llvm.NextInstruction(inst) {
+			if inst.IsACallInst().IsNil() {
+				continue
+			}
+			callee := inst.CalledValue()
+			if !callee.IsAInlineAsm().IsNil() || strings.HasPrefix(callee.Name(), "llvm.") {
+				// Not a call to another function.
+				continue
+			}
+			file, line := instructionPosition(inst)
+			if line == 0 {
+				continue
+			}
+			index, ok := files[file]
+			if !ok {
+				index = len(files)
+				files[file] = index
+			}
+			asm := fmt.Sprintf("1:\n.pushsection tinygo_lines,\"a\"\n.p2align 2\n%s\n.long %d\n.long %d\n.popsection", address, line, index)
+			c.builder.SetInsertPointBefore(llvm.NextInstruction(inst))
+			inst = c.builder.CreateCall(llvm.InlineAsm(asmType, asm, "", true, false, 0), nil, "")
+		}
+	}
+}
+
+// instructionPosition returns the file name and line of the debug location of
+// an instruction, or line 0 if it has no debug location. For an instruction in
+// an inlined function, this is the position of the inlined call, as the line
+// table describes the function that contains the call after inlining.
+func instructionPosition(inst llvm.Value) (file string, line int) {
+	var dir, name *C.char
+	var dirLength, nameLength C.unsigned
+	line = int(C.tinygo_getCallerDebugLoc(llvmValueRef(inst), &dir, &dirLength, &name, &nameLength))
+	if line == 0 {
+		return "", 0
+	}
+	dirName := C.GoStringN(dir, C.int(dirLength))
+	file = C.GoStringN(name, C.int(nameLength))
+	if dirName != "" {
+		file = filepath.Join(dirName, file)
+	}
+	return file, line
+}
+
+// getSymtabString returns a constant string for use in the symbol table. Equal
+// strings (like file names) share the same backing global.
+func (c *Compiler) getSymtabString(s string, globals map[string]llvm.Value) llvm.Value {
+	global, ok := globals[s]
+	if !ok {
+		global = llvm.AddGlobal(c.mod, llvm.ArrayType(c.ctx.Int8Type(), len(s)), "runtime.symtab$string")
+		global.SetInitializer(c.ctx.ConstString(s, false))
+		global.SetLinkage(llvm.InternalLinkage)
+		global.SetGlobalConstant(true)
+		global.SetUnnamedAddr(true)
+		globals[s] = global
+	}
+	zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
+	strPtr := llvm.ConstInBoundsGEP(global, []llvm.Value{zero, zero})
+	strLen := llvm.ConstInt(c.uintptrType, uint64(len(s)), false)
+	return llvm.ConstNamedStruct(c.getLLVMRuntimeType("_string"), []llvm.Value{strPtr, strLen})
+}
+
+// patchSymtabSizes fills in the size of each function in runtime.symtab in the
+// given object file. Function sizes are read from the ELF symbol table. Mach-O
+// symbols have no size, so there the distance to the next symbol is used.
+func (c *Compiler) patchSymtabSizes(object []byte) error {
+	if len(c.symtabFuncs) == 0 {
+		return nil
+	}
+	var sizes map[string]uint64
+	var tableOffset uint64
+	var found bool
+	if f, err := elf.NewFile(bytes.NewReader(object)); err == nil {
+		sizes, tableOffset, found, err = elfSymbolSizes(f)
+		if err != nil {
+			return err
+		}
+	} else if f, err := macho.NewFile(bytes.NewReader(object)); err == nil {
+		sizes, tableOffset, found = machoSymbolSizes(f)
+	} else {
+		return errors.New("could not read object file to add function sizes to the symbol table")
+	}
+	if !found {
+		return errors.New("could not find runtime.symtab in object file")
+	}
+
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	if c.targetData.ByteOrder() == llvm.BigEndian {
+		byteOrder = binary.BigEndian
+	}
+	funcType := c.getLLVMRuntimeType("Func")
+	entrySize := c.targetData.TypeAllocSize(funcType)
+	sizeOffset := c.targetData.ElementOffset(funcType, 1)
+	sizeSize := c.targetData.TypeAllocSize(c.uintptrType)
+	for i, name := range c.symtabFuncs {
+		offset := tableOffset + uint64(i)*entrySize + sizeOffset
+		if offset+sizeSize > uint64(len(object)) {
+			return errors.New("runtime.symtab is outside of the object file")
+		}
+		if sizeSize == 8 {
+			byteOrder.PutUint64(object[offset:], sizes[name])
+		} else {
+			byteOrder.PutUint32(object[offset:], uint32(sizes[name]))
+		}
+	}
+	return nil
+}
+
+// elfSymbolSizes returns the size of each function in an ELF object file, and
+// the file offset of runtime.symtab.
+func elfSymbolSizes(f *elf.File) (sizes map[string]uint64, tableOffset uint64, found bool, err error) {
+	symbols, err := f.Symbols()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	sizes = make(map[string]uint64)
+	for _, sym := range symbols {
+		if elf.ST_TYPE(sym.Info) == elf.STT_FUNC {
+			sizes[sym.Name] = sym.Size
+		} else if sym.Name == "runtime.symtab" && int(sym.Section) < len(f.Sections) {
+			tableOffset = f.Sections[sym.Section].Offset + sym.Value
+			found = true
+		}
+	}
+	return sizes, tableOffset, found, nil
+}
+
+// machoSymbolSizes returns the size of each symbol in a Mach-O object file,
+// which is the distance to the next symbol in the same section, and the file
+// offset of runtime.symtab.
+func machoSymbolSizes(f *macho.File) (sizes map[string]uint64, tableOffset uint64, found bool) {
+	if f.Symtab == nil {
+		return nil, 0, false
+	}
+	var symbols []macho.Symbol
+	for _, sym := range f.Symtab.Syms {
+		if sym.Type&0xe0 == 0 && sym.Sect != 0 && int(sym.Sect) <= len(f.Sections) { // not a debug symbol (N_STAB)
+			symbols = append(symbols, sym)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].Sect != symbols[j].Sect {
+			return symbols[i].Sect < symbols[j].Sect
+		}
+		return symbols[i].Value < symbols[j].Value
+	})
+	sizes = make(map[string]uint64)
+	for i, sym := range symbols {
+		section := f.Sections[sym.Sect-1]
+		name := strings.TrimPrefix(sym.Name, "_")
+		if name == "runtime.symtab" {
+			tableOffset = uint64(section.Offset) + sym.Value - section.Addr
+			found = true
+		}
+		end := section.Addr + section.Size
+		for _, next := range symbols[i+1:] {
+			if next.Sect != sym.Sect {
+				break
+			}
+			if next.Value > sym.Value {
+				end = next.Value
+				break
+			}
+		}
+		sizes[name] = end - sym.Value
+	}
+	return sizes, tableOffset, found
+}
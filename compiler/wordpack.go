@@ -38,7 +38,8 @@ func (c *Compiler) emitPointerPack(values []llvm.Value) llvm.Value {
 	} else {
 		// Packed data is bigger than a pointer, so allocate it on the heap.
 		sizeValue := llvm.ConstInt(c.uintptrType, size, false)
-		packedHeapAlloc = c.createRuntimeCall("alloc", []llvm.Value{sizeValue}, "")
+		layoutValue := c.getObjectLayout(packedType)
+		packedHeapAlloc = c.createRuntimeCall("alloc", []llvm.Value{sizeValue, layoutValue}, "")
 		if c.needsStackObjects() {
 			c.trackPointer(packedHeapAlloc)
 		}
@@ -0,0 +1,241 @@
+package main
+
+// This file implements `tinygo env` and `tinygo list`, which show the
+// configuration TinyGo uses for a target and the packages it loads, and
+// `tinygo targets` and `tinygo target-check`, which show the target
+// specifications. They help debugging problems with the environment, the build
+// and custom targets.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tinygo-org/tinygo/compiler"
+	"github.com/tinygo-org/tinygo/loader"
+)
+
+// envInfo is the effective configuration of TinyGo for a target, as printed by
+// `tinygo env`.
+type envInfo struct {
+	GOOS        string
+	GOARCH      string
+	GOROOT      string
+	GOPATH      string
+	TINYGOROOT  string
+	TINYGOCACHE string
+	GC          string
+	BuildTags   []string
+	Target      *TargetSpec // the target after merging all inherited targets
+}
+
+// listedPackage is a package as printed by `tinygo list -json`.
+type listedPackage struct {
+	ImportPath string
+	Name       string
+	Dir        string
+	GoFiles    []string `json:",omitempty"`
+	CgoFiles   []string `json:",omitempty"`
+	Imports    []string `json:",omitempty"`
+	Overlay    bool     `json:",omitempty"` // loaded from TINYGOROOT instead of GOROOT or GOPATH
+}
+
+// newEnvCompiler creates a compiler for the target, which is only used to load
+// packages and not to generate code.
+func newEnvCompiler(pkgName string, spec *TargetSpec, config *BuildConfig) (*compiler.Compiler, error) {
+	compilerConfig, err := newCompilerConfig(spec, config)
+	if err != nil {
+		return nil, err
+	}
+	compilerConfig.Debug = false
+	compilerConfig.PackageCache = nil
+	return compiler.NewCompiler(pkgName, compilerConfig)
+}
+
+// Env prints the configuration that is used to compile programs for the target:
+// the paths TinyGo uses, the build tags and the target specification. With
+// jsonOutput, the configuration is printed as JSON including the complete
+// target specification.
+func Env(target string, config *BuildConfig, jsonOutput bool) error {
+	spec, err := LoadTarget(target)
+	if err != nil {
+		return err
+	}
+	c, err := newEnvCompiler("main", spec, config)
+	if err != nil {
+		return err
+	}
+	env := &envInfo{
+		GOOS:        c.GOOS,
+		GOARCH:      c.GOARCH,
+		GOROOT:      c.GOROOT,
+		GOPATH:      c.GOPATH,
+		TINYGOROOT:  c.TINYGOROOT,
+		TINYGOCACHE: cacheDir(),
+		BuildTags:   c.AllBuildTags(),
+		Target:      spec,
+	}
+	for _, tag := range env.BuildTags {
+		if strings.HasPrefix(tag, "gc.") {
+			env.GC = tag[len("gc."):]
+		}
+	}
+	if jsonOutput {
+		return printJSON(os.Stdout, env)
+	}
+	fmt.Printf("GOOS=%q\n", env.GOOS)
+	fmt.Printf("GOARCH=%q\n", env.GOARCH)
+	fmt.Printf("GOROOT=%q\n", env.GOROOT)
+	fmt.Printf("GOPATH=%q\n", env.GOPATH)
+	fmt.Printf("TINYGOROOT=%q\n", env.TINYGOROOT)
+	fmt.Printf("TINYGOCACHE=%q\n", env.TINYGOCACHE)
+	fmt.Printf("LLVM_TARGET=%q\n", spec.Triple)
+	fmt.Printf("CPU=%q\n", spec.CPU)
+	fmt.Printf("GC=%q\n", env.GC)
+	fmt.Printf("BUILDTAGS=%q\n", strings.Join(env.BuildTags, " "))
+	return nil
+}
+
+// List prints the import path of the given package, or of the package and all
+// its dependencies with deps, as they are loaded for the target. The
+// dependencies are printed before the packages that import them. With
+// jsonOutput, more information about each package is printed as JSON,
+// including whether it was overlaid by a package in TINYGOROOT.
+func List(pkgName, target string, config *BuildConfig, deps, jsonOutput bool) error {
+	spec, err := LoadTarget(target)
+	if err != nil {
+		return err
+	}
+	c, err := newEnvCompiler(pkgName, spec, config)
+	if err != nil {
+		return err
+	}
+	mainPkg, packages, err := c.LoadPackages(pkgName)
+	if err != nil {
+		return err
+	}
+	if !deps {
+		packages = []*loader.Package{mainPkg}
+	}
+	for _, pkg := range packages {
+		if !jsonOutput {
+			fmt.Println(pkg.ImportPath)
+			continue
+		}
+		listed := &listedPackage{
+			ImportPath: pkg.ImportPath,
+			Name:       pkg.Name,
+			Dir:        pkg.Package.Dir,
+			GoFiles:    pkg.GoFiles,
+			CgoFiles:   pkg.CgoFiles,
+			Overlay:    pkg.Overlay,
+		}
+		for _, imported := range pkg.Imports {
+			listed.Imports = append(listed.Imports, imported.ImportPath)
+		}
+		sort.Strings(listed.Imports)
+		err := printJSON(os.Stdout, listed)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listedTarget is a built-in target as printed by `tinygo targets -json`.
+type listedTarget struct {
+	Name     string
+	Inherits []string    // the targets this target inherits from directly
+	Target   *TargetSpec // the target after merging all inherited targets
+}
+
+// ListTargets prints all built-in targets, with the targets they inherit from
+// (usually the chip of a board) and the memory of the chip if known. With
+// jsonOutput, the targets are printed as JSON including the complete target
+// specification.
+func ListTargets(jsonOutput bool) error {
+	paths, err := filepath.Glob(filepath.Join(sourceDir(), "targets", "*.json"))
+	if err != nil {
+		return err
+	}
+	var targets []*listedTarget
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		spec := &TargetSpec{}
+		err := spec.loadFromGivenStr(name, false)
+		if err != nil {
+			return err
+		}
+		target := &listedTarget{
+			Name:     name,
+			Inherits: spec.Inherits,
+			Target:   spec,
+		}
+		err = spec.resolveInherits([]string{name}, false)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, target)
+	}
+
+	if jsonOutput {
+		for _, target := range targets {
+			err := printJSON(os.Stdout, target)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	size := func(n uint64) string {
+		if n == 0 {
+			return "-"
+		} else if n%1024 != 0 {
+			return fmt.Sprint(n)
+		}
+		return fmt.Sprintf("%dK", n/1024)
+	}
+	fmt.Printf("%-20s %-27s %6s %6s  %s\n", "target", "llvm-target", "flash", "ram", "inherits")
+	for _, target := range targets {
+		// The inherited targets are listed with the most specific one first,
+		// for example: nrf51, cortex-m.
+		var inherits []string
+		for i := len(target.Target.Inherits) - 1; i >= 0; i-- {
+			inherits = append(inherits, target.Target.Inherits[i])
+		}
+		line := fmt.Sprintf("%-20s %-27s %6s %6s  %s", target.Name, target.Target.Triple, size(target.Target.FlashSize), size(target.Target.RAMSize), strings.Join(inherits, ", "))
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+	return nil
+}
+
+// CheckTarget loads the given target specification (usually a custom .json
+// file) and all the targets it inherits from, and prints the merged target
+// specification as JSON. Unlike when building, unknown keys are an error, to
+// catch misspelled keys. Inheritance cycles are always an error.
+func CheckTarget(target string) error {
+	spec := &TargetSpec{}
+	err := spec.loadFromGivenStr(target, true)
+	if err != nil {
+		return err
+	}
+	err = spec.resolveInherits([]string{target}, true)
+	if err != nil {
+		return err
+	}
+	return printJSON(os.Stdout, spec)
+}
+
+// printJSON writes v as indented JSON to w.
+func printJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
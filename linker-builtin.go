@@ -8,6 +8,7 @@ import (
 	"errors"
 	"os"
 	"os/exec"
+	"sync"
 	"unsafe"
 )
 
@@ -19,12 +20,19 @@ bool tinygo_link_wasm(int argc, char **argv);
 */
 import "C"
 
+// linkLock makes sure the built-in linker only runs once at a time, as lld
+// keeps global state and is not safe to use from multiple threads. This
+// matters when multiple test binaries are built at the same time.
+var linkLock sync.Mutex
+
 // Link invokes a linker with the given name and flags.
 //
 // This version uses the built-in linker when trying to use lld.
 func Link(linker string, flags ...string) error {
 	switch linker {
 	case "ld.lld":
+		linkLock.Lock()
+		defer linkLock.Unlock()
 		flags = append([]string{"tinygo:" + linker}, flags...)
 		var cflag *C.char
 		buf := C.calloc(C.size_t(len(flags)), C.size_t(unsafe.Sizeof(cflag)))
@@ -40,6 +48,8 @@ func Link(linker string, flags ...string) error {
 		}
 		return nil
 	case "wasm-ld":
+		linkLock.Lock()
+		defer linkLock.Unlock()
 		flags = append([]string{"tinygo:" + linker}, flags...)
 		var cflag *C.char
 		buf := C.calloc(C.size_t(len(flags)), C.size_t(unsafe.Sizeof(cflag)))
@@ -10,27 +10,35 @@ import (
 	"go/types"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"text/template"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/tinygo-org/tinygo/cgo"
 )
 
 // Program holds all packages and some metadata about the program as a whole.
 type Program struct {
-	mainPkg      string
-	Build        *build.Context
-	OverlayBuild *build.Context
-	OverlayPath  func(path string) string
-	Packages     map[string]*Package
-	sorted       []*Package
-	fset         *token.FileSet
-	TypeChecker  types.Config
-	Dir          string // current working directory (for error reporting)
-	TINYGOROOT   string // root of the TinyGo installation or root of the source code
-	CFlags       []string
-	ClangHeaders string
+	mainPkg       string
+	Build         *build.Context
+	OverlayBuild  *build.Context
+	OverlayPath   func(path string) string
+	Packages      map[string]*Package
+	sorted        []*Package
+	fset          *token.FileSet
+	TypeChecker   types.Config
+	Dir           string // current working directory (for error reporting)
+	TINYGOROOT    string // root of the TinyGo installation or root of the source code
+	CFlags        []string
+	ClangHeaders  string
+	TestVerbose   bool   // print all test results in the generated test main (-v)
+	TestRun       string // regular expression selecting the tests to run (-run)
+	TestBench     string // regular expression selecting the benchmarks to run (-bench)
+	TestBenchTime string // run time or number of iterations of each benchmark (-benchtime)
+	modules       *modules
 }
 
 // Package holds a loaded package, its imports, and its parsed files.
@@ -39,6 +47,7 @@ type Package struct {
 	*build.Package
 	Imports   map[string]*Package
 	Importing bool
+	Overlay   bool // loaded from the TinyGo root instead of GOROOT or GOPATH
 	Files     []*ast.File
 	Pkg       *types.Package
 	types.Info
@@ -53,13 +62,28 @@ func (p *Program) Import(path, srcDir string) (*Package, error) {
 
 	// Load this package.
 	ctx := p.Build
+	var buildPkg *build.Package
+	overlay := false
 	if newPath := p.OverlayPath(path); newPath != "" {
 		ctx = p.OverlayBuild
 		path = newPath
-	}
-	buildPkg, err := ctx.Import(path, srcDir, build.ImportComment)
-	if err != nil {
+		overlay = true
+	} else if modulePkg, err := p.findModulePackage(path, srcDir); err != nil {
 		return nil, err
+	} else if modulePkg != nil {
+		// In module mode, the go command knows where the package is.
+		buildPkg, err = ctx.ImportDir(modulePkg.Dir, build.ImportComment)
+		if err != nil {
+			return nil, err
+		}
+		buildPkg.ImportPath = modulePkg.ImportPath
+	}
+	if buildPkg == nil {
+		var err error
+		buildPkg, err = ctx.Import(path, srcDir, build.ImportComment)
+		if err != nil {
+			return nil, err
+		}
 	}
 	if existingPkg, ok := p.Packages[buildPkg.ImportPath]; ok {
 		// Already imported, or at least started the import.
@@ -67,6 +91,7 @@ func (p *Program) Import(path, srcDir string) (*Package, error) {
 	}
 	p.sorted = nil // invalidate the sorted order of packages
 	pkg := p.newPackage(buildPkg)
+	pkg.Overlay = overlay
 	p.Packages[buildPkg.ImportPath] = pkg
 
 	if p.mainPkg == "" {
@@ -180,14 +205,26 @@ func (p *Program) sort() {
 	p.sorted = packageList
 }
 
-// Parse recursively imports all packages, parses them, and typechecks them.
-//
-// The returned error may be an Errors error, which contains a list of errors.
-//
-// Idempotent.
-func (p *Program) Parse(compileTestBinary bool) error {
+// MainPkg returns the first package that was imported, which is the main
+// package of the program.
+func (p *Program) MainPkg() *Package {
+	return p.Packages[p.mainPkg]
+}
+
+// Load recursively imports all packages, without parsing them. Test files and
+// their imports are included when compiling a test binary.
+func (p *Program) Load(compileTestBinary bool) error {
 	includeTests := compileTestBinary
 
+	if compileTestBinary {
+		// The test main generated by SwapTestMain has its own imports.
+		mainPkg := p.Packages[p.mainPkg]
+		mainPkg.Package.TestImports = append(mainPkg.Package.TestImports, "testing")
+		if p.TestRun != "" || p.TestBench != "" {
+			mainPkg.Package.TestImports = append(mainPkg.Package.TestImports, "regexp")
+		}
+	}
+
 	// Load all imports
 	for _, pkg := range p.Sorted() {
 		err := pkg.importRecursively(includeTests)
@@ -201,6 +238,22 @@ func (p *Program) Parse(compileTestBinary bool) error {
 		}
 	}
 
+	return nil
+}
+
+// Parse recursively imports all packages, parses them, and typechecks them.
+//
+// The returned error may be an Errors error, which contains a list of errors.
+//
+// Idempotent.
+func (p *Program) Parse(compileTestBinary bool) error {
+	includeTests := compileTestBinary
+
+	err := p.Load(compileTestBinary)
+	if err != nil {
+		return err
+	}
+
 	// Parse all packages.
 	for _, pkg := range p.Sorted() {
 		err := pkg.Parse(includeTests)
@@ -227,23 +280,36 @@ func (p *Program) Parse(compileTestBinary bool) error {
 	return nil
 }
 
+// testExample is an example function found by SwapTestMain.
+type testExample struct {
+	Name      string
+	Output    string
+	Unordered bool
+}
+
+// SwapTestMain replaces the main function of the main package with a generated
+// main function that runs all tests, benchmarks and examples of the package.
 func (p *Program) SwapTestMain() error {
-	var tests []string
+	var tests, benchmarks []string
+	var examples []testExample
 
-	isTestFunc := func(f *ast.FuncDecl) bool {
-		// TODO: improve signature check
-		if strings.HasPrefix(f.Name.Name, "Test") && f.Name.Name != "TestMain" {
-			return true
-		}
-		return false
-	}
 	mainPkg := p.Packages[p.mainPkg]
 	for _, f := range mainPkg.Files {
 		for i, d := range f.Decls {
 			switch v := d.(type) {
 			case *ast.FuncDecl:
-				if isTestFunc(v) {
-					tests = append(tests, v.Name.Name)
+				// TODO: improve signature check
+				name := v.Name.Name
+				switch {
+				case isTestName(name, "Test") && name != "TestMain":
+					tests = append(tests, name)
+				case isTestName(name, "Benchmark"):
+					benchmarks = append(benchmarks, name)
+				case isTestName(name, "Example"):
+					// Examples without output comment are only compiled.
+					if output, unordered, ok := exampleOutput(f, v); ok {
+						examples = append(examples, testExample{name, output, unordered})
+					}
 				}
 				if v.Name.Name == "main" {
 					// Remove main
@@ -262,6 +328,9 @@ func (p *Program) SwapTestMain() error {
 	const mainBody = `package main
 
 import (
+{{if or .Run .Bench}}
+	"regexp"
+{{end}}
 	"testing"
 )
 
@@ -272,6 +341,27 @@ func main () {
 			{Name: "{{.}}", Func: {{.}}},
 {{end}}
 		},
+		Benchmarks: []testing.BenchmarkToCall{
+{{range .BenchmarkFunctions}}
+			{Name: "{{.}}", Func: {{.}}},
+{{end}}
+		},
+		Examples: []testing.ExampleToCall{
+{{range .Examples}}
+			{Name: "{{.Name}}", Func: {{.Name}}, Output: {{printf "%q" .Output}}, Unordered: {{.Unordered}}},
+{{end}}
+		},
+		Verbose: {{.Verbose}},
+{{if .Run}}
+		Filter: {{printf "%q" .Run}},
+{{end}}
+{{if .Bench}}
+		Bench: {{printf "%q" .Bench}},
+		BenchTime: {{printf "%q" .BenchTime}},
+{{end}}
+{{if or .Run .Bench}}
+		MatchString: regexp.MatchString,
+{{end}}
 	}
 
 	testing.TestMain(m)
@@ -280,9 +370,21 @@ func main () {
 	tmpl := template.Must(template.New("testmain").Parse(mainBody))
 	b := bytes.Buffer{}
 	tmplData := struct {
-		TestFunctions []string
+		TestFunctions      []string
+		BenchmarkFunctions []string
+		Examples           []testExample
+		Verbose            bool
+		Run                string
+		Bench              string
+		BenchTime          string
 	}{
-		TestFunctions: tests,
+		TestFunctions:      tests,
+		BenchmarkFunctions: benchmarks,
+		Examples:           examples,
+		Verbose:            p.TestVerbose,
+		Run:                p.TestRun,
+		Bench:              p.TestBench,
+		BenchTime:          p.TestBenchTime,
 	}
 
 	err := tmpl.Execute(&b, tmplData)
@@ -304,6 +406,58 @@ func main () {
 	return nil
 }
 
+// isTestName reports whether name looks like a test (or benchmark, or example)
+// function name with the given prefix: the prefix must not be followed by a
+// lower-case letter, so that a function like Testify is not a test.
+func isTestName(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) { // "Test" is ok
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return !unicode.IsLower(r)
+}
+
+// exampleOutputRx matches the start of the output comment of an example.
+var exampleOutputRx = regexp.MustCompile(`(?i)^[[:space:]]*(unordered )?output:`)
+
+// exampleOutput returns the expected output of an example function, which is
+// the text of the last comment in the function body after "Output:" or
+// "Unordered output:". The last result is false if there is no such comment.
+func exampleOutput(f *ast.File, fn *ast.FuncDecl) (output string, unordered, ok bool) {
+	if fn.Body == nil {
+		return "", false, false
+	}
+	var last *ast.CommentGroup
+	for _, cg := range f.Comments {
+		if cg.Pos() < fn.Body.Lbrace {
+			continue
+		}
+		if cg.End() > fn.Body.Rbrace {
+			break
+		}
+		last = cg
+	}
+	if last == nil {
+		return "", false, false
+	}
+	text := last.Text()
+	loc := exampleOutputRx.FindStringSubmatchIndex(text)
+	if loc == nil {
+		return "", false, false
+	}
+	unordered = loc[2] != -1
+	text = text[loc[1]:]
+	// Strip zero or more spaces followed by a newline or a single space.
+	text = strings.TrimLeft(text, " ")
+	if len(text) > 0 && text[0] == '\n' {
+		text = text[1:]
+	}
+	return text, unordered, true
+}
+
 // parseFile is a wrapper around parser.ParseFile.
 func (p *Program) parseFile(path string, mode parser.Mode) (*ast.File, error) {
 	if p.fset == nil {
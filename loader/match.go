@@ -0,0 +1,145 @@
+package loader
+
+// This file expands package patterns like ./... into a list of packages, in
+// the same way as the go tool does in GOPATH mode.
+
+import (
+	"go/build"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// MatchPackages expands the package patterns that contain "..." into the
+// packages they match. Local patterns (like ./... or ../foo/...) are matched
+// against the directories relative to dir and result in local paths. Other
+// patterns are matched against the import paths of the packages in GOPATH.
+// Patterns without "..." are returned unchanged. Directories starting with a
+// dot or underscore and testdata and vendor directories are skipped, like the
+// go tool does.
+//
+// The returned list contains every package only once, in the order of the
+// patterns. A pattern that doesn't match any package results in an error.
+func MatchPackages(ctx *build.Context, dir string, patterns []string) ([]string, error) {
+	var pkgs []string
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+		if strings.Contains(pattern, "...") {
+			if build.IsLocalImport(pattern) {
+				matches = matchLocalPackages(ctx, dir, pattern)
+			} else {
+				matches = matchGopathPackages(ctx, pattern)
+			}
+			if len(matches) == 0 {
+				return nil, &NoMatchError{Pattern: pattern}
+			}
+		}
+		for _, pkg := range matches {
+			if !seen[pkg] {
+				seen[pkg] = true
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	return pkgs, nil
+}
+
+// NoMatchError is returned by MatchPackages when a pattern matches no
+// packages.
+type NoMatchError struct {
+	Pattern string
+}
+
+func (e *NoMatchError) Error() string {
+	return "pattern " + e.Pattern + " matched no packages"
+}
+
+// matchPattern returns a function that reports whether a package name matches
+// the pattern, in which "..." matches any string. As a special case, a pattern
+// like foo/... also matches foo itself.
+func matchPattern(pattern string) func(name string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.Replace(re, `\.\.\.`, `.*`, -1)
+	if strings.HasSuffix(re, `/.*`) {
+		re = re[:len(re)-len(`/.*`)] + `(/.*)?`
+	}
+	reg := regexp.MustCompile(`^` + re + `$`)
+	return reg.MatchString
+}
+
+// matchLocalPackages returns the local paths of all packages in the directory
+// tree below dir that match the local pattern.
+func matchLocalPackages(ctx *build.Context, dir, pattern string) []string {
+	// Start walking at the directory before the first "...".
+	i := strings.Index(pattern, "...")
+	root, _ := path.Split(pattern[:i])
+	match := matchPattern(pattern)
+	var pkgs []string
+	walkRoot := filepath.Join(dir, root)
+	walkPackages(ctx, walkRoot, func(pkgDir string) {
+		rel, err := filepath.Rel(walkRoot, pkgDir)
+		if err != nil {
+			return
+		}
+		// path.Join removes the ./ prefix, but it is needed to match the
+		// pattern and to keep the result a local path.
+		name := path.Join(root, filepath.ToSlash(rel))
+		if strings.HasPrefix(pattern, "./") && name != "." {
+			name = "./" + name
+		}
+		if match(name) {
+			pkgs = append(pkgs, name)
+		}
+	})
+	return pkgs
+}
+
+// matchGopathPackages returns the import paths of all packages in GOPATH that
+// match the pattern.
+func matchGopathPackages(ctx *build.Context, pattern string) []string {
+	i := strings.Index(pattern, "...")
+	root, _ := path.Split(pattern[:i])
+	match := matchPattern(pattern)
+	var pkgs []string
+	for _, gopath := range filepath.SplitList(ctx.GOPATH) {
+		src := filepath.Join(gopath, "src")
+		walkPackages(ctx, filepath.Join(src, filepath.FromSlash(root)), func(pkgDir string) {
+			rel, err := filepath.Rel(src, pkgDir)
+			if err != nil {
+				return
+			}
+			if name := filepath.ToSlash(rel); match(name) {
+				pkgs = append(pkgs, name)
+			}
+		})
+	}
+	return pkgs
+}
+
+// walkPackages calls fn for every directory below root (including root itself)
+// that contains a Go package.
+func walkPackages(ctx *build.Context, root string, fn func(dir string)) {
+	filepath.Walk(root, func(dir string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if dir != root {
+			elem := filepath.Base(dir)
+			if strings.HasPrefix(elem, ".") || strings.HasPrefix(elem, "_") || elem == "testdata" || elem == "vendor" {
+				return filepath.SkipDir
+			}
+		}
+		if _, err := ctx.ImportDir(dir, 0); err != nil {
+			if _, noGo := err.(*build.NoGoError); noGo {
+				return nil
+			}
+			// Keep packages with errors, so that the errors are reported
+			// when they are compiled.
+		}
+		fn(dir)
+		return nil
+	})
+}
@@ -0,0 +1,148 @@
+package loader
+
+// This file finds packages in module mode. The go command is asked where the
+// packages are (with `go list -json -deps`), so that the module graph, the
+// module cache, replace directives and vendoring all work the same as with the
+// go command. Packages that are overlaid by TinyGo and packages in GOROOT are
+// still loaded with go/build.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go/build"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// listedPackage is the part of the output of `go list -json` that is needed to
+// load a package.
+type listedPackage struct {
+	ImportPath string
+	Dir        string
+	Standard   bool
+	Error      *struct {
+		Err string
+	}
+}
+
+// modules holds the state of the module loader.
+type modules struct {
+	mainModule string                    // path of the main module, "" when not in module mode
+	packages   map[string]*listedPackage // listed packages by import path
+	dirs       map[string]*listedPackage // listed packages by directory
+}
+
+// findModulePackage returns the package with the given import path, as found
+// by the go command in module mode. It returns nil when the program is not in
+// module mode or when the go command reports that the package is part of the
+// standard library, in which case it should be loaded from GOROOT.
+func (p *Program) findModulePackage(path, srcDir string) (*listedPackage, error) {
+	if p.modules == nil {
+		p.modules = &modules{
+			packages: make(map[string]*listedPackage),
+			dirs:     make(map[string]*listedPackage),
+		}
+		// GOMOD is empty (or /dev/null) when not in module mode. Without a
+		// working go command, only GOPATH mode is possible.
+		out, err := p.goCommand(p.Dir, "env", "GOMOD")
+		if gomod := strings.TrimSpace(string(out)); err == nil && gomod != "" && gomod != os.DevNull {
+			out, err := p.goCommand(p.Dir, "list", "-m", "-json")
+			if err != nil {
+				return nil, err
+			}
+			var module struct{ Path string }
+			if err := json.Unmarshal(out, &module); err != nil {
+				return nil, err
+			}
+			p.modules.mainModule = module.Path
+		}
+	}
+	m := p.modules
+	if m.mainModule == "" {
+		return nil, nil // GOPATH mode
+	}
+
+	if srcDir == "" {
+		srcDir = p.Dir
+	}
+	isLocal := build.IsLocalImport(path)
+	lookup := func() *listedPackage {
+		if isLocal {
+			return m.dirs[filepath.Join(srcDir, path)]
+		}
+		return m.packages[path]
+	}
+	pkg := lookup()
+	if pkg == nil {
+		// List the package with all its dependencies (including those of the
+		// tests), so that the go command only has to run once for most
+		// programs. This includes the packages of the standard library, which
+		// the go command marks as such.
+		args := []string{"list", "-e", "-json", "-deps", "-test", "-tags", strings.Join(p.Build.BuildTags, " "), path}
+		out, err := p.goCommand(srcDir, args...)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(out))
+		for {
+			listed := &listedPackage{}
+			err := dec.Decode(listed)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if strings.HasSuffix(listed.ImportPath, "]") || strings.HasSuffix(listed.ImportPath, ".test") {
+				// Test variant of a package, or the generated test main.
+				continue
+			}
+			if _, ok := m.packages[listed.ImportPath]; !ok {
+				m.packages[listed.ImportPath] = listed
+			}
+			if listed.Dir != "" {
+				m.dirs[listed.Dir] = listed
+			}
+		}
+		pkg = lookup()
+		if pkg == nil {
+			return nil, errors.New("go list did not find package " + path)
+		}
+	}
+
+	if pkg.Standard {
+		return nil, nil
+	}
+	if pkg.Dir == "" {
+		if pkg.Error != nil {
+			return nil, errors.New(pkg.Error.Err)
+		}
+		return nil, errors.New("cannot find package " + path)
+	}
+	return pkg, nil
+}
+
+// goCommand runs the go command of GOROOT in the given directory, for the
+// target GOOS and GOARCH, and returns its output.
+func (p *Program) goCommand(dir string, args ...string) ([]byte, error) {
+	gocmd := filepath.Join(p.Build.GOROOT, "bin", "go")
+	if _, err := os.Stat(gocmd); err != nil {
+		gocmd = "go"
+	}
+	cmd := exec.Command(gocmd, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOOS="+p.Build.GOOS, "GOARCH="+p.Build.GOARCH, "CGO_ENABLED=1")
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
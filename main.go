@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
+	"go/build"
 	"go/types"
 	"io"
 	"io/ioutil"
@@ -15,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/tinygo-org/tinygo/compiler"
 	"github.com/tinygo-org/tinygo/interp"
@@ -50,17 +53,25 @@ type BuildConfig struct {
 	printIR       bool
 	dumpSSA       bool
 	debug         bool
+	symtab        string // "on", "off" or "" for the default: on, except on bare metal targets
+	printStacks   bool
+	trimPath      bool
+	buildInfo     bool
 	printSizes    string
+	jsonOutput    bool // print the output of the command as JSON
 	cFlags        []string
 	ldFlags       []string
 	tags          string
 	wasmAbi       string
 	heapSize      int64
+	incremental   bool
 	testConfig    compiler.TestConfig
+	parallelism   int // number of test binaries to build and run at the same time
 }
 
-// Helper function for Compiler object.
-func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, action func(string) error) error {
+// newCompilerConfig merges the target specification and the command line
+// options into the configuration of the compiler.
+func newCompilerConfig(spec *TargetSpec, config *BuildConfig) (compiler.Config, error) {
 	if config.gc == "" && spec.GC != "" {
 		config.gc = spec.GC
 	}
@@ -72,6 +83,10 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 	for _, flag := range spec.CFlags {
 		cflags = append(cflags, strings.Replace(flag, "{root}", root, -1))
 	}
+	if config.printStacks && spec.Compiler == "clang" {
+		// Emit stack sizes for C files too, for the stack usage analysis.
+		cflags = append(cflags, "-fstack-size-section")
+	}
 
 	// Merge and adjust LDFlags.
 	ldflags := append([]string{}, config.ldFlags...)
@@ -81,15 +96,15 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 
 	goroot := getGoroot()
 	if goroot == "" {
-		return errors.New("cannot locate $GOROOT, please set it manually")
+		return compiler.Config{}, errors.New("cannot locate $GOROOT, please set it manually")
 	}
-	tags := spec.BuildTags
+	tags := append([]string{}, spec.BuildTags...)
 	major, minor, err := getGorootVersion(goroot)
 	if err != nil {
-		return fmt.Errorf("could not read version from GOROOT (%v): %v", goroot, err)
+		return compiler.Config{}, fmt.Errorf("could not read version from GOROOT (%v): %v", goroot, err)
 	}
 	if major != 1 {
-		return fmt.Errorf("expected major version 1, got go%d.%d", major, minor)
+		return compiler.Config{}, fmt.Errorf("expected major version 1, got go%d.%d", major, minor)
 	}
 	for i := 1; i <= minor; i++ {
 		tags = append(tags, fmt.Sprintf("go1.%d", i))
@@ -109,6 +124,9 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 		LDFlags:       ldflags,
 		ClangHeaders:  getClangHeaderPath(root),
 		Debug:         config.debug,
+		Symtab:        config.symtab == "on" || (config.symtab == "" && !spec.isBareMetal()),
+		StackSizes:    config.printStacks,
+		TrimPath:      config.trimPath,
 		DumpSSA:       config.dumpSSA,
 		TINYGOROOT:    root,
 		GOROOT:        goroot,
@@ -116,6 +134,38 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 		BuildTags:     tags,
 		TestConfig:    config.testConfig,
 	}
+	if config.incremental {
+		compilerConfig.PackageCache = packageCache{}
+	}
+	return compilerConfig, nil
+}
+
+// trimPathCFlags returns the flags for -trimpath that remove the temporary
+// directory from the debug information of a C file, and replace the source
+// directory srcDir with name. Like in compileBuiltins, this is necessary to make
+// the output reproducible. The working directory is not mapped, as clang would
+// apply that mapping instead of the one for srcDir when srcDir is inside it.
+// Instead, clang is told to store "." as the compilation directory.
+func trimPathCFlags(compiler, tmpDir, srcDir, name string) []string {
+	flags := []string{
+		"-fdebug-prefix-map=" + srcDir + "=" + name,
+		"-fdebug-prefix-map=" + tmpDir + "=.",
+	}
+	if compiler == "clang" {
+		flags = append(flags, "-Xclang", "-fdebug-compilation-dir", "-Xclang", ".")
+	}
+	return flags
+}
+
+// Helper function for Compiler object.
+func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, action func(string) error) error {
+	compilerConfig, err := newCompilerConfig(spec, config)
+	if err != nil {
+		return err
+	}
+	root := compilerConfig.TINYGOROOT
+	cflags := compilerConfig.CFlags
+	ldflags := compilerConfig.LDFlags
 	c, err := compiler.NewCompiler(pkgName, compilerConfig)
 	if err != nil {
 		return err
@@ -190,6 +240,15 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 		return errors.New("verification failure after LLVM optimization passes")
 	}
 
+	// The call graph for the stack usage analysis must be taken after
+	// optimization, when functions have been inlined.
+	var callGraph map[string][]string
+	var exportedFuncs, goroutineFuncs []string
+	if config.printStacks {
+		callGraph = c.CallGraph()
+		exportedFuncs, goroutineFuncs = c.StackRoots()
+	}
+
 	// On the AVR, pointers can point either to flash or to RAM, but we don't
 	// know. As a temporary fix, load all global variables in RAM.
 	// In the future, there should be a compiler pass that determines which
@@ -202,6 +261,20 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 		}
 	}
 
+	// Embed the build information, after all passes so that it stays as it
+	// is.
+	var buildInfoSymbol string
+	if config.buildInfo {
+		if spec.GOARCH == "wasm" || spec.GOOS == "darwin" {
+			return errors.New("-buildinfo is only supported for ELF files")
+		}
+		info, err := buildInfo(pkgName, spec, config)
+		if err != nil {
+			return err
+		}
+		buildInfoSymbol = c.AddNote(buildInfoSection, buildInfoNoteName, buildInfoNoteType, info)
+	}
+
 	// Generate output.
 	outext := filepath.Ext(outpath)
 	switch outext {
@@ -245,6 +318,11 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 		if spec.RTLib == "compiler-rt" {
 			ldflags = append(ldflags, librt)
 		}
+		if config.buildInfo {
+			// The build information isn't referenced by the program, so make
+			// sure it isn't removed with --gc-sections.
+			ldflags = append(ldflags, linkerFlag(spec.Linker, "--build-id=sha1"), linkerFlag(spec.Linker, "--undefined="+buildInfoSymbol))
+		}
 		if spec.GOARCH == "wasm" {
 			// Round heap size to next multiple of 65536 (the WebAssembly page
 			// size).
@@ -260,7 +338,11 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 			if names, ok := commands[spec.Compiler]; ok {
 				cmdNames = names
 			}
-			err := execCommand(cmdNames, append(cflags, "-c", "-o", outpath, abspath)...)
+			args := append(cflags, "-c", "-o", outpath, abspath)
+			if config.trimPath {
+				args = append(trimPathCFlags(spec.Compiler, dir, root, "tinygo"), args...)
+			}
+			err := execCommand(cmdNames, args...)
 			if err != nil {
 				return &commandError{"failed to build", path, err}
 			}
@@ -276,7 +358,16 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 				if names, ok := commands[spec.Compiler]; ok {
 					cmdNames = names
 				}
-				err := execCommand(cmdNames, append(cflags, "-c", "-o", outpath, path)...)
+				args := append(cflags, "-c", "-o", outpath, path)
+				if config.trimPath {
+					name := pkg.ImportPath
+					if strings.HasPrefix(name, "_/") {
+						// Directory outside GOPATH.
+						name = "."
+					}
+					args = append(trimPathCFlags(spec.Compiler, dir, pkg.Package.Dir, name), args...)
+				}
+				err := execCommand(cmdNames, args...)
 				if err != nil {
 					return &commandError{"failed to build", path, err}
 				}
@@ -290,12 +381,36 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 			return &commandError{"failed to link", executable, err}
 		}
 
-		if config.printSizes == "short" || config.printSizes == "full" {
+		// Check whether the program fits in the flash and RAM of the chip.
+		if spec.FlashSize != 0 || spec.RAMSize != 0 || spec.MinStackSize != 0 {
+			usage, err := ReadMemoryUsage(executable)
+			if err != nil {
+				return err
+			}
+			err = checkMemoryUsage(spec, usage, config.printSizes == "short" || config.printSizes == "full")
+			if err != nil {
+				return err
+			}
+		}
+
+		if config.printStacks {
+			err := printStacks(executable, callGraph, exportedFuncs, goroutineFuncs)
+			if err != nil {
+				return err
+			}
+		}
+
+		if config.printSizes == "short" || config.printSizes == "full" || config.printSizes == "json" {
 			sizes, err := Sizes(executable)
 			if err != nil {
 				return err
 			}
-			if config.printSizes == "short" {
+			if config.printSizes == "json" {
+				err := printSizesJSON(os.Stdout, sizes)
+				if err != nil {
+					return err
+				}
+			} else if config.printSizes == "short" {
 				fmt.Printf("   code    data     bss |   flash     ram\n")
 				fmt.Printf("%7d %7d %7d | %7d %7d\n", sizes.Code, sizes.Data, sizes.BSS, sizes.Code+sizes.Data, sizes.Data+sizes.BSS)
 			} else {
@@ -362,7 +477,12 @@ func Build(pkgName, outpath, target string, config *BuildConfig) error {
 	})
 }
 
-func Test(pkgName, target string, config *BuildConfig) error {
+// Test builds and runs the tests of the packages matching the given patterns
+// (like ./...). Up to config.parallelism test binaries are built and run at the
+// same time. The output of every package is printed after its tests have
+// finished, followed by a summary line like the one printed by `go test`. With
+// a single package, the output is printed while the tests run.
+func Test(patterns []string, target string, config *BuildConfig) error {
 	spec, err := LoadTarget(target)
 	if err != nil {
 		return err
@@ -370,23 +490,146 @@ func Test(pkgName, target string, config *BuildConfig) error {
 
 	spec.BuildTags = append(spec.BuildTags, "test")
 	config.testConfig.CompileTestBinary = true
-	return Compile(pkgName, ".elf", spec, config, func(tmppath string) error {
-		cmd := exec.Command(tmppath)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		err := cmd.Run()
-		if err != nil {
-			// Propagate the exit code
-			if err, ok := err.(*exec.ExitError); ok {
-				if status, ok := err.Sys().(syscall.WaitStatus); ok {
-					os.Exit(status.ExitStatus())
+	if config.jsonOutput {
+		// The JSON events are created from the verbose output.
+		config.testConfig.Verbose = true
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	ctx := build.Default
+	ctx.GOPATH = getGopath()
+	ctx.BuildTags = append(append([]string{}, spec.BuildTags...), strings.Fields(config.tags)...)
+	pkgNames, err := loader.MatchPackages(&ctx, wd, patterns)
+	if err != nil {
+		return err
+	}
+
+	parallelism := config.parallelism
+	if parallelism < 1 || config.incremental {
+		// Incremental builds share a single LLVM context, which can't be used
+		// from multiple threads.
+		parallelism = 1
+	}
+
+	// Start all test jobs. The semaphore limits the number of jobs that run
+	// at the same time.
+	results := make([]*testResult, len(pkgNames))
+	semaphore := make(chan struct{}, parallelism)
+	for i, pkgName := range pkgNames {
+		result := &testResult{
+			pkgName: pkgName,
+			done:    make(chan struct{}),
+		}
+		results[i] = result
+		var stdout, stderr io.Writer = &result.output, &result.output
+		if len(pkgNames) == 1 {
+			stdout, stderr = os.Stdout, os.Stderr
+		}
+		config := *config // Compile modifies the config
+		go func() {
+			semaphore <- struct{}{}
+			result.passed = testPackage(result.pkgName, &ctx, wd, spec, &config, stdout, stderr)
+			<-semaphore
+			close(result.done)
+		}()
+	}
+
+	// Print the results in the order of the packages.
+	passed := true
+	for _, result := range results {
+		<-result.done
+		os.Stdout.Write(result.output.Bytes())
+		if !result.passed {
+			passed = false
+		}
+	}
+	if !passed {
+		if len(results) > 1 && !config.jsonOutput {
+			fmt.Println("FAIL")
+		}
+		os.Exit(1)
+	}
+	return nil
+}
+
+// testResult is the result of testing a single package.
+type testResult struct {
+	pkgName string
+	output  bytes.Buffer  // output, when testing multiple packages
+	passed  bool          // all tests passed
+	done    chan struct{} // closed when the test binary has finished
+}
+
+// testPackage builds and runs the tests of a single package, and writes the
+// output and a summary line like `ok  \tpkg\t0.123s` to stdout and stderr.
+// Build errors are also written to stderr. It reports whether the package
+// passed its tests.
+func testPackage(pkgName string, ctx *build.Context, wd string, spec *TargetSpec, config *BuildConfig, stdout, stderr io.Writer) bool {
+	importPath := pkgName
+	if pkg, err := ctx.Import(pkgName, wd, 0); err == nil || pkg.ImportPath != "" {
+		importPath = pkg.ImportPath
+		if err == nil && len(pkg.TestGoFiles) == 0 && len(pkg.XTestGoFiles) == 0 {
+			summary := fmt.Sprintf("?   \t%s\t[no test files]\n", importPath)
+			if config.jsonOutput {
+				converter := newTestJSONConverter(stdout, importPath)
+				converter.Write([]byte(summary))
+				converter.Close()
+			} else {
+				fmt.Fprint(stdout, summary)
+			}
+			return true
+		}
+	}
+
+	var converter *testJSONConverter
+	if config.jsonOutput {
+		converter = newTestJSONConverter(stdout, importPath)
+		defer converter.Close()
+		stdout = converter
+		stderr = converter
+	}
+
+	var runErr error
+	var elapsed time.Duration
+	err := Compile(pkgName, ".elf", spec, config, func(tmppath string) error {
+		// Run the test binary directly or in an emulator. The runtime of
+		// emulated targets reports the exit code to the emulator (through
+		// semihosting on Cortex-M and the exit function of wasm_exec.js on
+		// WebAssembly), so a failing test also fails here.
+		cmd := runCommand(spec, tmppath)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		start := time.Now()
+		runErr = cmd.Run()
+		elapsed = time.Since(start)
+		if runErr != nil {
+			if _, ok := runErr.(*exec.ExitError); !ok {
+				if len(spec.Emulator) != 0 {
+					return &commandError{"failed to run emulator with", tmppath, runErr}
 				}
-				os.Exit(1)
+				return &commandError{"failed to run compiled binary", tmppath, runErr}
 			}
-			return &commandError{"failed to run compiled binary", tmppath, err}
 		}
 		return nil
 	})
+	if err != nil {
+		printCompilerError(stderr, err)
+		fmt.Fprintf(stdout, "FAIL\t%s [build failed]\n", importPath)
+		return false
+	}
+	if converter != nil {
+		converter.Exited(runErr)
+	}
+	if runErr != nil {
+		fmt.Fprintln(stdout, runErr) // exit status 1
+		fmt.Fprintf(stdout, "FAIL\t%s\t%.3fs\n", importPath, elapsed.Seconds())
+		return false
+	}
+	fmt.Fprintf(stdout, "ok  \t%s\t%.3fs\n", importPath, elapsed.Seconds())
+	return true
 }
 
 func Flash(pkgName, target, port string, config *BuildConfig) error {
@@ -514,39 +757,47 @@ func Run(pkgName, target string, config *BuildConfig) error {
 	}
 
 	return Compile(pkgName, ".elf", spec, config, func(tmppath string) error {
-		if len(spec.Emulator) == 0 {
-			// Run directly.
-			cmd := exec.Command(tmppath)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			err := cmd.Run()
-			if err != nil {
-				if err, ok := err.(*exec.ExitError); ok && err.Exited() {
-					// Workaround for QEMU which always exits with an error.
-					return nil
-				}
-				return &commandError{"failed to run compiled binary", tmppath, err}
+		cmd := runCommand(spec, tmppath)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if err != nil {
+			if err, ok := err.(*exec.ExitError); ok && err.Exited() {
+				// The program ran but exited with an error. This is not an
+				// error of TinyGo itself.
+				return nil
 			}
-			return nil
-		} else {
-			// Run in an emulator.
-			args := append(spec.Emulator[1:], tmppath)
-			cmd := exec.Command(spec.Emulator[0], args...)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			err := cmd.Run()
-			if err != nil {
-				if err, ok := err.(*exec.ExitError); ok && err.Exited() {
-					// Workaround for QEMU which always exits with an error.
-					return nil
-				}
+			if len(spec.Emulator) != 0 {
 				return &commandError{"failed to run emulator with", tmppath, err}
 			}
-			return nil
+			return &commandError{"failed to run compiled binary", tmppath, err}
 		}
+		return nil
 	})
 }
 
+// runCommand returns the command to run the compiled program at path: the
+// program itself, or the emulator of the target when the program can't run on
+// the host. Relative paths in the emulator command (like
+// targets/wasm_exec.js) are relative to the TinyGo root, so that the command
+// works from any directory.
+func runCommand(spec *TargetSpec, path string) *exec.Cmd {
+	if len(spec.Emulator) == 0 {
+		return exec.Command(path)
+	}
+	args := make([]string, 0, len(spec.Emulator))
+	for _, arg := range spec.Emulator[1:] {
+		if !filepath.IsAbs(arg) && strings.Contains(arg, "/") {
+			if _, err := os.Stat(filepath.Join(sourceDir(), arg)); err == nil {
+				arg = filepath.Join(sourceDir(), arg)
+			}
+		}
+		args = append(args, arg)
+	}
+	args = append(args, path)
+	return exec.Command(spec.Emulator[0], args...)
+}
+
 // parseSize converts a human-readable size (with k/m/g suffix) into a plain
 // number.
 func parseSize(s string) (int64, error) {
@@ -581,7 +832,13 @@ func usage() {
 	fmt.Fprintln(os.Stderr, "  test:  test packages")
 	fmt.Fprintln(os.Stderr, "  flash: compile and flash to the device")
 	fmt.Fprintln(os.Stderr, "  gdb:   run/flash and immediately enter GDB")
-	fmt.Fprintln(os.Stderr, "  clean: empty cache directory ("+cacheDir()+")")
+	fmt.Fprintln(os.Stderr, "  size-diff: compare the code size of two ELF files (old.elf new.elf)")
+	fmt.Fprintln(os.Stderr, "  env:   print the configuration used for the target")
+	fmt.Fprintln(os.Stderr, "  list:  list the packages loaded for the target")
+	fmt.Fprintln(os.Stderr, "  targets: list the built-in targets")
+	fmt.Fprintln(os.Stderr, "  target-check: check a target specification (file.json) and print it merged with its inherited targets")
+	fmt.Fprintln(os.Stderr, "  clean: empty cache directory ("+cacheDir()+"), or only remove old files with -older-than")
+	fmt.Fprintln(os.Stderr, "  version: print the TinyGo version, or with -m the build information of ELF files")
 	fmt.Fprintln(os.Stderr, "  help:  print this help text")
 	fmt.Fprintln(os.Stderr, "\nflags:")
 	flag.PrintDefaults()
@@ -589,30 +846,35 @@ func usage() {
 
 func handleCompilerError(err error) {
 	if err != nil {
-		switch err := err.(type) {
-		case *interp.Unsupported:
-			// hit an unknown/unsupported instruction
-			fmt.Fprintln(os.Stderr, "unsupported instruction during init evaluation:")
-			err.Inst.Dump()
-			fmt.Fprintln(os.Stderr)
-		case types.Error:
-			fmt.Fprintln(os.Stderr, err)
-		case loader.Errors:
-			fmt.Fprintln(os.Stderr, "#", err.Pkg.ImportPath)
-			for _, err := range err.Errs {
-				fmt.Fprintln(os.Stderr, err)
-			}
-		case *multiError:
-			for _, err := range err.Errs {
-				fmt.Fprintln(os.Stderr, err)
-			}
-		default:
-			fmt.Fprintln(os.Stderr, "error:", err)
-		}
+		printCompilerError(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
+// printCompilerError prints the error (or errors) returned by Compile to w.
+func printCompilerError(w io.Writer, err error) {
+	switch err := err.(type) {
+	case *interp.Unsupported:
+		// hit an unknown/unsupported instruction
+		fmt.Fprintln(w, "unsupported instruction during init evaluation:")
+		err.Inst.Dump()
+		fmt.Fprintln(w)
+	case types.Error:
+		fmt.Fprintln(w, err)
+	case loader.Errors:
+		fmt.Fprintln(w, "#", err.Pkg.ImportPath)
+		for _, err := range err.Errs {
+			fmt.Fprintln(w, err)
+		}
+	case *multiError:
+		for _, err := range err.Errs {
+			fmt.Fprintln(w, err)
+		}
+	default:
+		fmt.Fprintln(w, "error:", err)
+	}
+}
+
 func main() {
 	outpath := flag.String("o", "", "output filename")
 	opt := flag.String("opt", "z", "optimization level: 0, 1, 2, s, z")
@@ -622,14 +884,29 @@ func main() {
 	dumpSSA := flag.Bool("dumpssa", false, "dump internal Go SSA")
 	tags := flag.String("tags", "", "a space-separated list of extra build tags")
 	target := flag.String("target", "", "LLVM target | .json file with TargetSpec")
-	printSize := flag.String("size", "", "print sizes (none, short, full)")
+	printSize := flag.String("size", "", "print sizes (none, short, full, json)")
+	jsonOutput := flag.Bool("json", false, "print the output as JSON (test results as events, like go test -json)")
 	nodebug := flag.Bool("no-debug", false, "disable DWARF debug symbol generation")
+	printStacks := flag.Bool("print-stacks", false, "print the worst case stack usage of main, goroutines and interrupt handlers")
+	embedBuildInfo := flag.Bool("buildinfo", false, "embed a build ID and the TinyGo version, target, VCS revision and build flags, see version -m")
+	versionBuildInfo := flag.Bool("m", false, "with version: print the build information of the given ELF files")
+	trimPath := flag.Bool("trimpath", false, "remove all file system paths from the compiled program, for reproducible builds")
+	symtab := flag.Bool("symtab", false, "enable the symbol table on bare metal targets, where it is off by default as it keeps the frame pointer in every function")
+	nosymtab := flag.Bool("no-symtab", false, "disable the symbol table used by runtime.Caller and panic tracebacks")
 	ocdOutput := flag.Bool("ocd-output", false, "print OCD daemon output during debug")
 	port := flag.String("port", "/dev/ttyACM0", "flash port")
 	cFlags := flag.String("cflags", "", "additional cflags for compiler")
 	ldFlags := flag.String("ldflags", "", "additional ldflags for linker")
 	wasmAbi := flag.String("wasm-abi", "js", "WebAssembly ABI conventions: js (no i64 params) or generic")
 	heapSize := flag.String("heap-size", "1M", "default heap size in bytes (only supported by WebAssembly)")
+	testRun := flag.String("run", "", "with test: only run tests matching this regular expression")
+	testVerbose := flag.Bool("v", false, "with test: print the name and result of all tests and all log messages")
+	testBench := flag.String("bench", "", "with test: run benchmarks matching this regular expression")
+	testBenchTime := flag.String("benchtime", "", "with test: run each benchmark for this duration, or this many times with an x suffix (e.g. 1s or 100x)")
+	parallelism := flag.Int("p", runtime.NumCPU(), "with test: the number of test binaries to build and run in parallel")
+	incremental := flag.Bool("incremental", false, "cache the compiled code of each package and only recompile changed packages")
+	listDeps := flag.Bool("deps", false, "with list: also list all dependencies of the package")
+	olderThan := flag.Duration("older-than", 0, "with clean: only remove cached files that have not been used for this long (e.g. 72h)")
 
 	if len(os.Args) < 2 {
 		fmt.Fprintln(os.Stderr, "No command-line arguments supplied.")
@@ -646,9 +923,27 @@ func main() {
 		printIR:       *printIR,
 		dumpSSA:       *dumpSSA,
 		debug:         !*nodebug,
+		printStacks:   *printStacks,
+		trimPath:      *trimPath,
+		buildInfo:     *embedBuildInfo,
 		printSizes:    *printSize,
+		jsonOutput:    *jsonOutput,
 		tags:          *tags,
 		wasmAbi:       *wasmAbi,
+		incremental:   *incremental,
+		testConfig: compiler.TestConfig{
+			Verbose:   *testVerbose,
+			Run:       *testRun,
+			Bench:     *testBench,
+			BenchTime: *testBenchTime,
+		},
+		parallelism: *parallelism,
+	}
+
+	if *nosymtab {
+		config.symtab = "off"
+	} else if *symtab {
+		config.symtab = "on"
 	}
 
 	if *cFlags != "" {
@@ -736,20 +1031,61 @@ func main() {
 		err := Run(flag.Arg(0), *target, config)
 		handleCompilerError(err)
 	case "test":
+		patterns := flag.Args()
+		if len(patterns) == 0 {
+			patterns = []string{"."}
+		}
+		err := Test(patterns, *target, config)
+		handleCompilerError(err)
+	case "size-diff":
+		if flag.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "size-diff requires two ELF files: old and new.")
+			usage()
+			os.Exit(1)
+		}
+		diff, err := SizeDiff(flag.Arg(0), flag.Arg(1))
+		if err == nil {
+			if config.jsonOutput {
+				err = diff.PrintJSON(os.Stdout)
+			} else {
+				diff.Print(os.Stdout)
+			}
+		}
+		handleCompilerError(err)
+	case "env":
+		err := Env(*target, config, config.jsonOutput)
+		handleCompilerError(err)
+	case "list":
 		pkgName := "."
 		if flag.NArg() == 1 {
 			pkgName = flag.Arg(0)
 		} else if flag.NArg() > 1 {
-			fmt.Fprintln(os.Stderr, "test only accepts a single positional argument: package name, but multiple were specified")
+			fmt.Fprintln(os.Stderr, "list only accepts a single positional argument: package name, but multiple were specified")
 			usage()
 			os.Exit(1)
 		}
-		err := Test(pkgName, *target, config)
+		err := List(pkgName, *target, config, *listDeps, config.jsonOutput)
+		handleCompilerError(err)
+	case "targets":
+		err := ListTargets(config.jsonOutput)
+		handleCompilerError(err)
+	case "target-check":
+		if flag.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "target-check requires a target specification file.")
+			usage()
+			os.Exit(1)
+		}
+		err := CheckTarget(flag.Arg(0))
 		handleCompilerError(err)
 	case "clean":
-		// remove cache directory
-		dir := cacheDir()
-		err := os.RemoveAll(dir)
+		var err error
+		if *olderThan != 0 {
+			// remove cached files that haven't been used recently
+			err = cacheClean(*olderThan)
+		} else {
+			// remove cache directory
+			err = os.RemoveAll(cacheDir())
+		}
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "cannot clean cache:", err)
 			os.Exit(1)
@@ -757,6 +1093,18 @@ func main() {
 	case "help":
 		usage()
 	case "version":
+		if *versionBuildInfo {
+			if flag.NArg() == 0 {
+				fmt.Fprintln(os.Stderr, "No files specified.")
+				usage()
+				os.Exit(1)
+			}
+			for _, path := range flag.Args() {
+				err := printBuildInfo(path)
+				handleCompilerError(err)
+			}
+			return
+		}
 		fmt.Printf("tinygo version %s %s/%s\n", version, runtime.GOOS, runtime.GOARCH)
 	default:
 		fmt.Fprintln(os.Stderr, "Unknown command:", command)
@@ -4,16 +4,21 @@ package main
 // comparing their output with the expected output in testdata/*.txt.
 
 import (
+	"archive/zip"
 	"bufio"
 	"bytes"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/tinygo-org/tinygo/compiler"
 	"github.com/tinygo-org/tinygo/loader"
 )
 
@@ -48,7 +53,7 @@ func TestCompiler(t *testing.T) {
 	t.Log("running tests on host...")
 	for _, path := range matches {
 		t.Run(path, func(t *testing.T) {
-			runTest(path, tmpdir, "", t)
+			runTest(path, tmpdir, false, "", t)
 		})
 	}
 
@@ -56,10 +61,32 @@ func TestCompiler(t *testing.T) {
 		return
 	}
 
+	// The runtime and other packages are compiled in the first test and
+	// loaded from the cache in the following tests.
+	t.Log("running tests on host with incremental compilation...")
+	for _, path := range matches {
+		t.Run(path, func(t *testing.T) {
+			runTest(path, tmpdir, true, "", t)
+		})
+	}
+
 	t.Log("running tests for emulated cortex-m3...")
 	for _, path := range matches {
+		if path == filepath.Join("testdata", "heapgrow.go") {
+			continue // the heap cannot grow on baremetal targets (64kB RAM)
+		}
+		t.Run(path, func(t *testing.T) {
+			runTest(path, tmpdir, false, "qemu", t)
+		})
+	}
+
+	t.Log("running tests for emulated cortex-m3 with incremental compilation...")
+	for _, path := range matches {
+		if path == filepath.Join("testdata", "heapgrow.go") {
+			continue // the heap cannot grow on baremetal targets (64kB RAM)
+		}
 		t.Run(path, func(t *testing.T) {
-			runTest(path, tmpdir, "qemu", t)
+			runTest(path, tmpdir, true, "qemu", t)
 		})
 	}
 
@@ -70,7 +97,7 @@ func TestCompiler(t *testing.T) {
 				continue // TODO: improve CGo
 			}
 			t.Run(path, func(t *testing.T) {
-				runTest(path, tmpdir, "arm--linux-gnueabihf", t)
+				runTest(path, tmpdir, false, "arm--linux-gnueabihf", t)
 			})
 		}
 
@@ -80,7 +107,7 @@ func TestCompiler(t *testing.T) {
 				continue // TODO: improve CGo
 			}
 			t.Run(path, func(t *testing.T) {
-				runTest(path, tmpdir, "aarch64--linux-gnu", t)
+				runTest(path, tmpdir, false, "aarch64--linux-gnu", t)
 			})
 		}
 
@@ -90,13 +117,366 @@ func TestCompiler(t *testing.T) {
 				continue // known to fail
 			}
 			t.Run(path, func(t *testing.T) {
-				runTest(path, tmpdir, "wasm", t)
+				runTest(path, tmpdir, false, "wasm", t)
 			})
 		}
 	}
 }
 
-func runTest(path, tmpdir string, target string, t *testing.T) {
+func TestReproducible(t *testing.T) {
+	// Build a package with Go and C files and debug information twice, which
+	// should result in exactly the same binary with -trimpath.
+	tmpdir, err := ioutil.TempDir("", "tinygo-test")
+	if err != nil {
+		t.Fatal("could not create temporary directory:", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	var binaries [][]byte
+	for i := 0; i < 2; i++ {
+		config := &BuildConfig{
+			opt:      "z",
+			debug:    true,
+			symtab:   "on",
+			trimPath: true,
+			wasmAbi:  "js",
+		}
+		binary := filepath.Join(tmpdir, "test"+strconv.Itoa(i))
+		err := Build("./"+filepath.Join(TESTDATA, "cgo")+"/", binary, "", config)
+		if err != nil {
+			t.Fatal("failed to build:", err)
+		}
+		data, err := ioutil.ReadFile(binary)
+		if err != nil {
+			t.Fatal("could not read binary:", err)
+		}
+		binaries = append(binaries, data)
+	}
+	if !bytes.Equal(binaries[0], binaries[1]) {
+		t.Error("two builds with -trimpath resulted in different binaries")
+	}
+	if bytes.Contains(binaries[0], []byte(tmpdir)) || bytes.Contains(binaries[0], []byte(os.TempDir()+string(filepath.Separator)+"tinygo")) {
+		t.Error("binary built with -trimpath contains a temporary directory")
+	}
+
+	// The binary must also not depend on where the source code is stored.
+	testdata, err := filepath.Abs(TESTDATA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := []string{testdata, sourceDir()}
+	dirs = append(dirs, filepath.SplitList(getGopath())...)
+	for _, dir := range dirs {
+		if dir != "" && bytes.Contains(binaries[0], []byte(dir)) {
+			t.Errorf("binary built with -trimpath contains the path %s", dir)
+		}
+	}
+}
+
+func TestBuildInfo(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("-buildinfo is only supported for ELF files")
+	}
+	tmpdir, err := ioutil.TempDir("", "tinygo-test")
+	if err != nil {
+		t.Fatal("could not create temporary directory:", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	config := &BuildConfig{
+		opt:       "z",
+		wasmAbi:   "js",
+		buildInfo: true,
+	}
+	binary := filepath.Join(tmpdir, "test")
+	err = Build("./"+filepath.Join(TESTDATA, "calls.go"), binary, "", config)
+	if err != nil {
+		t.Fatal("failed to build:", err)
+	}
+	info, err := ReadBuildInfo(binary)
+	if err != nil {
+		t.Fatal("could not read build information:", err)
+	}
+	if len(info.BuildID) != 40 {
+		t.Errorf("unexpected build ID: %q", info.BuildID)
+	}
+	settings := map[string]string{}
+	for _, setting := range info.Settings {
+		settings[setting[0]] = setting[1]
+	}
+	if settings["tinygo"] != version || settings["target"] == "" {
+		t.Errorf("unexpected build information: %v", info.Settings)
+	}
+}
+
+// TestSymtab checks the call positions reported by runtime.Caller and the
+// functions found by runtime.FuncForPC, which are only available with a symbol
+// table and debug information.
+func TestSymtab(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "tinygo-test")
+	if err != nil {
+		t.Fatal("could not create temporary directory:", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(TESTDATA, "symtab", "caller.go")
+	expected, err := ioutil.ReadFile(path[:len(path)-3] + ".txt")
+	if err != nil {
+		t.Fatal("could not read expected output file:", err)
+	}
+
+	// The symbol table must be the same with incremental compilation, both when
+	// packages are compiled and when they are loaded from the cache.
+	for _, incremental := range []bool{false, true, true} {
+		config := &BuildConfig{
+			opt:         "z",
+			debug:       true,
+			symtab:      "on",
+			wasmAbi:     "js",
+			incremental: incremental,
+		}
+		binary := filepath.Join(tmpdir, "test")
+		err = Build("./"+path, binary, "", config)
+		if err != nil {
+			t.Fatal("failed to build:", err)
+		}
+		actual, err := exec.Command(binary).Output()
+		if err != nil {
+			t.Fatal("failed to run:", err)
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Errorf("output did not match (incremental: %v), expected:\n%s\nactual:\n%s", incremental, expected, actual)
+		}
+	}
+}
+
+// TestModules builds a program in module mode, with a package in the main
+// module, a required module in the module cache and a replaced module.
+func TestModules(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "tinygo-test")
+	if err != nil {
+		t.Fatal("could not create temporary directory:", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// The required module is served by a module proxy in a local directory,
+	// so that the go command downloads it without network access into the
+	// module cache of a new GOPATH. The go command makes the files in the
+	// module cache read-only, so they are made writable again before the
+	// temporary directory is removed.
+	dir := filepath.Join(TESTDATA, "modules")
+	proxy := filepath.Join(tmpdir, "proxy")
+	err = writeModuleProxy(proxy, "example.com/dep", "v1.0.0", filepath.Join(dir, "dep"))
+	if err != nil {
+		t.Fatal("could not create module proxy:", err)
+	}
+	for key, value := range map[string]string{
+		"GOPROXY":     "file://" + filepath.ToSlash(proxy),
+		"GOPATH":      filepath.Join(tmpdir, "gopath"),
+		"GOSUMDB":     "off",
+		"GO111MODULE": "on",
+	} {
+		defer setEnv(key, value)()
+	}
+	defer makeWritable(filepath.Join(tmpdir, "gopath"))
+
+	// Build from the module directory, like the go command.
+	expected, err := ioutil.ReadFile(filepath.Join(dir, "out.txt"))
+	if err != nil {
+		t.Fatal("could not read expected output file:", err)
+	}
+	binary, err := filepath.Abs(filepath.Join(tmpdir, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Build(".", binary, "", &BuildConfig{opt: "z", wasmAbi: "js"})
+	os.Chdir(wd)
+	if err != nil {
+		t.Fatal("failed to build:", err)
+	}
+	actual, err := exec.Command(binary).Output()
+	if err != nil {
+		t.Fatal("failed to run:", err)
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Errorf("output did not match, expected:\n%s\nactual:\n%s", expected, actual)
+	}
+}
+
+// writeModuleProxy creates a module proxy in the given directory (for use with
+// GOPROXY=file://...) that serves a single version of a module, with the files
+// in the given source directory.
+func writeModuleProxy(proxy, module, version, source string) error {
+	dir := filepath.Join(proxy, filepath.FromSlash(module), "@v")
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+	modfile, err := ioutil.ReadFile(filepath.Join(source, "go.mod"))
+	if err != nil {
+		return err
+	}
+	files := map[string]string{
+		"list":            version + "\n",
+		version + ".info": `{"Version":"` + version + `","Time":"2019-01-01T00:00:00Z"}`,
+		version + ".mod":  string(modfile),
+	}
+	for name, data := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The zip file contains all files of the module, in a directory named
+	// after the module and version.
+	f, err := os.Create(filepath.Join(dir, version+".zip"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	infos, err := ioutil.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		data, err := ioutil.ReadFile(filepath.Join(source, info.Name()))
+		if err != nil {
+			return err
+		}
+		zf, err := w.Create(module + "@" + version + "/" + info.Name())
+		if err != nil {
+			return err
+		}
+		_, err = zf.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// makeWritable makes all directories below dir writable, so that the files in
+// them can be removed.
+func makeWritable(dir string) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			os.Chmod(path, 0777)
+		}
+		return nil
+	})
+}
+
+// setEnv sets an environment variable and returns a function that restores
+// its previous value.
+func setEnv(key, value string) func() {
+	old, hadOld := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if hadOld {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// TestTest runs the tests of a package through `tinygo test`, which replaces
+// the main function of the package with one that runs the tests selected with
+// -run. The output must be the same as the output of `go test`.
+func TestTest(t *testing.T) {
+	config := &BuildConfig{
+		opt:     "z",
+		debug:   true,
+		wasmAbi: "js",
+		testConfig: compiler.TestConfig{
+			Verbose: true,
+			Run:     "TestAdd/one",
+		},
+	}
+	path := filepath.Join(TESTDATA, "testpkg")
+	expected, err := ioutil.ReadFile(filepath.Join(path, "out.txt"))
+	if err != nil {
+		t.Fatal("could not read expected output file:", err)
+	}
+	actual := captureTest(t, "./"+path, config)
+
+	// The last line is the summary with the import path of the package.
+	summary := strings.LastIndex(strings.TrimSuffix(actual, "\n"), "\n") + 1
+	if !strings.HasPrefix(actual[summary:], "ok  \t") {
+		t.Errorf("expected a summary line starting with ok, got:\n%s", actual)
+	}
+	if actual[:summary] != string(expected) {
+		t.Errorf("output did not match, expected:\n%s\nactual:\n%s", expected, actual)
+	}
+}
+
+// TestTestBench runs the examples and benchmarks of a package through `tinygo
+// test`. The expected output of examples is read from their Output comment,
+// and -benchtime with an x suffix sets the number of benchmark iterations.
+func TestTestBench(t *testing.T) {
+	config := &BuildConfig{
+		opt:     "z",
+		debug:   true,
+		wasmAbi: "js",
+		testConfig: compiler.TestConfig{
+			Verbose:   true,
+			Run:       "Example",
+			Bench:     "Add",
+			BenchTime: "10x",
+		},
+	}
+	actual := captureTest(t, "./"+filepath.Join(TESTDATA, "testpkg"), config)
+	expected := regexp.MustCompile(`^=== RUN   Example_add
+--- PASS: Example_add \(0\.00s\)
+BenchmarkAdd\t *10\t *[0-9.]+ ns/op
+PASS
+ok  \t.*\n$`)
+	if !expected.MatchString(actual) {
+		t.Errorf("output did not match, expected:\n%s\nactual:\n%s", expected, actual)
+	}
+}
+
+// durationRegexp matches the durations in the output of a test binary.
+var durationRegexp = regexp.MustCompile(`[0-9]+\.[0-9]+s`)
+
+// captureTest runs the tests of a package with Test and returns the output,
+// with all durations replaced by 0.00s. Test exits the process when a test
+// fails, so it can only be used for tests that pass.
+func captureTest(t *testing.T, pkgName string, config *BuildConfig) string {
+	f, err := ioutil.TempFile("", "tinygo-test")
+	if err != nil {
+		t.Fatal("could not create temporary file:", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// The test binary writes directly to stdout when a single package is
+	// tested.
+	stdout := os.Stdout
+	os.Stdout = f
+	err = Test([]string{pkgName}, "", config)
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal("failed to test:", err)
+	}
+	output, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal("could not read test output:", err)
+	}
+	return durationRegexp.ReplaceAllString(string(output), "0.00s")
+}
+
+func runTest(path, tmpdir string, incremental bool, target string, t *testing.T) {
 	// Get the expected output for this test.
 	txtpath := path[:len(path)-3] + ".txt"
 	if path[len(path)-1] == os.PathSeparator {
@@ -113,12 +493,13 @@ func runTest(path, tmpdir string, target string, t *testing.T) {
 
 	// Build the test binary.
 	config := &BuildConfig{
-		opt:        "z",
-		printIR:    false,
-		dumpSSA:    false,
-		debug:      false,
-		printSizes: "",
-		wasmAbi:    "js",
+		opt:         "z",
+		printIR:     false,
+		dumpSSA:     false,
+		debug:       false,
+		printSizes:  "",
+		wasmAbi:     "js",
+		incremental: incremental,
 	}
 	binary := filepath.Join(tmpdir, "test")
 	err = Build("./"+path, binary, target, config)
@@ -134,7 +515,8 @@ func runTest(path, tmpdir string, target string, t *testing.T) {
 		return
 	}
 
-	// Run the test.
+	// Run the test. Emulated targets report the exit code of the program to
+	// the emulator, so a program that exits with an error fails the test.
 	var cmd *exec.Cmd
 	if target == "" {
 		cmd = exec.Command(binary)
@@ -146,8 +528,7 @@ func runTest(path, tmpdir string, target string, t *testing.T) {
 		if len(spec.Emulator) == 0 {
 			t.Fatal("no emulator available for target:", target)
 		}
-		args := append(spec.Emulator[1:], binary)
-		cmd = exec.Command(spec.Emulator[0], args...)
+		cmd = runCommand(spec, binary)
 	}
 	stdout := &bytes.Buffer{}
 	cmd.Stdout = stdout
@@ -155,9 +536,6 @@ func runTest(path, tmpdir string, target string, t *testing.T) {
 		cmd.Stderr = os.Stderr
 	}
 	err = cmd.Run()
-	if _, ok := err.(*exec.ExitError); ok && target != "" {
-		err = nil // workaround for QEMU
-	}
 
 	// putchar() prints CRLF, convert it to LF.
 	actual := bytes.Replace(stdout.Bytes(), []byte{'\r', '\n'}, []byte{'\n'}, -1)
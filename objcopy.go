@@ -29,6 +29,20 @@ func (s ProgSlice) Len() int           { return len(s) }
 func (s ProgSlice) Less(i, j int) bool { return s[i].Paddr < s[j].Paddr }
 func (s ProgSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 
+// romSegments returns the segments of the ELF file that are stored in the
+// firmware image, sorted by load address.
+func romSegments(f *elf.File) ProgSlice {
+	progs := make(ProgSlice, 0, 2)
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Filesz == 0 {
+			continue
+		}
+		progs = append(progs, prog)
+	}
+	sort.Sort(progs)
+	return progs
+}
+
 // ExtractROM extracts a firmware image and the first load address from the
 // given ELF file. It tries to emulate the behavior of objcopy.
 func ExtractROM(path string) (uint64, []byte, error) {
@@ -56,17 +70,10 @@ func ExtractROM(path string) (uint64, []byte, error) {
 		}
 	}
 
-	progs := make(ProgSlice, 0, 2)
-	for _, prog := range f.Progs {
-		if prog.Type != elf.PT_LOAD || prog.Filesz == 0 {
-			continue
-		}
-		progs = append(progs, prog)
-	}
+	progs := romSegments(f)
 	if len(progs) == 0 {
 		return 0, nil, ObjcopyError{"file does not contain ROM segments: " + path, nil}
 	}
-	sort.Sort(progs)
 
 	var rom []byte
 	for _, prog := range progs {
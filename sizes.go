@@ -0,0 +1,261 @@
+package main
+
+// This file prints the size reports of -size=json and `tinygo size-diff`, for
+// tools (like CI scripts) that track the code size of a program, and checks
+// the size of a program against the memory of the target.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// sizeReport is the JSON representation of the sizes of a program, as printed
+// with -size=json.
+type sizeReport struct {
+	Code     uint64              `json:"code"`
+	Data     uint64              `json:"data"`
+	BSS      uint64              `json:"bss"`
+	Flash    uint64              `json:"flash"`
+	RAM      uint64              `json:"ram"`
+	Packages []packageSizeReport `json:"packages"`
+	Symbols  []symbolSizeReport  `json:"symbols"`
+}
+
+type packageSizeReport struct {
+	Name   string `json:"name"`
+	Code   uint64 `json:"code"`
+	ROData uint64 `json:"rodata"`
+	Data   uint64 `json:"data"`
+	BSS    uint64 `json:"bss"`
+	Flash  uint64 `json:"flash"`
+	RAM    uint64 `json:"ram"`
+}
+
+type symbolSizeReport struct {
+	Name    string `json:"name"`
+	Package string `json:"package"`
+	Section string `json:"section"`
+	Kind    string `json:"kind"`
+	Address uint64 `json:"address"`
+	Size    uint64 `json:"size"`
+}
+
+// printSizesJSON writes the sizes of the program, per package and per symbol,
+// as JSON to w.
+func printSizesJSON(w io.Writer, sizes *ProgramSize) error {
+	report := sizeReport{
+		Code:     sizes.Code,
+		Data:     sizes.Data,
+		BSS:      sizes.BSS,
+		Flash:    sizes.Code + sizes.Data,
+		RAM:      sizes.Data + sizes.BSS,
+		Packages: []packageSizeReport{},
+		Symbols:  []symbolSizeReport{},
+	}
+	for _, name := range sizes.SortedPackageNames() {
+		pkgSize := sizes.Packages[name]
+		report.Packages = append(report.Packages, packageSizeReport{
+			Name:   name,
+			Code:   pkgSize.Code,
+			ROData: pkgSize.ROData,
+			Data:   pkgSize.Data,
+			BSS:    pkgSize.BSS,
+			Flash:  pkgSize.Flash(),
+			RAM:    pkgSize.RAM(),
+		})
+	}
+	for _, symbol := range sizes.Symbols {
+		report.Symbols = append(report.Symbols, symbolSizeReport(*symbol))
+	}
+	data, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// sizeChange is the difference in flash and RAM usage of a symbol, a package
+// or a whole program between two builds.
+type sizeChange struct {
+	Name     string `json:"name"`
+	OldFlash uint64 `json:"old_flash"`
+	NewFlash uint64 `json:"new_flash"`
+	OldRAM   uint64 `json:"old_ram"`
+	NewRAM   uint64 `json:"new_ram"`
+}
+
+// FlashDiff returns the growth in flash usage, which is negative if it shrunk.
+func (c *sizeChange) FlashDiff() int64 {
+	return int64(c.NewFlash) - int64(c.OldFlash)
+}
+
+// RAMDiff returns the growth in RAM usage, which is negative if it shrunk.
+func (c *sizeChange) RAMDiff() int64 {
+	return int64(c.NewRAM) - int64(c.OldRAM)
+}
+
+// sizeDiff is the difference in size between two builds of a program, as
+// printed by `tinygo size-diff`. Only packages and symbols that changed in size
+// are included, largest growth first.
+type sizeDiff struct {
+	Total    *sizeChange   `json:"total"`
+	Packages []*sizeChange `json:"packages"`
+	Symbols  []*sizeChange `json:"symbols"`
+}
+
+// SizeDiff compares the sizes of two ELF files, usually two builds of the same
+// program, per package and per symbol. Symbols with the same name (like static
+// functions in C files) are combined.
+func SizeDiff(oldPath, newPath string) (*sizeDiff, error) {
+	oldSizes, err := Sizes(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newSizes, err := Sizes(newPath)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &sizeDiff{
+		Total: &sizeChange{
+			Name:     "(total)",
+			OldFlash: oldSizes.Code + oldSizes.Data,
+			NewFlash: newSizes.Code + newSizes.Data,
+			OldRAM:   oldSizes.Data + oldSizes.BSS,
+			NewRAM:   newSizes.Data + newSizes.BSS,
+		},
+		Packages: []*sizeChange{},
+		Symbols:  []*sizeChange{},
+	}
+
+	packages := map[string]*sizeChange{}
+	for name, pkgSize := range oldSizes.Packages {
+		packages[name] = &sizeChange{Name: name, OldFlash: pkgSize.Flash(), OldRAM: pkgSize.RAM()}
+	}
+	for name, pkgSize := range newSizes.Packages {
+		change := packages[name]
+		if change == nil {
+			change = &sizeChange{Name: name}
+			packages[name] = change
+		}
+		change.NewFlash = pkgSize.Flash()
+		change.NewRAM = pkgSize.RAM()
+	}
+
+	symbols := map[string]*sizeChange{}
+	for _, symbol := range oldSizes.Symbols {
+		change := symbols[symbol.Name]
+		if change == nil {
+			change = &sizeChange{Name: symbol.Name}
+			symbols[symbol.Name] = change
+		}
+		change.OldFlash += symbol.Flash()
+		change.OldRAM += symbol.RAM()
+	}
+	for _, symbol := range newSizes.Symbols {
+		change := symbols[symbol.Name]
+		if change == nil {
+			change = &sizeChange{Name: symbol.Name}
+			symbols[symbol.Name] = change
+		}
+		change.NewFlash += symbol.Flash()
+		change.NewRAM += symbol.RAM()
+	}
+
+	diff.Packages = sortedSizeChanges(packages)
+	diff.Symbols = sortedSizeChanges(symbols)
+	return diff, nil
+}
+
+// sortedSizeChanges returns the changes that are not zero, with the largest
+// growth in flash (and then RAM) first.
+func sortedSizeChanges(changes map[string]*sizeChange) []*sizeChange {
+	list := []*sizeChange{}
+	for _, change := range changes {
+		if change.FlashDiff() != 0 || change.RAMDiff() != 0 {
+			list = append(list, change)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].FlashDiff() != list[j].FlashDiff() {
+			return list[i].FlashDiff() > list[j].FlashDiff()
+		}
+		if list[i].RAMDiff() != list[j].RAMDiff() {
+			return list[i].RAMDiff() > list[j].RAMDiff()
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Print writes the size differences as a table to w.
+func (d *sizeDiff) Print(w io.Writer) {
+	printChanges := func(title string, changes []*sizeChange) {
+		fmt.Fprintf(w, "  flash     old     new |     ram     old     new | %s\n", title)
+		for _, change := range changes {
+			fmt.Fprintf(w, "%+7d %7d %7d | %+7d %7d %7d | %s\n", change.FlashDiff(), change.OldFlash, change.NewFlash, change.RAMDiff(), change.OldRAM, change.NewRAM, change.Name)
+		}
+	}
+	printChanges("symbol", d.Symbols)
+	fmt.Fprintln(w)
+	printChanges("package", append(d.Packages, d.Total))
+}
+
+// PrintJSON writes the size differences as JSON to w.
+func (d *sizeDiff) PrintJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(d, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// checkMemoryUsage compares the memory usage of the program with the limits of
+// the target. It returns an error with a usage table when the program doesn't
+// fit. When verbose is set, the table is also printed when it does fit.
+func checkMemoryUsage(spec *TargetSpec, usage *MemoryUsage, verbose bool) error {
+	type region struct {
+		name  string
+		used  uint64
+		limit uint64
+		ok    bool
+	}
+	regions := []region{
+		{"flash", usage.Flash, spec.FlashSize, spec.FlashSize == 0 || usage.Flash <= spec.FlashSize},
+		{"ram", usage.RAM, spec.RAMSize, spec.RAMSize == 0 || usage.RAM <= spec.RAMSize},
+		{"stack", usage.Stack, spec.MinStackSize, spec.MinStackSize == 0 || usage.Stack == 0 || usage.Stack >= spec.MinStackSize},
+	}
+
+	var problems []string
+	table := &strings.Builder{}
+	fmt.Fprintf(table, "region     used    size  usage\n")
+	for _, r := range regions {
+		if r.limit == 0 {
+			continue
+		}
+		if r.name == "stack" {
+			// The stack has a minimum size instead of a maximum.
+			fmt.Fprintf(table, "%-6s %8d %7d  (minimum)\n", r.name, r.used, r.limit)
+			if !r.ok {
+				problems = append(problems, fmt.Sprintf("stack of %d bytes is smaller than the minimum of %d bytes", r.used, r.limit))
+			}
+			continue
+		}
+		fmt.Fprintf(table, "%-6s %8d %7d %5.1f%%\n", r.name, r.used, r.limit, float64(r.used)*100/float64(r.limit))
+		if !r.ok {
+			problems = append(problems, fmt.Sprintf("%s overflowed by %d bytes", r.name, r.used-r.limit))
+		}
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("program does not fit in the memory of the target: %s\n%s", strings.Join(problems, ", "), strings.TrimSuffix(table.String(), "\n"))
+	}
+	if verbose {
+		fmt.Print(table.String())
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// The ELF files in testdata/sizes are built from the assembly files next to
+// them, so that the size of every symbol is known.
+var (
+	oldSizesELF = filepath.Join(TESTDATA, "sizes", "old.elf")
+	newSizesELF = filepath.Join(TESTDATA, "sizes", "new.elf")
+)
+
+func TestSizes(t *testing.T) {
+	sizes, err := Sizes(newSizesELF)
+	if err != nil {
+		t.Fatal("could not read sizes:", err)
+	}
+	if sizes.Code != 92 || sizes.Data != 32 || sizes.BSS != 128 {
+		t.Errorf("unexpected section sizes: code %d, data %d, bss %d", sizes.Code, sizes.Data, sizes.BSS)
+	}
+	expectedPackages := map[string]PackageSize{
+		"(bootstrap)": {Code: 8},
+		"main":        {Code: 60, ROData: 8, Data: 32},
+		"runtime":     {Code: 24, BSS: 128},
+	}
+	if len(sizes.Packages) != len(expectedPackages) {
+		t.Errorf("expected packages %v, got %v", expectedPackages, sizes.SortedPackageNames())
+	}
+	for name, expected := range expectedPackages {
+		if pkgSize := sizes.Packages[name]; pkgSize == nil || *pkgSize != expected {
+			t.Errorf("package %s: expected size %+v, got %+v", name, expected, pkgSize)
+		}
+	}
+
+	// Symbols are sorted by address.
+	var symbols []string
+	for _, symbol := range sizes.Symbols {
+		symbols = append(symbols, symbol.Name+" "+symbol.Kind)
+	}
+	expectedSymbols := "_start code, main.grow code, main.same code, runtime.alloc code, main.added code, main.message rodata, main.table data, runtime.heap bss"
+	if strings.Join(symbols, ", ") != expectedSymbols {
+		t.Errorf("unexpected symbols:\nexpected: %s\nactual:   %s", expectedSymbols, strings.Join(symbols, ", "))
+	}
+}
+
+func TestSizeDiff(t *testing.T) {
+	diff, err := SizeDiff(oldSizesELF, newSizesELF)
+	if err != nil {
+		t.Fatal("could not compare sizes:", err)
+	}
+
+	// Changes are sorted by growth in flash and then RAM, and unchanged
+	// symbols and packages are left out.
+	expectedSymbols := []sizeChange{
+		{Name: "main.grow", OldFlash: 16, NewFlash: 48},
+		{Name: "main.table", OldFlash: 16, NewFlash: 32, OldRAM: 16, NewRAM: 32},
+		{Name: "main.added", NewFlash: 4},
+		{Name: "runtime.heap", OldRAM: 64, NewRAM: 128},
+		{Name: "runtime.alloc", OldFlash: 32, NewFlash: 24},
+		{Name: "runtime.removed", OldFlash: 8},
+	}
+	expectedPackages := []sizeChange{
+		{Name: "main", OldFlash: 48, NewFlash: 100, OldRAM: 16, NewRAM: 32},
+		{Name: "runtime", OldFlash: 40, NewFlash: 24, OldRAM: 64, NewRAM: 128},
+	}
+	checkSizeChanges(t, "symbols", expectedSymbols, diff.Symbols)
+	checkSizeChanges(t, "packages", expectedPackages, diff.Packages)
+	expectedTotal := sizeChange{Name: "(total)", OldFlash: 88, NewFlash: 124, OldRAM: 80, NewRAM: 160}
+	if *diff.Total != expectedTotal {
+		t.Errorf("expected total %+v, got %+v", expectedTotal, *diff.Total)
+	}
+
+	buf := &bytes.Buffer{}
+	diff.Print(buf)
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) != 13 || lines[1] != "    +32      16      48 |      +0       0       0 | main.grow" || lines[11] != "    +36      88     124 |     +80      80     160 | (total)" {
+		t.Errorf("unexpected table:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	err = diff.PrintJSON(buf)
+	if err != nil {
+		t.Fatal("could not print JSON:", err)
+	}
+	var report map[string]json.RawMessage
+	err = json.Unmarshal(buf.Bytes(), &report)
+	if err != nil {
+		t.Fatal("could not parse JSON:", err)
+	}
+	checkJSONKeys(t, "size diff", report, "packages", "symbols", "total")
+	var symbols []map[string]json.RawMessage
+	err = json.Unmarshal(report["symbols"], &symbols)
+	if err != nil {
+		t.Fatal("could not parse symbols:", err)
+	}
+	if len(symbols) != len(expectedSymbols) {
+		t.Fatalf("expected %d symbols in JSON, got %d", len(expectedSymbols), len(symbols))
+	}
+	checkJSONKeys(t, "symbol change", symbols[0], "name", "new_flash", "new_ram", "old_flash", "old_ram")
+	if string(symbols[0]["name"]) != `"main.grow"` || string(symbols[0]["new_flash"]) != "48" {
+		t.Errorf("unexpected first symbol in JSON: %s", buf.String())
+	}
+}
+
+func TestPrintSizesJSON(t *testing.T) {
+	sizes, err := Sizes(newSizesELF)
+	if err != nil {
+		t.Fatal("could not read sizes:", err)
+	}
+	buf := &bytes.Buffer{}
+	err = printSizesJSON(buf, sizes)
+	if err != nil {
+		t.Fatal("could not print JSON:", err)
+	}
+	var report map[string]json.RawMessage
+	err = json.Unmarshal(buf.Bytes(), &report)
+	if err != nil {
+		t.Fatal("could not parse JSON:", err)
+	}
+	checkJSONKeys(t, "size report", report, "bss", "code", "data", "flash", "packages", "ram", "symbols")
+	if string(report["flash"]) != "124" || string(report["ram"]) != "160" {
+		t.Errorf("unexpected totals in JSON: flash %s, ram %s", report["flash"], report["ram"])
+	}
+
+	var packages, symbols []map[string]json.RawMessage
+	err = json.Unmarshal(report["packages"], &packages)
+	if err != nil {
+		t.Fatal("could not parse packages:", err)
+	}
+	err = json.Unmarshal(report["symbols"], &symbols)
+	if err != nil {
+		t.Fatal("could not parse symbols:", err)
+	}
+	if len(packages) != 3 || len(symbols) != 8 {
+		t.Fatalf("expected 3 packages and 8 symbols in JSON, got %d and %d", len(packages), len(symbols))
+	}
+	checkJSONKeys(t, "package", packages[1], "bss", "code", "data", "flash", "name", "ram", "rodata")
+	if string(packages[1]["name"]) != `"main"` || string(packages[1]["flash"]) != "100" {
+		t.Errorf("unexpected package in JSON: %s", buf.String())
+	}
+	checkJSONKeys(t, "symbol", symbols[7], "address", "kind", "name", "package", "section", "size")
+	if string(symbols[7]["name"]) != `"runtime.heap"` || string(symbols[7]["section"]) != `".bss"` || string(symbols[7]["kind"]) != `"bss"` {
+		t.Errorf("unexpected symbol in JSON: %s", buf.String())
+	}
+}
+
+func TestCheckMemoryUsage(t *testing.T) {
+	spec := &TargetSpec{FlashSize: 4096, RAMSize: 1024, MinStackSize: 256}
+	tests := []struct {
+		name  string
+		spec  *TargetSpec
+		usage MemoryUsage
+		err   string
+	}{
+		{"fits", spec, MemoryUsage{Flash: 1000, RAM: 500, Stack: 256}, ""},
+		{"full", spec, MemoryUsage{Flash: 4096, RAM: 1024, Stack: 512}, ""},
+		{"flash overflow", spec, MemoryUsage{Flash: 4097, RAM: 500, Stack: 256}, "flash overflowed by 1 bytes"},
+		{"ram overflow", spec, MemoryUsage{Flash: 1000, RAM: 2000, Stack: 256}, "ram overflowed by 976 bytes"},
+		{"both overflow", spec, MemoryUsage{Flash: 5000, RAM: 2000, Stack: 256}, "flash overflowed by 904 bytes, ram overflowed by 976 bytes"},
+		{"small stack", spec, MemoryUsage{Flash: 1000, RAM: 500, Stack: 128}, "stack of 128 bytes is smaller than the minimum of 256 bytes"},
+		{"unknown stack", spec, MemoryUsage{Flash: 1000, RAM: 500}, ""},
+		{"zero limits", &TargetSpec{}, MemoryUsage{Flash: 1 << 30, RAM: 1 << 30, Stack: 1}, ""},
+		{"zero flash limit", &TargetSpec{RAMSize: 1024}, MemoryUsage{Flash: 1 << 30, RAM: 2048}, "ram overflowed by 1024 bytes"},
+	}
+	for _, tc := range tests {
+		err := checkMemoryUsage(tc.spec, &tc.usage, false)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+			continue
+		}
+		// The first line lists the problems, followed by a usage table.
+		lines := strings.Split(err.Error(), "\n")
+		if lines[0] != "program does not fit in the memory of the target: "+tc.err {
+			t.Errorf("%s: unexpected error: %s", tc.name, lines[0])
+		}
+		if lines[1] != "region     used    size  usage" {
+			t.Errorf("%s: expected a usage table, got:\n%s", tc.name, err)
+		}
+	}
+
+	// The table only lists the regions with a limit.
+	err := checkMemoryUsage(&TargetSpec{RAMSize: 1024}, &MemoryUsage{Flash: 100, RAM: 2048}, false)
+	expected := "region     used    size  usage\nram        2048    1024 200.0%"
+	if err == nil || !strings.HasSuffix(err.Error(), "\n"+expected) {
+		t.Errorf("expected usage table:\n%s\ngot:\n%v", expected, err)
+	}
+}
+
+// checkSizeChanges compares a list of size changes with the expected changes,
+// including their order.
+func checkSizeChanges(t *testing.T, what string, expected []sizeChange, actual []*sizeChange) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Errorf("expected %d %s, got %d", len(expected), what, len(actual))
+		return
+	}
+	for i := range expected {
+		if *actual[i] != expected[i] {
+			t.Errorf("%s %d: expected %+v, got %+v", what, i, expected[i], *actual[i])
+		}
+	}
+}
+
+// checkJSONKeys checks that a JSON object has exactly the given keys, which
+// must be sorted.
+func checkJSONKeys(t *testing.T, what string, object map[string]json.RawMessage, keys ...string) {
+	t.Helper()
+	var actual []string
+	for key := range object {
+		actual = append(actual, key)
+	}
+	sort.Strings(actual)
+	if strings.Join(actual, " ") != strings.Join(keys, " ") {
+		t.Errorf("%s: expected JSON keys %v, got %v", what, keys, actual)
+	}
+}
@@ -52,7 +52,8 @@ func Asm(asm string)
 func AsmFull(asm string, regs map[string]interface{})
 
 // ReadRegister returns the contents of the specified register. The register
-// must be a processor register, reachable with the "mov" instruction.
+// must be a processor register, reachable with the "mov" instruction, or one of
+// the special registers primask, basepri, faultmask, control, msp or psp.
 func ReadRegister(name string) uintptr
 
 // Run the following system call (SVCall) with 0 arguments.
@@ -118,19 +119,17 @@ func SetPriority(irq uint32, priority uint32) {
 }
 
 // DisableInterrupts disables all interrupts, and returns the old state.
-//
-// TODO: it doesn't actually return the old state, meaning that it cannot be
-// nested.
 func DisableInterrupts() uintptr {
-	Asm("cpsid if")
-	return 0
+	mask := ReadRegister("primask")
+	Asm("cpsid i")
+	return mask
 }
 
 // EnableInterrupts enables all interrupts again. The value passed in must be
-// the mask returned by DisableInterrupts.
-//
-// TODO: it doesn't actually use the old state, meaning that it cannot be
-// nested.
+// the mask returned by DisableInterrupts. Interrupts stay disabled if they were
+// already disabled before that call, so that these calls can be nested.
 func EnableInterrupts(mask uintptr) {
-	Asm("cpsie if")
+	if mask&1 == 0 {
+		Asm("cpsie i")
+	}
 }
@@ -6,5 +6,7 @@ package riscv
 func Asm(asm string)
 
 // ReadRegister returns the contents of the specified register. The register
-// must be a processor register, reachable with the "mov" instruction.
+// must be a processor register, reachable with the "mv" instruction, or one of
+// the machine-mode control and status registers mstatus, mie, mip, mtvec,
+// mscratch, mepc, mcause or mtval.
 func ReadRegister(name string) uintptr
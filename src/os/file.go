@@ -29,6 +29,20 @@ type File struct {
 	name string
 }
 
+// Write writes len(b) bytes to the File. It returns the number of bytes written
+// and an error, if any. Write returns a non-nil error when n != len(b).
+func (f *File) Write(b []byte) (n int, err error) {
+	if f.fd == Stdout.fd && runtime_captureStdout(b) {
+		// The output is captured by the testing package.
+		return len(b), nil
+	}
+	return f.write(b)
+}
+
+// runtime_captureStdout stores the data and returns true if output to stdout is
+// being captured. It is implemented in the runtime.
+func runtime_captureStdout(b []byte) bool
+
 // Readdir is a stub, not yet implemented
 func (f *File) Readdir(n int) ([]FileInfo, error) {
 	return nil, notImplemented
@@ -11,9 +11,9 @@ func (f *File) Read(b []byte) (n int, err error) {
 	return 0, errUnsupported
 }
 
-// Write writes len(b) bytes to the output. It returns the number of bytes
-// written or an error if this file is not stdout or stderr.
-func (f *File) Write(b []byte) (n int, err error) {
+// write writes len(b) bytes to the output. It returns an error if this file is
+// not stdout or stderr.
+func (f *File) write(b []byte) (n int, err error) {
 	switch f.fd {
 	case Stdout.fd, Stderr.fd:
 		for _, c := range b {
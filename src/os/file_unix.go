@@ -12,9 +12,8 @@ func (f *File) Read(b []byte) (n int, err error) {
 	return syscall.Read(int(f.fd), b)
 }
 
-// Write writes len(b) bytes to the File. It returns the number of bytes written
-// and an error, if any. Write returns a non-nil error when n != len(b).
-func (f *File) Write(b []byte) (n int, err error) {
+// write writes len(b) bytes to the file descriptor.
+func (f *File) write(b []byte) (n int, err error) {
 	return syscall.Write(int(f.fd), b)
 }
 
@@ -1,6 +1,7 @@
 package reflect
 
 import (
+	"strconv"
 	"unsafe"
 )
 
@@ -23,6 +24,33 @@ import (
 //         The higher bits are either the contents of the type depending on the
 //         type (if n is clear) or indicate the number of the named type (if n
 //         is set).
+//
+// The contents of an unnamed complex type depend on the kind:
+//   Chan, Ptr, Slice: the type code of the element type.
+//   Interface, Func:  a unique number.
+//   Array:            an index into arrayTypesSidetable, which contains the
+//                     element type and the length.
+//   Map:              an index into mapTypesSidetable, which contains the key
+//                     type and the element type.
+//   Struct:           an offset into structTypesSidetable, which contains the
+//                     size and number of fields followed by the fields. Each
+//                     field consists of its type, offset, name << 1 (with the
+//                     lowest bit set for embedded fields), tag and package path.
+//                     Strings are offsets into structNamesSidetable, where each
+//                     string is stored as a uvarint length followed by the
+//                     bytes.
+// The number of a named complex type is an index into
+// namedNonBasicTypesSidetable, which contains the type code of the underlying
+// type.
+//
+// The side tables are filled in by the compiler, see compiler/reflect.go.
+var (
+	namedNonBasicTypesSidetable uintptr
+	arrayTypesSidetable         uintptr
+	mapTypesSidetable           uintptr
+	structTypesSidetable        uintptr
+	structNamesSidetable        byte
+)
 
 type Kind uintptr
 
@@ -142,32 +170,138 @@ func (t Type) Kind() Kind {
 	}
 }
 
+// isNamed returns whether this is a named type.
+func (t Type) isNamed() bool {
+	if t%2 == 0 {
+		// basic type
+		return t>>6 != 0
+	}
+	return (t>>4)%2 != 0
+}
+
+// underlying returns the underlying type of a named type, and the type itself
+// for unnamed types.
+func (t Type) underlying() Type {
+	if !t.isNamed() {
+		return t
+	}
+	if t%2 == 0 {
+		// basic type
+		return t.Kind().basicType()
+	}
+	return Type(readSidetable(unsafe.Pointer(&namedNonBasicTypesSidetable), uintptr(t>>5)))
+}
+
+// ptrTo returns the type code of a pointer to this type.
+func (t Type) ptrTo() Type {
+	if t>>(unsafe.Sizeof(t)*8-5) != 0 {
+		panic("reflect: cannot create pointer type: type code too big")
+	}
+	return t<<5 | Type(Ptr-19)<<1 | 1
+}
+
+// sliceOf returns the type code of a slice of this type.
+func (t Type) sliceOf() Type {
+	if t>>(unsafe.Sizeof(t)*8-5) != 0 {
+		panic("reflect: cannot create slice type: type code too big")
+	}
+	return t<<5 | Type(Slice-19)<<1 | 1
+}
+
 func (t Type) Elem() Type {
 	switch t.Kind() {
 	case Chan, Ptr, Slice:
-		if (t>>4)%2 != 0 {
-			panic("unimplemented: (reflect.Type).Elem() for named types")
-		}
-		return t >> 5
-	default: // not implemented: Array, Map
-		panic("unimplemented: (reflect.Type).Elem()")
+		return t.underlying() >> 5
+	case Array:
+		index := uintptr(t.underlying() >> 5)
+		return Type(readSidetable(unsafe.Pointer(&arrayTypesSidetable), index*2))
+	case Map:
+		index := uintptr(t.underlying() >> 5)
+		return Type(readSidetable(unsafe.Pointer(&mapTypesSidetable), index*2+1))
+	default:
+		panic("reflect: Elem of invalid type")
+	}
+}
+
+// Key returns the key type of a map type.
+func (t Type) Key() Type {
+	if t.Kind() != Map {
+		panic("reflect: Key of non-map type")
 	}
+	index := uintptr(t.underlying() >> 5)
+	return Type(readSidetable(unsafe.Pointer(&mapTypesSidetable), index*2))
 }
 
+// Field returns the i'th field of a struct type.
 func (t Type) Field(i int) StructField {
-	panic("unimplemented: (reflect.Type).Field()")
+	field := t.rawField(i)
+	return StructField{
+		Name:      readStringSidetable(field.name >> 1),
+		PkgPath:   readStringSidetable(field.pkgPath),
+		Type:      field.typecode,
+		Tag:       StructTag(readStringSidetable(field.tag)),
+		Offset:    field.offset,
+		Index:     []int{i},
+		Anonymous: field.name&1 != 0,
+	}
+}
+
+// rawStructField is a struct field as stored in the struct side table.
+type rawStructField struct {
+	typecode Type
+	offset   uintptr
+	name     uintptr // name << 1, with the lowest bit set if embedded
+	tag      uintptr
+	pkgPath  uintptr
+}
+
+// rawField returns the i'th field of this struct type, without decoding the
+// strings.
+func (t Type) rawField(i int) rawStructField {
+	if t.Kind() != Struct {
+		panic("reflect: Field of non-struct type")
+	}
+	structOffset := uintptr(t.underlying() >> 5)
+	if uint(i) >= uint(t.NumField()) {
+		panic("reflect: Field index out of bounds")
+	}
+	table := unsafe.Pointer(&structTypesSidetable)
+	fieldOffset := structOffset + 2 + uintptr(i)*5
+	return rawStructField{
+		typecode: Type(readSidetable(table, fieldOffset)),
+		offset:   readSidetable(table, fieldOffset+1),
+		name:     readSidetable(table, fieldOffset+2),
+		tag:      readSidetable(table, fieldOffset+3),
+		pkgPath:  readSidetable(table, fieldOffset+4),
+	}
 }
 
+// Bits returns the size of a numeric type in bits.
 func (t Type) Bits() int {
-	panic("unimplemented: (reflect.Type).Bits()")
+	switch t.Kind() {
+	case Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64, Uintptr, Float32, Float64, Complex64, Complex128:
+		return int(t.Size()) * 8
+	default:
+		panic("reflect: Bits of non-arithmetic type")
+	}
 }
 
+// Len returns the length of an array type.
 func (t Type) Len() int {
-	panic("unimplemented: (reflect.Type).Len()")
+	if t.Kind() != Array {
+		panic("reflect: Len of non-array type")
+	}
+	index := uintptr(t.underlying() >> 5)
+	return int(readSidetable(unsafe.Pointer(&arrayTypesSidetable), index*2+1))
 }
 
+// NumField returns the number of fields of a struct type.
 func (t Type) NumField() int {
-	panic("unimplemented: (reflect.Type).NumField()")
+	if t.Kind() != Struct {
+		panic("reflect: NumField of non-struct type")
+	}
+	structOffset := uintptr(t.underlying() >> 5)
+	return int(readSidetable(unsafe.Pointer(&structTypesSidetable), structOffset+1))
 }
 
 func (t Type) Size() uintptr {
@@ -198,12 +332,125 @@ func (t Type) Size() uintptr {
 		return unsafe.Sizeof(uintptr(0))
 	case Slice:
 		return unsafe.Sizeof(SliceHeader{})
+	case Interface:
+		return unsafe.Sizeof(interfaceHeader{})
+	case Func:
+		return unsafe.Sizeof(funcHeader{})
+	case Array:
+		return t.Elem().Size() * uintptr(t.Len())
+	case Struct:
+		structOffset := uintptr(t.underlying() >> 5)
+		return readSidetable(unsafe.Pointer(&structTypesSidetable), structOffset)
 	default:
 		panic("unimplemented: size of type")
 	}
 }
 
+// readSidetable returns the word at the given index in a side table.
+func readSidetable(table unsafe.Pointer, index uintptr) uintptr {
+	return *(*uintptr)(unsafe.Pointer(uintptr(table) + index*unsafe.Sizeof(uintptr(0))))
+}
+
+// readStringSidetable returns the string at the given offset in
+// structNamesSidetable. The string is not copied.
+func readStringSidetable(offset uintptr) string {
+	ptr := uintptr(unsafe.Pointer(&structNamesSidetable)) + offset
+	// Decode the uvarint length.
+	length := uintptr(0)
+	shift := uint(0)
+	for {
+		b := *(*byte)(unsafe.Pointer(ptr))
+		ptr++
+		length |= uintptr(b&0x7f) << shift
+		if b < 0x80 {
+			break
+		}
+		shift += 7
+	}
+	s := StringHeader{
+		Data: ptr,
+		Len:  length,
+	}
+	return *(*string)(unsafe.Pointer(&s))
+}
+
+// A StructField describes a single field in a struct.
 type StructField struct {
+	// Name is the field name.
 	Name string
-	Type Type
+	// PkgPath is the package path that qualifies a lower case (unexported)
+	// field name. It is empty for upper case (exported) field names.
+	PkgPath string
+
+	Type      Type      // field type
+	Tag       StructTag // field tag string
+	Offset    uintptr   // offset within struct, in bytes
+	Index     []int     // index sequence for Type.FieldByIndex
+	Anonymous bool      // is an embedded field
+}
+
+// A StructTag is the tag string in a struct field.
+type StructTag string
+
+// Get returns the value associated with key in the tag string. If there is no
+// such key in the tag, Get returns the empty string.
+func (tag StructTag) Get(key string) string {
+	v, _ := tag.Lookup(key)
+	return v
+}
+
+// Lookup returns the value associated with key in the tag string. If the key
+// is present in the tag the value (which may be empty) is returned. Otherwise
+// the returned value will be the empty string. The ok return value reports
+// whether the value was explicitly set in the tag string.
+//
+// This implementation has been copied from the Go standard library, see
+// https://golang.org/src/reflect/type.go.
+func (tag StructTag) Lookup(key string) (value string, ok bool) {
+	for tag != "" {
+		// Skip leading space.
+		i := 0
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		tag = tag[i:]
+		if tag == "" {
+			break
+		}
+
+		// Scan to colon. A space, a quote or a control character is a syntax
+		// error.
+		i = 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			break
+		}
+		name := string(tag[:i])
+		tag = tag[i+1:]
+
+		// Scan quoted string to find value.
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			break
+		}
+		qvalue := string(tag[:i+1])
+		tag = tag[i+1:]
+
+		if key == name {
+			value, err := strconv.Unquote(qvalue)
+			if err != nil {
+				break
+			}
+			return value, true
+		}
+	}
+	return "", false
 }
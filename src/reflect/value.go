@@ -30,18 +30,83 @@ func (v Value) Interface() interface{} {
 		typecode: v.typecode,
 		value:    v.value,
 	}
-	if v.indirect && v.Type().Size() <= unsafe.Sizeof(uintptr(0)) {
-		// Value was indirect but must be put back directly in the interface
-		// value.
-		var value uintptr
-		for j := v.Type().Size(); j != 0; j-- {
-			value = (value << 8) | uintptr(*(*uint8)(unsafe.Pointer(uintptr(v.value) + j - 1)))
+	if v.indirect {
+		size := v.Type().Size()
+		if size <= unsafe.Sizeof(uintptr(0)) {
+			// Value was indirect but must be put back directly in the
+			// interface value.
+			i.value = loadValue(v.value, size)
+		} else {
+			// Copy the value, so that later changes through this Value are
+			// not visible in the interface.
+			i.value = alloc(size, 0)
+			memcpy(i.value, v.value, size)
 		}
-		i.value = unsafe.Pointer(value)
 	}
 	return *(*interface{})(unsafe.Pointer(&i))
 }
 
+// loadValue loads a value of the given size from memory, to be stored directly
+// in a Value or interface. The value must fit in a pointer.
+func loadValue(ptr unsafe.Pointer, size uintptr) unsafe.Pointer {
+	var value uintptr
+	for j := size; j != 0; j-- {
+		value = (value << 8) | uintptr(*(*uint8)(unsafe.Pointer(uintptr(ptr) + j - 1)))
+	}
+	return unsafe.Pointer(value)
+}
+
+// valueAt returns a Value for the value of the given type stored at ptr. If
+// indirect is set, the returned value refers to this memory and is
+// addressable.
+func valueAt(typ Type, ptr unsafe.Pointer, indirect bool) Value {
+	if !indirect && typ.Size() <= unsafe.Sizeof(uintptr(0)) {
+		return Value{
+			typecode: typ,
+			value:    loadValue(ptr, typ.Size()),
+		}
+	}
+	return Value{
+		typecode: typ,
+		value:    ptr,
+		indirect: indirect,
+	}
+}
+
+// isDirect returns whether the value itself is stored in v.value, instead of a
+// pointer to the value.
+func (v Value) isDirect() bool {
+	return !v.indirect && v.Type().Size() <= unsafe.Sizeof(uintptr(0))
+}
+
+// pointer returns the pointer stored in a pointer-like value (chan, map,
+// pointer, unsafe.Pointer).
+func (v Value) pointer() unsafe.Pointer {
+	if v.indirect {
+		return *(*unsafe.Pointer)(v.value)
+	}
+	return v.value
+}
+
+// extract returns the value of the given type at the given offset within this
+// value, for struct fields and array elements.
+func (v Value) extract(typ Type, offset uintptr) Value {
+	if v.isDirect() {
+		// The value is stored directly in v.value, so the field or element
+		// must be extracted from there.
+		size := typ.Size()
+		value := uintptr(v.value) >> (offset * 8)
+		if size < unsafe.Sizeof(uintptr(0)) {
+			value &= 1<<(size*8) - 1
+		}
+		return Value{
+			typecode: typ,
+			value:    unsafe.Pointer(value),
+		}
+	}
+	return valueAt(typ, unsafe.Pointer(uintptr(v.value)+offset), v.indirect)
+}
+
 func (v Value) Type() Type {
 	return v.typecode
 }
@@ -53,7 +118,7 @@ func (v Value) Kind() Kind {
 func (v Value) IsNil() bool {
 	switch v.Kind() {
 	case Chan, Map, Ptr:
-		return v.value == nil
+		return v.pointer() == nil
 	case Func:
 		if v.value == nil {
 			return true
@@ -80,7 +145,7 @@ func (v Value) IsNil() bool {
 func (v Value) Pointer() uintptr {
 	switch v.Kind() {
 	case Chan, Map, Ptr, UnsafePointer:
-		return uintptr(v.value)
+		return uintptr(v.pointer())
 	case Slice:
 		slice := (*SliceHeader)(v.value)
 		return slice.Data
@@ -96,7 +161,7 @@ func (v Value) IsValid() bool {
 }
 
 func (v Value) CanInterface() bool {
-	// No Value types of private data can be constructed at the moment.
+	// Access to unexported struct fields is not restricted.
 	return true
 }
 
@@ -266,11 +331,62 @@ func (v Value) String() string {
 }
 
 func (v Value) Bytes() []byte {
-	panic("unimplemented: (reflect.Value).Bytes()")
+	if v.Kind() != Slice || v.Type().Elem().Kind() != Uint8 {
+		panic(&ValueError{"Bytes"})
+	}
+	// A slice is never stored directly in the interface, but always as a
+	// pointer to the slice value.
+	return *(*[]byte)(v.value)
 }
 
 func (v Value) Slice(i, j int) Value {
-	panic("unimplemented: (reflect.Value).Slice()")
+	switch v.Kind() {
+	case Slice:
+		slice := *(*SliceHeader)(v.value)
+		if i < 0 || j < i || uintptr(j) > slice.Cap {
+			panic("reflect: slice index out of bounds")
+		}
+		elemSize := v.Type().Elem().Size()
+		return Value{
+			typecode: v.typecode,
+			value: unsafe.Pointer(&SliceHeader{
+				Data: slice.Data + elemSize*uintptr(i),
+				Len:  uintptr(j - i),
+				Cap:  slice.Cap - uintptr(i),
+			}),
+		}
+	case Array:
+		if !v.indirect {
+			panic("reflect: slice of unaddressable array")
+		}
+		length := v.Type().Len()
+		if i < 0 || j < i || j > length {
+			panic("reflect: slice index out of bounds")
+		}
+		elemType := v.Type().Elem()
+		return Value{
+			typecode: elemType.sliceOf(),
+			value: unsafe.Pointer(&SliceHeader{
+				Data: uintptr(v.value) + elemType.Size()*uintptr(i),
+				Len:  uintptr(j - i),
+				Cap:  uintptr(length - i),
+			}),
+		}
+	case String:
+		s := *(*StringHeader)(v.value)
+		if i < 0 || j < i || uintptr(j) > s.Len {
+			panic("reflect: string slice index out of bounds")
+		}
+		return Value{
+			typecode: v.typecode,
+			value: unsafe.Pointer(&StringHeader{
+				Data: s.Data + uintptr(i),
+				Len:  uintptr(j - i),
+			}),
+		}
+	default:
+		panic(&ValueError{"Slice"})
+	}
 }
 
 func (v Value) Len() int {
@@ -280,7 +396,11 @@ func (v Value) Len() int {
 		return int((*SliceHeader)(v.value).Len)
 	case String:
 		return int((*StringHeader)(v.value).Len)
-	default: // Array, Chan, Map
+	case Array:
+		return t.Len()
+	case Map:
+		return mapLen(v.pointer())
+	default: // Chan
 		panic("unimplemented: (reflect.Value).Len()")
 	}
 }
@@ -290,13 +410,18 @@ func (v Value) Cap() int {
 	switch t.Kind() {
 	case Slice:
 		return int((*SliceHeader)(v.value).Cap)
-	default: // Array, Chan
+	case Array:
+		return t.Len()
+	default: // Chan
 		panic("unimplemented: (reflect.Value).Cap()")
 	}
 }
 
 func (v Value) NumField() int {
-	panic("unimplemented: (reflect.Value).NumField()")
+	if v.Kind() != Struct {
+		panic(&ValueError{"NumField"})
+	}
+	return v.Type().NumField()
 }
 
 func (v Value) Elem() Value {
@@ -314,13 +439,25 @@ func (v Value) Elem() Value {
 			value:    ptr,
 			indirect: true,
 		}
-	default: // not implemented: Interface
+	case Interface:
+		// An interface is never stored directly in the interface, but always
+		// as a pointer to the interface value.
+		itf := (*interfaceHeader)(v.value)
+		return Value{
+			typecode: itf.typecode,
+			value:    itf.value,
+		}
+	default:
 		panic(&ValueError{"Elem"})
 	}
 }
 
 func (v Value) Field(i int) Value {
-	panic("unimplemented: (reflect.Value).Field()")
+	if v.Kind() != Struct {
+		panic(&ValueError{"Field"})
+	}
+	field := v.Type().rawField(i)
+	return v.extract(field.typecode, field.offset)
 }
 
 func (v Value) Index(i int) Value {
@@ -351,37 +488,132 @@ func (v Value) Index(i int) Value {
 			value:    unsafe.Pointer(uintptr(*(*uint8)(unsafe.Pointer(s.Data + uintptr(i))))),
 		}
 	case Array:
-		panic("unimplemented: (reflect.Value).Index()")
+		if uint(i) >= uint(v.Type().Len()) {
+			panic("reflect: array index out of range")
+		}
+		elemType := v.Type().Elem()
+		return v.extract(elemType, elemType.Size()*uintptr(i))
 	default:
 		panic(&ValueError{"Index"})
 	}
 }
 
 func (v Value) MapKeys() []Value {
-	panic("unimplemented: (reflect.Value).MapKeys()")
+	if v.Kind() != Map {
+		panic(&ValueError{"MapKeys"})
+	}
+	keys := make([]Value, 0, v.Len())
+	it := v.MapRange()
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	return keys
 }
 
 func (v Value) MapIndex(key Value) Value {
-	panic("unimplemented: (reflect.Value).MapIndex()")
+	if v.Kind() != Map {
+		panic(&ValueError{"MapIndex"})
+	}
+	keyType := v.Type().Key()
+	if key.Type() != keyType {
+		panic("reflect: MapIndex key has the wrong type")
+	}
+
+	// Get a pointer to the key data.
+	var keyPtr unsafe.Pointer
+	if key.isDirect() {
+		keyValue := key.value
+		keyPtr = unsafe.Pointer(&keyValue)
+	} else {
+		keyPtr = key.value
+	}
+
+	elemType := v.Type().Elem()
+	elem := alloc(elemType.Size(), 0)
+	var ok bool
+	if keyType.Kind() == String {
+		ok = mapStringGet(v.pointer(), *(*string)(keyPtr), elem)
+	} else if keyType.isBinary() {
+		ok = mapBinaryGet(v.pointer(), keyPtr, elem)
+	} else {
+		// The compiler only supports string keys and keys that can be compared
+		// as plain memory (see compiler/map.go), so there are no maps with
+		// other key types.
+		panic("reflect: MapIndex on a map with unsupported key type " + keyType.String())
+	}
+	if !ok {
+		return Value{}
+	}
+	return valueAt(elemType, elem, false)
+}
+
+// isBinary returns whether values of this type can be compared and hashed as
+// plain memory, like map keys in the runtime.
+func (t Type) isBinary() bool {
+	switch t.Kind() {
+	case Bool, Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64, Uintptr, Ptr:
+		return true
+	case Array:
+		return t.Elem().isBinary()
+	case Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !t.rawField(i).typecode.isBinary() {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
 }
 
 func (v Value) MapRange() *MapIter {
-	panic("unimplemented: (reflect.Value).MapRange()")
+	if v.Kind() != Map {
+		panic(&ValueError{"MapRange"})
+	}
+	return &MapIter{
+		m: v,
+	}
 }
 
+// A MapIter is an iterator for ranging over a map. See Value.MapRange.
 type MapIter struct {
+	m     Value
+	it    hashmapIterator
+	key   Value
+	value Value
 }
 
+// Key returns the key of the iterator's current map entry.
 func (it *MapIter) Key() Value {
-	panic("unimplemented: (*reflect.MapIter).Key()")
+	return it.key
 }
 
+// Value returns the value of the iterator's current map entry.
 func (it *MapIter) Value() Value {
-	panic("unimplemented: (*reflect.MapIter).Value()")
+	return it.value
 }
 
+// Next advances the map iterator and reports whether there is another entry.
+// It returns false when the iterator is exhausted.
 func (it *MapIter) Next() bool {
-	panic("unimplemented: (*reflect.MapIter).Next()")
+	m := it.m.pointer()
+	if m == nil {
+		// Iterating over a nil map, which has no entries.
+		return false
+	}
+	keyType := it.m.Type().Key()
+	elemType := it.m.Type().Elem()
+	key := alloc(keyType.Size(), 0)
+	elem := alloc(elemType.Size(), 0)
+	if !mapNext(m, unsafe.Pointer(&it.it), key, elem) {
+		it.key = Value{}
+		it.value = Value{}
+		return false
+	}
+	it.key = valueAt(keyType, key, false)
+	it.value = valueAt(elemType, elem, false)
+	return true
 }
 
 func (v Value) Set(x Value) {
@@ -498,16 +730,61 @@ func (v Value) SetString(x string) {
 	}
 }
 
+// MakeSlice creates a new zero-initialized slice value for the specified slice
+// type, length, and capacity.
 func MakeSlice(typ Type, len, cap int) Value {
-	panic("unimplemented: reflect.MakeSlice()")
+	if typ.Kind() != Slice {
+		panic("reflect.MakeSlice of non-slice type")
+	}
+	if len < 0 {
+		panic("reflect.MakeSlice: negative len")
+	}
+	if cap < 0 {
+		panic("reflect.MakeSlice: negative cap")
+	}
+	if len > cap {
+		panic("reflect.MakeSlice: len > cap")
+	}
+	elemSize := typ.Elem().Size()
+	return Value{
+		typecode: typ,
+		value: unsafe.Pointer(&SliceHeader{
+			Data: uintptr(alloc(elemSize*uintptr(cap), 0)),
+			Len:  uintptr(len),
+			Cap:  uintptr(cap),
+		}),
+	}
 }
 
+// Zero returns a Value representing the zero value for the specified type.
+// The returned value is not addressable.
 func Zero(typ Type) Value {
-	panic("unimplemented: reflect.Zero()")
+	if typ.Size() <= unsafe.Sizeof(uintptr(0)) {
+		// The zero value is stored directly in the Value.
+		return Value{
+			typecode: typ,
+		}
+	}
+	return Value{
+		typecode: typ,
+		value:    alloc(typ.Size(), 0),
+	}
 }
 
+// New returns a Value representing a pointer to a new zero value for the
+// specified type.
 func New(typ Type) Value {
-	panic("unimplemented: reflect.New()")
+	return Value{
+		typecode: typ.ptrTo(),
+		value:    alloc(typ.Size(), 0),
+	}
+}
+
+// hashmapIterator has the same layout as runtime.hashmapIterator.
+type hashmapIterator struct {
+	bucketNumber uintptr
+	bucket       unsafe.Pointer
+	bucketIndex  uint8
 }
 
 type funcHeader struct {
@@ -542,3 +819,14 @@ func (e *ValueError) Error() string {
 
 //go:linkname memcpy runtime.memcpy
 func memcpy(dst, src unsafe.Pointer, size uintptr)
+
+// The layout of all objects allocated here is unknown to the compiler, so it
+// is passed as 0 to scan them conservatively.
+//go:linkname alloc runtime.alloc
+func alloc(size uintptr, layout uintptr) unsafe.Pointer
+
+// Implemented in the runtime, see src/runtime/hashmap.go.
+func mapLen(m unsafe.Pointer) int
+func mapStringGet(m unsafe.Pointer, key string, value unsafe.Pointer) bool
+func mapBinaryGet(m unsafe.Pointer, key, value unsafe.Pointer) bool
+func mapNext(m, it, key, value unsafe.Pointer) bool
@@ -2,6 +2,12 @@
 
 package runtime
 
+import (
+	"device/avr"
+	"runtime/volatile"
+	"unsafe"
+)
+
 const GOARCH = "arm" // avr pretends to be arm
 
 // The bitness of the CPU (e.g. 8, 32, 64).
@@ -12,3 +18,21 @@ func align(ptr uintptr) uintptr {
 	// No alignment necessary on the AVR.
 	return ptr
 }
+
+// The status register, which contains the global interrupt enable flag (I).
+var sreg = (*uint8)(unsafe.Pointer(uintptr(0x5f)))
+
+// lockAtomics disables interrupts, to make the sync/atomic implementations in
+// atomic.go atomic.
+func lockAtomics() uintptr {
+	mask := uintptr(volatile.LoadUint8(sreg))
+	avr.Asm("cli")
+	return mask
+}
+
+// unlockAtomics restores the interrupt state from before lockAtomics.
+func unlockAtomics(mask uintptr) {
+	if mask&0x80 != 0 {
+		avr.Asm("sei")
+	}
+}
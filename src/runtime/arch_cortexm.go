@@ -19,3 +19,14 @@ func align(ptr uintptr) uintptr {
 func getCurrentStackPointer() uintptr {
 	return arm.ReadRegister("sp")
 }
+
+// lockAtomics disables interrupts, to make the sync/atomic implementations in
+// atomic.go atomic.
+func lockAtomics() uintptr {
+	return arm.DisableInterrupts()
+}
+
+// unlockAtomics restores the interrupt state from before lockAtomics.
+func unlockAtomics(mask uintptr) {
+	arm.EnableInterrupts(mask)
+}
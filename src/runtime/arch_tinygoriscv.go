@@ -17,3 +17,18 @@ func align(ptr uintptr) uintptr {
 func getCurrentStackPointer() uintptr {
 	return riscv.ReadRegister("sp")
 }
+
+// lockAtomics disables interrupts, to make the sync/atomic implementations in
+// atomic.go atomic.
+func lockAtomics() uintptr {
+	mask := riscv.ReadRegister("mstatus")
+	riscv.Asm("csrci mstatus, 8") // clear MIE
+	return mask
+}
+
+// unlockAtomics restores the interrupt state from before lockAtomics.
+func unlockAtomics(mask uintptr) {
+	if mask&8 != 0 {
+		riscv.Asm("csrsi mstatus, 8") // set MIE
+	}
+}
@@ -17,6 +17,9 @@ var heapStartSymbol unsafe.Pointer
 //go:export llvm.wasm.memory.size.i32
 func wasm_memory_size(index int32) int32
 
+//go:export llvm.wasm.memory.grow.i32
+func wasm_memory_grow(index int32, delta int32) int32
+
 var (
 	heapStart = uintptr(unsafe.Pointer(&heapStartSymbol))
 	heapEnd   = uintptr(wasm_memory_size(0) * wasmPageSize)
@@ -24,6 +27,18 @@ var (
 
 const wasmPageSize = 64 * 1024
 
+// growHeapMemory grows the linear memory, doubling its size, and extends the
+// heap to the new end of memory. It returns false when the memory could not be
+// grown.
+func growHeapMemory() bool {
+	// Grow memory by the current size, which is a doubling.
+	if wasm_memory_grow(0, wasm_memory_size(0)) == -1 {
+		return false
+	}
+	heapEnd = uintptr(wasm_memory_size(0) * wasmPageSize)
+	return true
+}
+
 // Align on word boundary.
 func align(ptr uintptr) uintptr {
 	return (ptr + 3) &^ 3
@@ -1,24 +1,279 @@
 package runtime
 
 // This file contains implementations for the sync/atomic package.
+//
+// Calls to these functions are normally replaced with atomic instructions by
+// the compiler. These implementations are only used on targets without
+// (sufficiently wide) atomic instructions, or when a function is called
+// indirectly. They are made atomic by disabling interrupts, which is enough
+// as there is only a single thread of execution.
 
-// All implementations assume there are no goroutines, threads or interrupts.
+import (
+	"unsafe"
+)
+
+//go:linkname addInt32 sync/atomic.AddInt32
+func addInt32(addr *int32, delta int32) int32 {
+	mask := lockAtomics()
+	*addr += delta
+	val := *addr
+	unlockAtomics(mask)
+	return val
+}
+
+//go:linkname addInt64 sync/atomic.AddInt64
+func addInt64(addr *int64, delta int64) int64 {
+	mask := lockAtomics()
+	*addr += delta
+	val := *addr
+	unlockAtomics(mask)
+	return val
+}
+
+//go:linkname addUint32 sync/atomic.AddUint32
+func addUint32(addr *uint32, delta uint32) uint32 {
+	mask := lockAtomics()
+	*addr += delta
+	val := *addr
+	unlockAtomics(mask)
+	return val
+}
+
+//go:linkname addUint64 sync/atomic.AddUint64
+func addUint64(addr *uint64, delta uint64) uint64 {
+	mask := lockAtomics()
+	*addr += delta
+	val := *addr
+	unlockAtomics(mask)
+	return val
+}
+
+//go:linkname addUintptr sync/atomic.AddUintptr
+func addUintptr(addr *uintptr, delta uintptr) uintptr {
+	mask := lockAtomics()
+	*addr += delta
+	val := *addr
+	unlockAtomics(mask)
+	return val
+}
+
+//go:linkname loadInt32 sync/atomic.LoadInt32
+func loadInt32(addr *int32) int32 {
+	mask := lockAtomics()
+	val := *addr
+	unlockAtomics(mask)
+	return val
+}
+
+//go:linkname loadInt64 sync/atomic.LoadInt64
+func loadInt64(addr *int64) int64 {
+	mask := lockAtomics()
+	val := *addr
+	unlockAtomics(mask)
+	return val
+}
+
+//go:linkname loadUint32 sync/atomic.LoadUint32
+func loadUint32(addr *uint32) uint32 {
+	mask := lockAtomics()
+	val := *addr
+	unlockAtomics(mask)
+	return val
+}
 
 //go:linkname loadUint64 sync/atomic.LoadUint64
 func loadUint64(addr *uint64) uint64 {
-	return *addr
+	mask := lockAtomics()
+	val := *addr
+	unlockAtomics(mask)
+	return val
+}
+
+//go:linkname loadUintptr sync/atomic.LoadUintptr
+func loadUintptr(addr *uintptr) uintptr {
+	mask := lockAtomics()
+	val := *addr
+	unlockAtomics(mask)
+	return val
+}
+
+//go:linkname loadPointer sync/atomic.LoadPointer
+func loadPointer(addr *unsafe.Pointer) unsafe.Pointer {
+	mask := lockAtomics()
+	val := *addr
+	unlockAtomics(mask)
+	return val
+}
+
+//go:linkname storeInt32 sync/atomic.StoreInt32
+func storeInt32(addr *int32, val int32) {
+	mask := lockAtomics()
+	*addr = val
+	unlockAtomics(mask)
+}
+
+//go:linkname storeInt64 sync/atomic.StoreInt64
+func storeInt64(addr *int64, val int64) {
+	mask := lockAtomics()
+	*addr = val
+	unlockAtomics(mask)
 }
 
 //go:linkname storeUint32 sync/atomic.StoreUint32
 func storeUint32(addr *uint32, val uint32) {
+	mask := lockAtomics()
+	*addr = val
+	unlockAtomics(mask)
+}
+
+//go:linkname storeUint64 sync/atomic.StoreUint64
+func storeUint64(addr *uint64, val uint64) {
+	mask := lockAtomics()
+	*addr = val
+	unlockAtomics(mask)
+}
+
+//go:linkname storeUintptr sync/atomic.StoreUintptr
+func storeUintptr(addr *uintptr, val uintptr) {
+	mask := lockAtomics()
 	*addr = val
+	unlockAtomics(mask)
+}
+
+//go:linkname storePointer sync/atomic.StorePointer
+func storePointer(addr *unsafe.Pointer, val unsafe.Pointer) {
+	mask := lockAtomics()
+	*addr = val
+	unlockAtomics(mask)
+}
+
+//go:linkname swapInt32 sync/atomic.SwapInt32
+func swapInt32(addr *int32, new int32) int32 {
+	mask := lockAtomics()
+	old := *addr
+	*addr = new
+	unlockAtomics(mask)
+	return old
+}
+
+//go:linkname swapInt64 sync/atomic.SwapInt64
+func swapInt64(addr *int64, new int64) int64 {
+	mask := lockAtomics()
+	old := *addr
+	*addr = new
+	unlockAtomics(mask)
+	return old
+}
+
+//go:linkname swapUint32 sync/atomic.SwapUint32
+func swapUint32(addr *uint32, new uint32) uint32 {
+	mask := lockAtomics()
+	old := *addr
+	*addr = new
+	unlockAtomics(mask)
+	return old
+}
+
+//go:linkname swapUint64 sync/atomic.SwapUint64
+func swapUint64(addr *uint64, new uint64) uint64 {
+	mask := lockAtomics()
+	old := *addr
+	*addr = new
+	unlockAtomics(mask)
+	return old
+}
+
+//go:linkname swapUintptr sync/atomic.SwapUintptr
+func swapUintptr(addr *uintptr, new uintptr) uintptr {
+	mask := lockAtomics()
+	old := *addr
+	*addr = new
+	unlockAtomics(mask)
+	return old
+}
+
+//go:linkname swapPointer sync/atomic.SwapPointer
+func swapPointer(addr *unsafe.Pointer, new unsafe.Pointer) unsafe.Pointer {
+	mask := lockAtomics()
+	old := *addr
+	*addr = new
+	unlockAtomics(mask)
+	return old
+}
+
+//go:linkname compareAndSwapInt32 sync/atomic.CompareAndSwapInt32
+func compareAndSwapInt32(addr *int32, old, new int32) bool {
+	mask := lockAtomics()
+	swapped := *addr == old
+	if swapped {
+		*addr = new
+	}
+	unlockAtomics(mask)
+	return swapped
+}
+
+//go:linkname compareAndSwapInt64 sync/atomic.CompareAndSwapInt64
+func compareAndSwapInt64(addr *int64, old, new int64) bool {
+	mask := lockAtomics()
+	swapped := *addr == old
+	if swapped {
+		*addr = new
+	}
+	unlockAtomics(mask)
+	return swapped
+}
+
+//go:linkname compareAndSwapUint32 sync/atomic.CompareAndSwapUint32
+func compareAndSwapUint32(addr *uint32, old, new uint32) bool {
+	mask := lockAtomics()
+	swapped := *addr == old
+	if swapped {
+		*addr = new
+	}
+	unlockAtomics(mask)
+	return swapped
 }
 
 //go:linkname compareAndSwapUint64 sync/atomic.CompareAndSwapUint64
 func compareAndSwapUint64(addr *uint64, old, new uint64) bool {
-	if *addr == old {
+	mask := lockAtomics()
+	swapped := *addr == old
+	if swapped {
+		*addr = new
+	}
+	unlockAtomics(mask)
+	return swapped
+}
+
+//go:linkname compareAndSwapUintptr sync/atomic.CompareAndSwapUintptr
+func compareAndSwapUintptr(addr *uintptr, old, new uintptr) bool {
+	mask := lockAtomics()
+	swapped := *addr == old
+	if swapped {
 		*addr = new
-		return true
 	}
-	return false
+	unlockAtomics(mask)
+	return swapped
+}
+
+//go:linkname compareAndSwapPointer sync/atomic.CompareAndSwapPointer
+func compareAndSwapPointer(addr *unsafe.Pointer, old, new unsafe.Pointer) bool {
+	mask := lockAtomics()
+	swapped := *addr == old
+	if swapped {
+		*addr = new
+	}
+	unlockAtomics(mask)
+	return swapped
+}
+
+// Goroutines are never preempted and there is only a single thread, so pinning
+// the current goroutine (as done by atomic.Value) is a no-op.
+
+//go:linkname procPin sync/atomic.runtime_procPin
+func procPin() {
+}
+
+//go:linkname procUnpin sync/atomic.runtime_procUnpin
+func procUnpin() {
 }
@@ -0,0 +1,13 @@
+// +build !avr,!cortexm,!tinygo.riscv
+
+package runtime
+
+// These targets have no interrupts that can run in the middle of an atomic
+// operation, so no locking is needed.
+
+func lockAtomics() uintptr {
+	return 0
+}
+
+func unlockAtomics(mask uintptr) {
+}
@@ -28,3 +28,9 @@ var (
 	globalsEnd   = uintptr(unsafe.Pointer(&globalsEndSymbol))
 	stackTop     = uintptr(unsafe.Pointer(&stackTopSymbol))
 )
+
+// growHeapMemory returns false, as the heap has a fixed size on baremetal
+// systems.
+func growHeapMemory() bool {
+	return false
+}
@@ -22,6 +22,17 @@ package runtime
 // the 'comma-ok' value to true.
 // A receive operation on a closed channel is completed by zeroing the data
 // element of the receiving coroutine and setting the 'comma-ok' value to false.
+//
+// A buffered channel additionally has a ring buffer of values. A send stores
+// the value in the buffer if there is space and a receive takes the oldest
+// value from the buffer if there is one, without blocking. Therefore, a
+// buffered channel can only be in the send state when the buffer is full and
+// only in the recv state when the buffer is empty.
+//
+// A blocking select statement that cannot proceed immediately parks its
+// goroutine in selectWaiters. All tasks in there are woken up whenever a
+// channel operation happens that might allow them to proceed, after which they
+// try again.
 
 import (
 	"unsafe"
@@ -31,6 +42,10 @@ type channel struct {
 	elementSize uint16 // the size of one value in this channel
 	state       chanState
 	blocked     *coroutine
+	bufSize     uintptr        // the number of values that fit in the buffer
+	bufUsed     uintptr        // the number of values currently in the buffer
+	bufHead     uintptr        // the index of the oldest value in the buffer
+	buf         unsafe.Pointer // the ring buffer, or nil for unbuffered channels
 }
 
 type chanState uint8
@@ -50,8 +65,86 @@ type chanSelectState struct {
 	value unsafe.Pointer
 }
 
+// Tasks blocked in a select statement, linked through the next field of their
+// promise.
+var selectWaiters *coroutine
+
 func deadlockStub()
 
+// chanMake creates a new channel for values of the given size, with room for
+// bufSize values in the buffer.
+func chanMake(elementSize uintptr, bufSize uintptr) *channel {
+	if bufSize > ^uintptr(0)>>1 {
+		runtimePanic("makechan: size out of range")
+	}
+	ch := (*channel)(alloc(unsafe.Sizeof(channel{}), layoutUnknown))
+	ch.elementSize = uint16(elementSize)
+	ch.bufSize = bufSize
+	if bufSize != 0 {
+		ch.buf = alloc(elementSize*bufSize, layoutUnknown)
+	}
+	return ch
+}
+
+// chanLen returns the number of values in the channel buffer.
+func chanLen(ch *channel) int {
+	if ch == nil {
+		return 0
+	}
+	return int(ch.bufUsed)
+}
+
+// chanCap returns the size of the channel buffer.
+func chanCap(ch *channel) int {
+	if ch == nil {
+		return 0
+	}
+	return int(ch.bufSize)
+}
+
+// bufSlot returns a pointer to the value at the given index in the buffer.
+func (ch *channel) bufSlot(index uintptr) unsafe.Pointer {
+	return unsafe.Pointer(uintptr(ch.buf) + index*uintptr(ch.elementSize))
+}
+
+// bufPush copies the value to the end of the buffer, which must not be full.
+func (ch *channel) bufPush(value unsafe.Pointer) {
+	memcpy(ch.bufSlot((ch.bufHead+ch.bufUsed)%ch.bufSize), value, uintptr(ch.elementSize))
+	ch.bufUsed++
+}
+
+// bufPop copies the oldest value in the buffer, which must not be empty, to
+// value. If a sender is blocked on the buffer, its value takes the place that
+// was freed and the sender is re-activated.
+func (ch *channel) bufPop(value unsafe.Pointer) {
+	memcpy(value, ch.bufSlot(ch.bufHead), uintptr(ch.elementSize))
+	ch.bufHead = (ch.bufHead + 1) % ch.bufSize
+	ch.bufUsed--
+	if ch.state == chanStateSend {
+		sender := ch.blocked
+		senderPromise := sender.promise()
+		ch.bufPush(senderPromise.ptr)
+		ch.blocked = senderPromise.next
+		senderPromise.next = nil
+		activateTask(sender)
+		if ch.blocked == nil {
+			ch.state = chanStateEmpty
+		}
+	}
+}
+
+// wakeSelectWaiters re-activates all tasks blocked in a select statement, so
+// that they can check whether they can proceed now.
+func wakeSelectWaiters() {
+	for selectWaiters != nil {
+		t := selectWaiters
+		promise := t.promise()
+		selectWaiters = promise.next
+		promise.next = nil
+		activateTask(t)
+	}
+}
+
 // chanSend sends a single value over the channel. If this operation can
 // complete immediately (there is a goroutine waiting for a value), it sends the
 // value and re-activates both goroutines. If not, it sets itself as waiting on
@@ -61,8 +154,16 @@ func chanSend(sender *coroutine, ch *channel, value unsafe.Pointer) {
 		// A nil channel blocks forever. Do not scheduler this goroutine again.
 		return
 	}
+	wakeSelectWaiters()
 	switch ch.state {
 	case chanStateEmpty:
+		if ch.bufUsed < ch.bufSize {
+			// There is space left in the buffer, so the send completes
+			// immediately.
+			ch.bufPush(value)
+			activateTask(sender)
+			return
+		}
 		sender.promise().ptr = value
 		ch.state = chanStateSend
 		ch.blocked = sender
@@ -96,6 +197,15 @@ func chanRecv(receiver *coroutine, ch *channel, value unsafe.Pointer) {
 		// A nil channel blocks forever. Do not scheduler this goroutine again.
 		return
 	}
+	wakeSelectWaiters()
+	if ch.bufUsed != 0 {
+		// Receive the oldest value from the buffer. This is also possible when
+		// the channel is closed.
+		ch.bufPop(value)
+		receiver.promise().data = 1 // commaOk = true
+		activateTask(receiver)
+		return
+	}
 	switch ch.state {
 	case chanStateSend:
 		sender := ch.blocked
@@ -131,6 +241,7 @@ func chanClose(ch *channel) {
 		// Not allowed by the language spec.
 		runtimePanic("close of nil channel")
 	}
+	wakeSelectWaiters()
 	switch ch.state {
 	case chanStateClosed:
 		// Not allowed by the language spec.
@@ -157,11 +268,12 @@ func chanClose(ch *channel) {
 
 // chanSelect is the runtime implementation of the select statement. This is
 // perhaps the most complicated statement in the Go spec. It returns the
-// selected index and the 'comma-ok' value.
+// selected index and the 'comma-ok' value, or ^uintptr(0) as the index if none
+// of the operations can proceed immediately.
 //
 // TODO: do this in a round-robin fashion (as specified in the Go spec) instead
 // of picking the first one that can proceed.
-func chanSelect(recvbuf unsafe.Pointer, states []chanSelectState, blocking bool) (uintptr, bool) {
+func chanSelect(recvbuf unsafe.Pointer, states []chanSelectState) (uintptr, bool) {
 	// See whether we can receive from one of the channels.
 	for i, state := range states {
 		if state.ch == nil {
@@ -170,6 +282,12 @@ func chanSelect(recvbuf unsafe.Pointer, states []chanSelectState, blocking bool)
 		}
 		if state.value == nil {
 			// A receive operation.
+			if state.ch.bufUsed != 0 {
+				// Receive the oldest value from the buffer.
+				state.ch.bufPop(recvbuf)
+				wakeSelectWaiters()
+				return uintptr(i), true // commaOk = true
+			}
 			switch state.ch.state {
 			case chanStateSend:
 				// We can receive immediately.
@@ -182,6 +300,7 @@ func chanSelect(recvbuf unsafe.Pointer, states []chanSelectState, blocking bool)
 				if state.ch.blocked == nil {
 					state.ch.state = chanStateEmpty
 				}
+				wakeSelectWaiters()
 				return uintptr(i), true // commaOk = true
 			case chanStateClosed:
 				// Receive the zero value.
@@ -202,15 +321,44 @@ func chanSelect(recvbuf unsafe.Pointer, states []chanSelectState, blocking bool)
 				if state.ch.blocked == nil {
 					state.ch.state = chanStateEmpty
 				}
+				wakeSelectWaiters()
 				return uintptr(i), false
+			case chanStateEmpty:
+				if state.ch.bufUsed < state.ch.bufSize {
+					// There is space left in the buffer.
+					state.ch.bufPush(state.value)
+					wakeSelectWaiters()
+					return uintptr(i), false
+				}
 			case chanStateClosed:
 				runtimePanic("send on closed channel")
 			}
 		}
 	}
 
-	if !blocking {
-		return ^uintptr(0), false
+	return ^uintptr(0), false
+}
+
+// chanSelectBlocking implements a select statement without default case. If
+// none of the operations can proceed, the current task is parked until another
+// channel operation happens, after which it tries again.
+func chanSelectBlocking(recvbuf unsafe.Pointer, states []chanSelectState) (uintptr, bool) {
+	for {
+		index, commaOk := chanSelect(recvbuf, states)
+		if index != ^uintptr(0) {
+			return index, commaOk
+		}
+
+		t := getCoroutine()
+		if t == nil {
+			// There is no scheduler (see lowerParkTaskWithoutScheduler in the
+			// compiler), so no other task can make this select proceed.
+			deadlock()
+		}
+		promise := t.promise()
+		promise.next = selectWaiters
+		selectWaiters = t
+		scheduleLogTask("  park in select:", t)
+		parkTask()
 	}
-	panic("unimplemented: blocking select")
 }
@@ -19,9 +19,26 @@ package runtime
 // "head" and is followed by "tail" blocks. The reason for this distinction is
 // that this way, the start and end of every object can be found easily.
 //
-// Metadata is stored in a special area at the beginning of the heap, in the
-// area heapStart..poolStart. The actual blocks are stored in
-// poolStart..heapEnd.
+// Metadata is stored in a special area at the end of the heap, in the area
+// metadataStart..heapEnd. The actual blocks are stored in
+// poolStart..metadataStart. The metadata consists of the block states,
+// followed by the pointer bitmap (starting at bitmapStart).
+//
+// On some systems (WebAssembly and hosted systems) the heap can be grown when
+// no free space is left after a garbage collection cycle. The pool then
+// extends into the newly available memory and the metadata is moved to the
+// new end of the heap, so that block numbers and object addresses stay the
+// same.
+//
+// While pointers on the stack are found conservatively, heap objects are
+// scanned using the object layout passed to alloc (see layout.go). The layout
+// is expanded into the pointer bitmap when the object is allocated: it has a
+// bit for every word in the pool, which is set if the word may contain a
+// pointer. This costs one bit per word of heap, instead of a word per object
+// if the layout were stored with the object. This way, objects without
+// pointers (like []byte buffers) are never scanned, which makes marking faster
+// and avoids keeping objects alive just because some integer looks like a
+// pointer.
 //
 // More information:
 // https://github.com/micropython/micropython/wiki/Memory-Manager
@@ -45,12 +62,15 @@ const (
 	bytesPerBlock      = wordsPerBlock * unsafe.Sizeof(heapStart)
 	stateBits          = 2 // how many bits a block state takes (see blockState type)
 	blocksPerStateByte = 8 / stateBits
+	wordsPerBitmapByte = 8 // number of words described by a byte of the pointer bitmap
 )
 
 var (
-	poolStart uintptr // the first heap pointer
-	nextAlloc gcBlock // the next block that should be tried by the allocator
-	endBlock  gcBlock // the block just past the end of the available space
+	poolStart     uintptr // the first heap pointer
+	metadataStart uintptr // start of the block state metadata, just past the pool
+	bitmapStart   uintptr // start of the pointer bitmap, just past the block states
+	nextAlloc     gcBlock // the next block that should be tried by the allocator
+	endBlock      gcBlock // the block just past the end of the available space
 )
 
 // zeroSizedAlloc is just a sentinel that gets returned when allocating 0 bytes.
@@ -130,7 +150,7 @@ func (b gcBlock) findNext() gcBlock {
 
 // State returns the current block state.
 func (b gcBlock) state() blockState {
-	stateBytePtr := (*uint8)(unsafe.Pointer(heapStart + uintptr(b/blocksPerStateByte)))
+	stateBytePtr := (*uint8)(unsafe.Pointer(metadataStart + uintptr(b/blocksPerStateByte)))
 	return blockState(*stateBytePtr>>((b%blocksPerStateByte)*2)) % 4
 }
 
@@ -138,19 +158,19 @@ func (b gcBlock) state() blockState {
 // bits than the current state. Allowed transitions: from free to any state and
 // from head to mark.
 func (b gcBlock) setState(newState blockState) {
-	stateBytePtr := (*uint8)(unsafe.Pointer(heapStart + uintptr(b/blocksPerStateByte)))
+	stateBytePtr := (*uint8)(unsafe.Pointer(metadataStart + uintptr(b/blocksPerStateByte)))
 	*stateBytePtr |= uint8(newState << ((b % blocksPerStateByte) * 2))
 	if gcAsserts && b.state() != newState {
-		runtimePanic("gc: setState() was not successful")
+		runtimeFatal("gc: setState() was not successful")
 	}
 }
 
 // markFree sets the block state to free, no matter what state it was in before.
 func (b gcBlock) markFree() {
-	stateBytePtr := (*uint8)(unsafe.Pointer(heapStart + uintptr(b/blocksPerStateByte)))
+	stateBytePtr := (*uint8)(unsafe.Pointer(metadataStart + uintptr(b/blocksPerStateByte)))
 	*stateBytePtr &^= uint8(blockStateMask << ((b % blocksPerStateByte) * 2))
 	if gcAsserts && b.state() != blockStateFree {
-		runtimePanic("gc: markFree() was not successful")
+		runtimeFatal("gc: markFree() was not successful")
 	}
 }
 
@@ -158,13 +178,13 @@ func (b gcBlock) markFree() {
 // before calling this function.
 func (b gcBlock) unmark() {
 	if gcAsserts && b.state() != blockStateMark {
-		runtimePanic("gc: unmark() on a block that is not marked")
+		runtimeFatal("gc: unmark() on a block that is not marked")
 	}
 	clearMask := blockStateMask ^ blockStateHead // the bits to clear from the state
-	stateBytePtr := (*uint8)(unsafe.Pointer(heapStart + uintptr(b/blocksPerStateByte)))
+	stateBytePtr := (*uint8)(unsafe.Pointer(metadataStart + uintptr(b/blocksPerStateByte)))
 	*stateBytePtr &^= uint8(clearMask << ((b % blocksPerStateByte) * 2))
 	if gcAsserts && b.state() != blockStateHead {
-		runtimePanic("gc: unmark() was not successful")
+		runtimeFatal("gc: unmark() was not successful")
 	}
 }
 
@@ -173,42 +193,92 @@ func (b gcBlock) unmark() {
 // any packages the runtime depends upon may not allocate memory during package
 // initialization.
 func init() {
-	totalSize := heapEnd - heapStart
-
-	// Allocate some memory to keep 2 bits of information about every block.
-	metadataSize := totalSize / (blocksPerStateByte * bytesPerBlock)
-
 	// Align the pool.
-	poolStart = (heapStart + metadataSize + (bytesPerBlock - 1)) &^ (bytesPerBlock - 1)
-	poolEnd := heapEnd &^ (bytesPerBlock - 1)
-	numBlocks := (poolEnd - poolStart) / bytesPerBlock
-	endBlock = gcBlock(numBlocks)
+	poolStart = (heapStart + (bytesPerBlock - 1)) &^ (bytesPerBlock - 1)
+
+	stateSize, bitmapSize := calculateHeapLayout()
 	if gcDebug {
 		println("heapStart:        ", heapStart)
 		println("heapEnd:          ", heapEnd)
-		println("total size:       ", totalSize)
-		println("metadata size:    ", metadataSize)
+		println("total size:       ", heapEnd-heapStart)
+		println("metadata size:    ", stateSize+bitmapSize)
 		println("poolStart:        ", poolStart)
-		println("# of blocks:      ", numBlocks)
-		println("# of block states:", metadataSize*blocksPerStateByte)
+		println("# of blocks:      ", uintptr(endBlock))
+		println("bitmapStart:      ", bitmapStart)
 	}
-	if gcAsserts && metadataSize*blocksPerStateByte < numBlocks {
+
+	// Set all block states to 'free'.
+	memzero(unsafe.Pointer(metadataStart), stateSize+bitmapSize)
+}
+
+// calculateHeapLayout divides the memory between poolStart and heapEnd into
+// blocks and the metadata for these blocks. It sets metadataStart, bitmapStart
+// and endBlock and returns the size of the block states and of the pointer
+// bitmap.
+func calculateHeapLayout() (stateSize, bitmapSize uintptr) {
+	totalSize := heapEnd - poolStart
+
+	// Every block needs stateBits bits for its state and wordsPerBlock bits in
+	// the pointer bitmap. This is the largest number of blocks for which the
+	// following is true, even after rounding up both metadata sizes:
+	//     numBlocks * bytesPerBlock + stateSize + bitmapSize <= totalSize
+	numBlocks := (totalSize - 2) * 8 / (bytesPerBlock*8 + stateBits + wordsPerBlock)
+	stateSize = (numBlocks + (blocksPerStateByte - 1)) / blocksPerStateByte
+	bitmapSize = (numBlocks*wordsPerBlock + (wordsPerBitmapByte - 1)) / wordsPerBitmapByte
+	metadataStart = heapEnd - stateSize - bitmapSize
+	bitmapStart = metadataStart + stateSize
+	endBlock = gcBlock(numBlocks)
+	if gcAsserts && endBlock.address() > metadataStart {
 		// sanity check
-		runtimePanic("gc: metadata array is too small")
+		runtimeFatal("gc: metadata overlaps with the pool")
+	}
+	return
+}
+
+// growHeap tries to make the heap bigger, and returns whether it succeeded.
+// The block metadata is moved to the new end of the heap, and the memory it
+// occupied before becomes part of the pool.
+func growHeap() bool {
+	oldMetadataStart := metadataStart
+	oldBitmapStart := bitmapStart
+	oldStateSize := bitmapStart - metadataStart
+	oldBitmapSize := heapEnd - bitmapStart
+	if !growHeapMemory() {
+		// The heap cannot be grown on this system, or the system is out of
+		// memory.
+		return false
 	}
 
-	// Set all block states to 'free'.
-	memzero(unsafe.Pointer(heapStart), metadataSize)
+	// Move the metadata to the new end of the heap. The old and new areas may
+	// overlap, but the metadata only moves up. Move the pointer bitmap first,
+	// so that it doesn't overwrite the block states before they are moved.
+	stateSize, bitmapSize := calculateHeapLayout()
+	memmove(unsafe.Pointer(bitmapStart), unsafe.Pointer(oldBitmapStart), oldBitmapSize)
+	memmove(unsafe.Pointer(metadataStart), unsafe.Pointer(oldMetadataStart), oldStateSize)
+
+	// The new blocks are all free.
+	memzero(unsafe.Pointer(metadataStart+oldStateSize), stateSize-oldStateSize)
+	memzero(unsafe.Pointer(bitmapStart+oldBitmapSize), bitmapSize-oldBitmapSize)
+
+	if gcDebug {
+		println("heap grown to:    ", heapEnd-heapStart)
+		println("# of blocks:      ", uintptr(endBlock))
+	}
+	return true
 }
 
 // alloc tries to find some free space on the heap, possibly doing a garbage
-// collection cycle if needed. If no space is free, it panics.
+// collection cycle if needed. If no space is free, it panics. The layout
+// describes where the pointers are in the allocated object.
 //go:noinline
-func alloc(size uintptr) unsafe.Pointer {
+func alloc(size uintptr, layout uintptr) unsafe.Pointer {
 	if size == 0 {
 		return unsafe.Pointer(&zeroSizedAlloc)
 	}
 
+	gcTotalAlloc += uint64(size)
+	gcMallocs++
+
 	neededBlocks := (size + (bytesPerBlock - 1)) / bytesPerBlock
 
 	// Continue looping until a run of free blocks has been found that fits the
@@ -228,7 +298,15 @@ func alloc(size uintptr) unsafe.Pointer {
 				GC()
 			} else {
 				// Even after garbage collection, no free memory could be found.
-				runtimePanic("out of memory")
+				// Try to grow the heap and continue searching in the newly
+				// available blocks.
+				oldEndBlock := endBlock
+				if !growHeap() {
+					runtimeFatal("out of memory")
+				}
+				index = oldEndBlock
+				nextAlloc = oldEndBlock
+				numFreeBlocks = 0
 			}
 		}
 
@@ -264,7 +342,9 @@ func alloc(size uintptr) unsafe.Pointer {
 				i.setState(blockStateTail)
 			}
 
-			// Return a pointer to this allocation.
+			// Store the layout in the pointer bitmap and return a pointer to
+			// this allocation.
+			setPointerBitmap(thisAlloc, nextAlloc, layout)
 			pointer := thisAlloc.pointer()
 			memzero(pointer, size)
 			return pointer
@@ -282,6 +362,12 @@ func GC() {
 		println("running collection cycle...")
 	}
 
+	// Drop all objects in sync.Pool instances, so that they can be freed in
+	// this cycle.
+	if poolCleanup != nil {
+		poolCleanup()
+	}
+
 	// Mark phase: mark all reachable objects, recursively.
 	markGlobals()
 	markStack()
@@ -306,7 +392,7 @@ func markRoots(start, end uintptr) {
 	}
 	if gcAsserts {
 		if start >= end {
-			runtimePanic("gc: unexpected range to mark")
+			runtimeFatal("gc: unexpected range to mark")
 		}
 	}
 
@@ -327,8 +413,43 @@ func markRoot(addr, root uintptr) {
 			head.setState(blockStateMark)
 			next := block.findNext()
 			// TODO: avoid recursion as much as possible
-			markRoots(head.address(), next.address())
+			markObject(head.address(), next.address())
+		}
+	}
+}
+
+// setPointerBitmap sets the bits in the pointer bitmap for the words in the
+// blocks start..end (exclusive) according to the given object layout.
+func setPointerBitmap(start, end gcBlock, layout uintptr) {
+	firstWord := uintptr(start) * wordsPerBlock
+	for word := firstWord; word < uintptr(end)*wordsPerBlock; word++ {
+		bitmapBytePtr := (*uint8)(unsafe.Pointer(bitmapStart + word/wordsPerBitmapByte))
+		bit := uint8(1) << (word % wordsPerBitmapByte)
+		if layout != layoutNoPointers && layoutHasPointer(layout, word-firstWord) {
+			*bitmapBytePtr |= bit
+		} else {
+			*bitmapBytePtr &^= bit
+		}
+	}
+}
+
+// markObject scans the heap object from start to end (exclusive), using the
+// pointer bitmap, and marks all objects it points to.
+func markObject(start, end uintptr) {
+	for addr := start; addr < end; {
+		word := (addr - poolStart) / unsafe.Sizeof(addr)
+		bits := *(*uint8)(unsafe.Pointer(bitmapStart + word/wordsPerBitmapByte)) >> (word % wordsPerBitmapByte)
+		if bits == 0 {
+			// None of the remaining words of this bitmap byte contain a
+			// pointer.
+			addr += (wordsPerBitmapByte - word%wordsPerBitmapByte) * unsafe.Sizeof(addr)
+			continue
+		}
+		if bits&1 != 0 {
+			root := *(*uintptr)(unsafe.Pointer(addr))
+			markRoot(addr, root)
 		}
+		addr += unsafe.Sizeof(addr)
 	}
 }
 
@@ -358,10 +479,10 @@ func sweep() {
 }
 
 // looksLikePointer returns whether this could be a pointer. Currently, it
-// simply returns whether it lies anywhere in the heap. Go allows interior
+// simply returns whether it lies anywhere in the pool. Go allows interior
 // pointers so we can't check alignment or anything like that.
 func looksLikePointer(ptr uintptr) bool {
-	return ptr >= poolStart && ptr < heapEnd
+	return ptr >= poolStart && ptr < endBlock.address()
 }
 
 // dumpHeap can be used for debugging purposes. It dumps the state of each heap
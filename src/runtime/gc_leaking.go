@@ -13,15 +13,17 @@ import (
 // Ever-incrementing pointer: no memory is freed.
 var heapptr = heapStart
 
-func alloc(size uintptr) unsafe.Pointer {
+func alloc(size uintptr, layout uintptr) unsafe.Pointer {
 	// TODO: this can be optimized by not casting between pointers and ints so
 	// much. And by using platform-native data types (e.g. *uint8 for 8-bit
 	// systems).
+	gcTotalAlloc += uint64(size)
+	gcMallocs++
 	size = align(size)
 	addr := heapptr
 	heapptr += size
 	if heapptr >= heapEnd {
-		runtimePanic("out of memory")
+		runtimeFatal("out of memory")
 	}
 	for i := uintptr(0); i < uintptr(size); i += 4 {
 		ptr := (*uint32)(unsafe.Pointer(addr + i))
@@ -68,7 +68,7 @@ func hashmapMake(keySize, valueSize uint8, sizeHint uintptr) *hashmap {
 		bucketBits++
 	}
 	bucketBufSize := unsafe.Sizeof(hashmapBucket{}) + uintptr(keySize)*8 + uintptr(valueSize)*8
-	buckets := alloc(bucketBufSize * (1 << bucketBits))
+	buckets := alloc(bucketBufSize*(1<<bucketBits), layoutUnknown)
 	return &hashmap{
 		buckets:    buckets,
 		keySize:    keySize,
@@ -149,7 +149,7 @@ func hashmapSet(m *hashmap, key unsafe.Pointer, value unsafe.Pointer, hash uint3
 // value into the bucket, and returns a pointer to this bucket.
 func hashmapInsertIntoNewBucket(m *hashmap, key, value unsafe.Pointer, tophash uint8) *hashmapBucket {
 	bucketBufSize := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*8
-	bucketBuf := alloc(bucketBufSize)
+	bucketBuf := alloc(bucketBufSize, layoutUnknown)
 	// Insert into the first slot, which is empty as it has just been allocated.
 	slotKeyOffset := unsafe.Sizeof(hashmapBucket{})
 	slotKey := unsafe.Pointer(uintptr(bucketBuf) + slotKeyOffset)
@@ -318,3 +318,32 @@ func hashmapStringDelete(m *hashmap, key string) {
 	hash := hashmapStringHash(key)
 	hashmapDelete(m, unsafe.Pointer(&key), hash, hashmapStringEqual)
 }
+
+// Hashmap operations for the reflect package, which does not know about the
+// hashmap type.
+
+//go:linkname reflect_mapLen reflect.mapLen
+func reflect_mapLen(m unsafe.Pointer) int {
+	return hashmapLen((*hashmap)(m))
+}
+
+//go:linkname reflect_mapStringGet reflect.mapStringGet
+func reflect_mapStringGet(m unsafe.Pointer, key string, value unsafe.Pointer) bool {
+	if m == nil {
+		return false
+	}
+	return hashmapStringGet((*hashmap)(m), key, value)
+}
+
+//go:linkname reflect_mapBinaryGet reflect.mapBinaryGet
+func reflect_mapBinaryGet(m unsafe.Pointer, key, value unsafe.Pointer) bool {
+	if m == nil {
+		return false
+	}
+	return hashmapBinaryGet((*hashmap)(m), key, value)
+}
+
+//go:linkname reflect_mapNext reflect.mapNext
+func reflect_mapNext(m, it, key, value unsafe.Pointer) bool {
+	return hashmapNext((*hashmap)(m), (*hashmapIterator)(it), key, value)
+}
@@ -0,0 +1,55 @@
+package runtime
+
+// This file describes the object layouts that are passed to alloc, which tell
+// the garbage collector where the pointers in a heap object are. They are
+// created by the compiler (see getObjectLayout in compiler/gc.go).
+//
+// A layout describes a type as a number of words (pointer-sized values) and a
+// bitmap with a bit set for each word that may contain a pointer. An object is
+// an array of one or more values of this type, so the layout repeats until the
+// end of the object. A layout value is one of the following:
+//
+//   * 0: the layout is not known, so the whole object must be scanned.
+//   * A value with the lowest bit set: the layout is stored in the value
+//     itself. The layoutSizeBits bits above the lowest bit contain the number
+//     of words, the remaining bits contain the bitmap.
+//   * Otherwise, a pointer to a global that contains the number of words
+//     (as an uintptr) followed by the bitmap (as a byte array).
+
+import (
+	"unsafe"
+)
+
+const (
+	// layoutUnknown is the layout of objects that must be scanned
+	// conservatively, as their layout is not known.
+	layoutUnknown = 0
+
+	// layoutSizeBits is the number of bits used to store the number of words
+	// in a layout stored inline: 4 bits on 16-bit, 5 bits on 32-bit, and 6
+	// bits on 64-bit systems.
+	layoutSizeBits = 4 + unsafe.Sizeof(uintptr(0))/4
+
+	// layoutNoPointers is the layout of objects that do not contain any
+	// pointers, like strings and []byte buffers.
+	layoutNoPointers = 1<<1 | 1
+)
+
+// layoutHasPointer returns whether the word at the given index in an object
+// with the given layout may contain a pointer.
+func layoutHasPointer(layout, index uintptr) bool {
+	if layout == layoutUnknown {
+		return true
+	}
+	if layout&1 != 0 {
+		// Inline layout.
+		size := (layout >> 1) & (1<<layoutSizeBits - 1)
+		bitmap := layout >> (1 + layoutSizeBits)
+		return (bitmap>>(index%size))&1 != 0
+	}
+	// Layout stored in a global.
+	size := *(*uintptr)(unsafe.Pointer(layout))
+	index %= size
+	bitmapByte := *(*uint8)(unsafe.Pointer(layout + unsafe.Sizeof(uintptr(0)) + index/8))
+	return (bitmapByte>>(index%8))&1 != 0
+}
@@ -0,0 +1,26 @@
+package runtime
+
+// Memory statistics, as far as they are tracked by the memory allocator.
+
+// MemStats records statistics about the memory allocator. Only a subset of the
+// fields of the upstream runtime is available.
+type MemStats struct {
+	// TotalAlloc is the cumulative number of bytes allocated for heap objects.
+	// It doesn't decrease when objects are freed.
+	TotalAlloc uint64
+
+	// Mallocs is the cumulative count of heap objects allocated.
+	Mallocs uint64
+}
+
+// Allocation counters, updated by alloc.
+var (
+	gcTotalAlloc uint64 // total number of bytes allocated
+	gcMallocs    uint64 // total number of objects allocated
+)
+
+// ReadMemStats populates m with memory allocator statistics.
+func ReadMemStats(m *MemStats) {
+	m.TotalAlloc = gcTotalAlloc
+	m.Mallocs = gcMallocs
+}
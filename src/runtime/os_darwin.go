@@ -3,3 +3,8 @@
 package runtime
 
 const GOOS = "darwin"
+
+const (
+	MAP_ANONYMOUS = 0x1000
+	MAP_NORESERVE = 0x40
+)
@@ -3,3 +3,8 @@
 package runtime
 
 const GOOS = "linux"
+
+const (
+	MAP_ANONYMOUS = 0x20
+	MAP_NORESERVE = 0x4000
+)
@@ -0,0 +1,26 @@
+package runtime
+
+// This file implements capturing of the output written to os.Stdout, which is
+// used by the testing package to compare the output of examples with their
+// expected output.
+
+// stdoutCapture receives the data written to os.Stdout while it is captured.
+var stdoutCapture *[]byte
+
+// testing_captureStdout redirects everything written to os.Stdout to the given
+// buffer, or stops capturing when the buffer is nil.
+//go:linkname testing_captureStdout testing.captureStdout
+func testing_captureStdout(buf *[]byte) {
+	stdoutCapture = buf
+}
+
+// os_runtime_captureStdout is called for every write to os.Stdout. It stores
+// the data and returns true when the output is being captured.
+//go:linkname os_runtime_captureStdout os.runtime_captureStdout
+func os_runtime_captureStdout(b []byte) bool {
+	if stdoutCapture == nil {
+		return false
+	}
+	*stdoutCapture = append(*stdoutCapture, b...)
+	return true
+}
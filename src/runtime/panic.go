@@ -1,30 +1,219 @@
 package runtime
 
+// This file implements panic and recover. See compiler/panic.go for the
+// compiler side.
+//
+// Panics are implemented by setting the panicking flag and returning from
+// _panic like it was a normal function call. The compiler inserts a check
+// after every call, so that a function returns immediately (or jumps to a
+// landing pad that runs the deferred calls) when the called function panicked.
+// This way, the stack is unwound without any target-specific support, which
+// means it also works on WebAssembly.
+// Unwinding is only enabled when the program actually uses defer. Otherwise,
+// a panic simply prints the panic message and aborts.
+
 // trap is a compiler hint that this function cannot be executed. It is
 // translated into either a trap instruction or a call to abort().
 //go:export llvm.trap
 func trap()
 
+// The Error interface identifies a run time error.
+type Error interface {
+	error
+
+	// RuntimeError is a no-op function but serves to distinguish types that
+	// are run time errors from ordinary errors.
+	RuntimeError()
+}
+
+// runtimeError is the value passed to panic for run time errors, like an index
+// out of range.
+type runtimeError struct {
+	msg string
+}
+
+func (e *runtimeError) Error() string {
+	return "runtime error: " + e.msg
+}
+
+func (e *runtimeError) RuntimeError() {}
+
+// panicRecord is allocated on the stack by each function with deferred calls
+// that is unwound by a panic. It keeps track of the panic value while the
+// deferred calls are running, so that recover() can return it. As it is stored
+// in the frame of the function, each goroutine has its own panic records.
+type panicRecord struct {
+	value     interface{}
+	recovered bool
+}
+
+// The panicking flag and the panic value are only set while the stack is being
+// unwound, that is, between a call to panic and the next landing pad (or the
+// top of the goroutine). No other goroutine runs in between, as a blocking
+// function that returns while panicking resumes its caller right away (see
+// activateTask). Therefore, they don't need to be stored per goroutine.
+var (
+	// panicking is set while the stack is being unwound.
+	panicking bool
+
+	// panicValue is the value passed to panic while panicking is set.
+	panicValue interface{}
+
+	// panicParent is the coroutine that was waiting on a blocking function
+	// that returned while panicking. It must be resumed right away by the
+	// scheduler so it can continue unwinding the stack.
+	panicParent *coroutine
+)
+
+// The deferred call that a landing pad is about to make, see
+// startDeferredCall. It is claimed by the called function before it does
+// anything else, so other goroutines cannot run in between either.
+var (
+	deferredCall       uintptr
+	deferredCallRecord *panicRecord
+)
+
+// supportsRecover returns whether stack unwinding is enabled in this program.
+// It is replaced with a constant by the compiler.
+func supportsRecover() bool
+
 // Builtin function panic(msg), used as a compiler intrinsic.
 func _panic(message interface{}) {
+	savePanicStack()
+	if supportsRecover() {
+		// Start unwinding the stack. The compiler-inserted check after this
+		// call will return from the calling function.
+		panicking = true
+		panicValue = message
+		return
+	}
 	printstring("panic: ")
 	printitf(message)
 	printnl()
+	printPanicStack()
 	abort()
 }
 
 // Cause a runtime panic, which is (currently) always a string.
 func runtimePanic(msg string) {
+	if supportsRecover() {
+		_panic(&runtimeError{msg})
+		return
+	}
+	runtimeFatal(msg)
+}
+
+// runtimeFatal is like runtimePanic, but cannot be recovered. It is used for
+// errors inside the runtime itself (like running out of memory) where it is not
+// possible to continue.
+func runtimeFatal(msg string) {
+	savePanicStack()
 	printstring("panic: runtime error: ")
 	println(msg)
+	printPanicStack()
 	abort()
 }
 
-// Try to recover a panicking goroutine.
-func _recover() interface{} {
-	// Deferred functions are currently not executed during panic, so there is
-	// no way this can return anything besides nil.
-	return nil
+// beginUnwind is called from the landing pad of a function with deferred calls
+// when a panic reaches it, before the deferred calls are run. This may be a new
+// panic from within a deferred call while already unwinding, in which case it
+// replaces the previous panic.
+func beginUnwind(record *panicRecord) {
+	record.value = panicValue
+	record.recovered = false
+	panicking = false
+	panicValue = nil
+}
+
+// startDeferredCall is called from the landing pad right before each deferred
+// call, with the value that identifies the called function: its function
+// pointer.
+func startDeferredCall(record *panicRecord, fn uintptr) {
+	deferredCall = fn
+	deferredCallRecord = record
+}
+
+// forwardDeferredCall is called at the start of a method wrapper (like the
+// wrapper of a method called through an interface) with the function pointers
+// of the wrapper and the wrapped method. When the wrapper is the deferred call,
+// the wrapped method it calls takes its place.
+func forwardDeferredCall(wrapper, fn uintptr) {
+	if deferredCallRecord != nil && deferredCall == wrapper {
+		deferredCall = fn
+	}
+}
+
+// claimDeferredCall is called at the start of each function that calls
+// recover(), with its own function pointer. It returns the panic record when
+// the function is the deferred call that the landing pad has just started, and
+// nil otherwise. A deferred call can only be claimed once, so that a function
+// called by the deferred function cannot claim it even if it is the same
+// function.
+func claimDeferredCall(fn uintptr) *panicRecord {
+	record := deferredCallRecord
+	if record == nil || deferredCall != fn {
+		return nil
+	}
+	deferredCall = 0
+	deferredCallRecord = nil
+	return record
+}
+
+// endUnwind is called from the landing pad after all deferred calls have run.
+// It returns true when the panic was recovered, in which case the function
+// returns normally. Otherwise, the panic continues unwinding the stack.
+func endUnwind(record *panicRecord) bool {
+	// The last deferred call may not have been claimed. The record is about
+	// to go out of scope.
+	deferredCall = 0
+	deferredCallRecord = nil
+	if record.recovered {
+		return true
+	}
+	panicking = true
+	panicValue = record.value
+	return false
+}
+
+// unrecoveredPanic is called when a panic reaches the top of the stack of a
+// goroutine without being recovered.
+func unrecoveredPanic() {
+	// Clear the flag, as the compiler-inserted checks after the calls below
+	// would otherwise return from this function instead of aborting.
+	panicking = false
+	printstring("panic: ")
+	if err, ok := panicValue.(*runtimeError); ok {
+		// Avoid a heap allocation in err.Error().
+		printstring("runtime error: ")
+		printstring(err.msg)
+	} else {
+		printitf(panicValue)
+	}
+	printnl()
+	printPanicStack()
+	abort()
+}
+
+// goroutineStarted is called right after starting a new goroutine. A
+// goroutine that panics before blocking returns to the function that started
+// it, which must not mistake the panic for its own.
+func goroutineStarted() {
+	if supportsRecover() && panicking {
+		unrecoveredPanic()
+	}
+}
+
+// Try to recover a panicking goroutine. Like in Go, this only works when
+// called directly by a deferred function that is run by the panic, not by a
+// function called from it or a function deferred by it. The compiler passes the
+// panic record that the calling function claimed when it was called, see
+// claimDeferredCall and compiler/panic.go.
+func _recover(record *panicRecord) interface{} {
+	if !supportsRecover() || record == nil || record.recovered {
+		return nil
+	}
+	record.recovered = true
+	return record.value
 }
 
 // See emitNilCheck in compiler/asserts.go.
@@ -50,6 +239,8 @@ func slicePanic() {
 	runtimePanic("slice out of range")
 }
 
+// Called from compiler-generated code that cannot unwind the stack, so this
+// panic cannot be recovered.
 func blockingPanic() {
-	runtimePanic("trying to do blocking operation in exported function")
+	runtimeFatal("trying to do blocking operation in exported function")
 }
@@ -19,7 +19,7 @@ func initAll()
 //
 // With scheduler:
 //
-//     main.main()
+//     runtime.runMain() // calls main.main() and sets mainExited
 //     scheduler()
 func callMain()
 
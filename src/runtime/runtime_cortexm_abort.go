@@ -0,0 +1,17 @@
+// +build cortexm,!qemu
+
+package runtime
+
+import (
+	"device/arm"
+)
+
+func abort() {
+	// disable all interrupts
+	arm.DisableInterrupts()
+
+	// lock up forever
+	for {
+		arm.Asm("wfi")
+	}
+}
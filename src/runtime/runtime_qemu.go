@@ -22,8 +22,36 @@ func main() {
 	preinit()
 	initAll()
 	callMain()
-	arm.SemihostingCall(arm.SemihostingReportException, arm.SemihostingApplicationExit)
-	abort()
+	exit(0)
+}
+
+// exit stops QEMU through semihosting. The reason reported to QEMU determines
+// its exit code: an application exit results in exit code 0 and any other
+// reason in exit code 1. That is why all non-zero exit codes are reported as
+// exit code 1.
+func exit(code int) {
+	reason := uintptr(arm.SemihostingApplicationExit)
+	if code != 0 {
+		reason = arm.SemihostingRunTimeErrorUnknown
+	}
+	arm.SemihostingCall(arm.SemihostingReportException, reason)
+	// Semihosting is not available (for example, the program isn't running
+	// in QEMU). Lock up forever.
+	arm.DisableInterrupts()
+	for {
+		arm.Asm("wfi")
+	}
+}
+
+//go:linkname syscall_Exit syscall.Exit
+func syscall_Exit(code int) {
+	exit(code)
+}
+
+// abort stops QEMU with a non-zero exit code, so that a panic in a test makes
+// the test fail instead of hanging QEMU.
+func abort() {
+	exit(1)
 }
 
 const asyncScheduler = false
@@ -24,13 +24,62 @@ func exit(code int)
 //go:export clock_gettime
 func clock_gettime(clk_id uint, ts *timespec)
 
+//go:export mmap
+func mmap(addr unsafe.Pointer, length uintptr, prot, flags, fd int32, offset int) unsafe.Pointer
+
+//go:export mprotect
+func mprotect(addr unsafe.Pointer, length uintptr, prot int32) int32
+
 const heapSize = 1 * 1024 * 1024 // 1MB to start
 
+// heapMaxSize is the amount of address space reserved for the heap: 256MB on
+// 32-bit and 1GB on 64-bit systems. Only the part up to heapEnd is actually
+// usable.
+const heapMaxSize = 1 << (TargetBits/16 + 26)
+
+const (
+	PROT_NONE  = 0
+	PROT_READ  = 1
+	PROT_WRITE = 2
+
+	MAP_PRIVATE = 2
+)
+
 var (
-	heapStart = uintptr(malloc(heapSize))
-	heapEnd   = heapStart + heapSize
+	heapStart  = reserveHeap()
+	heapEnd    = heapStart + heapSize
+	heapMaxEnd uintptr // end of the reserved address space, or 0 if the heap cannot grow
 )
 
+// reserveHeap reserves address space for the heap to grow into, of which only
+// the first heapSize bytes are made accessible. If that is not possible, it
+// falls back to a fixed size heap allocated with malloc.
+func reserveHeap() uintptr {
+	addr := mmap(nil, heapMaxSize, PROT_NONE, MAP_PRIVATE|MAP_ANONYMOUS|MAP_NORESERVE, -1, 0)
+	if uintptr(addr) == ^uintptr(0) { // MAP_FAILED
+		return uintptr(malloc(heapSize))
+	}
+	if mprotect(addr, heapSize, PROT_READ|PROT_WRITE) != 0 {
+		return uintptr(malloc(heapSize))
+	}
+	heapMaxEnd = uintptr(addr) + heapMaxSize
+	return uintptr(addr)
+}
+
+// growHeapMemory doubles the usable part of the heap, as long as it fits in the
+// reserved address space. It returns false when the heap could not be grown.
+func growHeapMemory() bool {
+	newHeapEnd := heapStart + (heapEnd-heapStart)*2
+	if newHeapEnd > heapMaxEnd {
+		return false
+	}
+	if mprotect(unsafe.Pointer(heapEnd), newHeapEnd-heapEnd, PROT_READ|PROT_WRITE) != 0 {
+		return false
+	}
+	heapEnd = newHeapEnd
+	return true
+}
+
 type timeUnit int64
 
 const tickMicros = 1
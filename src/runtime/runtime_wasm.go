@@ -64,6 +64,18 @@ func sleepTicks(d timeUnit)
 //go:export runtime.ticks
 func ticks() timeUnit
 
+// exit is implemented in JavaScript. It stops the program with the given exit
+// code, for example by exiting Node.js.
+//go:export runtime.exit
+func exit(code int32)
+
+//go:linkname syscall_Exit syscall.Exit
+func syscall_Exit(code int) {
+	exit(int32(code))
+	// The environment didn't stop the program, make sure it doesn't continue.
+	abort()
+}
+
 // Abort executes the wasm 'unreachable' instruction.
 func abort() {
 	trap()
@@ -73,6 +73,10 @@ var (
 	sleepQueueBaseTime timeUnit
 )
 
+// mainExited is set by the compiler when main.main has returned. From then on,
+// active timers no longer keep the program running.
+var mainExited bool
+
 // Simple logging, for debugging.
 func scheduleLog(msg string) {
 	if schedulerDebug {
@@ -107,6 +111,13 @@ func activateTask(task *coroutine) {
 	if task == nil {
 		return
 	}
+	if supportsRecover() && panicking {
+		// The task returned while panicking. The parent must continue
+		// unwinding the stack before any other goroutine runs, so don't put it
+		// at the end of the runqueue but let the scheduler resume it directly.
+		panicParent = task
+		return
+	}
 	scheduleLogTask("  set runnable:", task)
 	runqueuePushBack(task)
 }
@@ -228,9 +239,26 @@ func addSleepTask(t *coroutine) {
 func scheduler() {
 	// Main scheduler loop.
 	for {
+		if supportsRecover() && panicking {
+			// A blocking function panicked. Continue unwinding in the
+			// coroutine that called it, or abort when the panic reached the
+			// top of the goroutine.
+			t := panicParent
+			if t == nil {
+				unrecoveredPanic()
+			}
+			panicParent = nil
+			t.resume()
+			continue
+		}
+
 		scheduleLog("\n  schedule")
 		now := ticks()
 
+		// Run the callbacks of all timers that have expired. They may make
+		// other tasks runnable, for example by sending on a channel.
+		runTimers(now)
+
 		// Add tasks that are done sleeping to the end of the runqueue so they
 		// will be executed soon.
 		if sleepQueue != nil && now-sleepQueueBaseTime >= timeUnit(sleepQueue.promise().data) {
@@ -245,15 +273,26 @@ func scheduler() {
 
 		t := runqueuePopFront()
 		if t == nil {
-			if sleepQueue == nil {
-				// No more tasks to execute.
+			if sleepQueue == nil && (timerQueue == nil || mainExited) {
+				// No more tasks to execute. Timers only need to be run while
+				// main.main is running, like in Go.
 				// It would be nice if we could detect deadlocks here, because
 				// there might still be functions waiting on each other in a
 				// deadlock.
 				scheduleLog("  no tasks left!")
 				return
 			}
-			timeLeft := timeUnit(sleepQueue.promise().data) - (now - sleepQueueBaseTime)
+			// Sleep until the next task wakes up or the next timer expires,
+			// whichever comes first.
+			var timeLeft timeUnit
+			if sleepQueue != nil {
+				timeLeft = timeUnit(sleepQueue.promise().data) - (now - sleepQueueBaseTime)
+			}
+			if timerQueue != nil {
+				if timerLeft := timerTicksLeft(now); sleepQueue == nil || timerLeft < timeLeft {
+					timeLeft = timerLeft
+				}
+			}
 			if schedulerDebug {
 				println("  sleeping...", sleepQueue, uint(timeLeft))
 			}
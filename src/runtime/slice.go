@@ -7,8 +7,9 @@ import (
 )
 
 // Builtin append(src, elements...) function: append elements to src and return
-// the modified (possibly expanded) slice.
-func sliceAppend(srcBuf, elemsBuf unsafe.Pointer, srcLen, srcCap, elemsLen uintptr, elemSize uintptr) (unsafe.Pointer, uintptr, uintptr) {
+// the modified (possibly expanded) slice. The elemLayout is the object layout of
+// the element type, used when a new buffer must be allocated.
+func sliceAppend(srcBuf, elemsBuf unsafe.Pointer, srcLen, srcCap, elemsLen uintptr, elemSize uintptr, elemLayout uintptr) (unsafe.Pointer, uintptr, uintptr) {
 	if elemsLen == 0 {
 		// Nothing to append, return the input slice.
 		return srcBuf, srcLen, srcCap
@@ -27,7 +28,7 @@ func sliceAppend(srcBuf, elemsBuf unsafe.Pointer, srcLen, srcCap, elemsLen uintp
 			// programs).
 			srcCap *= 2
 		}
-		buf := alloc(srcCap * elemSize)
+		buf := alloc(srcCap*elemSize, elemLayout)
 
 		// Copy the old slice to the new slice.
 		if srcLen != 0 {
@@ -1,16 +1,228 @@
 package runtime
 
+// This file implements runtime.Caller and related functions using a symbol
+// table created by the compiler (see compiler/symtab.go). The symbol table has
+// an entry for each function with debug information, containing its address,
+// size, name, and the position of its declaration. A separate line table has
+// the position of every call in these functions, which is used to report the
+// line of a call. Functions that were inlined do not show up as separate
+// frames, but the line reported for their caller is the line in the inlined
+// function.
+//
+// The stack is walked using frame pointers, see callerFrame. When the symbol
+// table is disabled (with -no-symtab or -no-debug, on bare metal targets unless
+// -symtab is passed, or on targets where the stack cannot be walked), these
+// functions report nothing.
+
+import (
+	"unsafe"
+)
+
+// Func describes a single function in the symbol table. The layout must match
+// the entries created by the compiler.
 type Func struct {
+	entry uintptr
+	size  uintptr // 0 if unknown
+	name  string
+	file  string
+	line  int
+}
+
+// lineEntry is an entry in the line table, describing the call just before
+// the given address. The layout must match the entries created by the
+// compiler.
+type lineEntry struct {
+	pc   int32 // relative to the address of this field, unless lineTableAbsolute
+	line int32
+	file int32 // index in symtabFiles
 }
 
+// hasSymtab returns whether the compiler created a symbol table for this
+// program. It is replaced with a constant by the compiler.
+func hasSymtab() bool
+
+//go:extern runtime.symtab
+var symtab [0]Func
+
+//go:extern runtime.symtabLength
+var symtabLength uintptr
+
+//go:extern runtime.symtabFiles
+var symtabFiles [0]string
+
+//go:extern __start_tinygo_lines
+var lineTableStart [0]lineEntry
+
+//go:extern __stop_tinygo_lines
+var lineTableEnd [0]lineEntry
+
+//go:export llvm.frameaddress
+func frameAddress(level int32) unsafe.Pointer
+
+// FuncForPC returns a *Func describing the function that contains the given
+// program counter address, or else nil.
 func FuncForPC(pc uintptr) *Func {
-	return nil
+	if !hasSymtab() {
+		return nil
+	}
+	var f *Func
+	for i := uintptr(0); i < symtabLength; i++ {
+		entry := (*Func)(unsafe.Pointer(uintptr(unsafe.Pointer(&symtab)) + i*unsafe.Sizeof(Func{})))
+		if entry.entry > pc {
+			continue
+		}
+		if entry.size != 0 {
+			if pc < entry.entry+entry.size {
+				return entry
+			}
+			continue
+		}
+		// The size of this function is unknown, so use the closest function
+		// before pc.
+		if f == nil || entry.entry > f.entry {
+			f = entry
+		}
+	}
+	return f
 }
 
+// Name returns the name of the function.
 func (f *Func) Name() string {
-	return ""
+	if f == nil {
+		return ""
+	}
+	return f.name
+}
+
+// Entry returns the entry address of the function.
+func (f *Func) Entry() uintptr {
+	return f.entry
+}
+
+// FileLine returns the file name and line number of the call at the given
+// program counter in the function. If the call is not in the line table, the
+// position of the function declaration is returned.
+func (f *Func) FileLine(pc uintptr) (file string, line int) {
+	if f.size == 0 {
+		return f.file, f.line
+	}
+	// The line table entry of a call is the first one at or after the call
+	// instruction. Only consider entries within this function.
+	var call *lineEntry
+	var callAddress uintptr
+	for p := uintptr(unsafe.Pointer(&lineTableStart)); p < uintptr(unsafe.Pointer(&lineTableEnd)); p += unsafe.Sizeof(lineEntry{}) {
+		entry := (*lineEntry)(unsafe.Pointer(p))
+		address := uintptr(uint32(entry.pc))
+		if !lineTableAbsolute {
+			address = p + uintptr(entry.pc)
+		}
+		if address >= pc && address < f.entry+f.size && (call == nil || address < callAddress) {
+			call = entry
+			callAddress = address
+		}
+	}
+	if call == nil {
+		return f.file, f.line
+	}
+	return *(*string)(unsafe.Pointer(uintptr(unsafe.Pointer(&symtabFiles)) + uintptr(call.file)*unsafe.Sizeof(""))), int(call.line)
 }
 
+// Caller reports file and line number information about function invocations
+// on the calling goroutine's stack. The argument skip is the number of stack
+// frames to ascend, with 0 identifying the caller of Caller.
+//go:noinline
 func Caller(skip int) (pc uintptr, file string, line int, ok bool) {
-	return 0, "", 0, false
+	var pcs [1]uintptr
+	if Callers(skip+2, pcs[:]) == 0 {
+		return 0, "", 0, false
+	}
+	f := FuncForPC(pcs[0] - 1)
+	if f == nil {
+		return 0, "", 0, false
+	}
+	file, line = f.FileLine(pcs[0] - 1)
+	return pcs[0], file, line, true
+}
+
+// Callers fills the slice pc with the return program counters of function
+// invocations on the calling goroutine's stack. The argument skip is the
+// number of stack frames to skip before recording in pc, with 1 identifying
+// the caller of Callers. The frame of Callers itself is never recorded. It
+// returns the number of entries written to pc.
+//go:noinline
+func Callers(skip int, pc []uintptr) int {
+	if !hasSymtab() {
+		return 0
+	}
+	return callers(uintptr(frameAddress(0)), skip-1, pc)
+}
+
+// callers walks the stack starting at the given frame pointer, skips the
+// given number of frames, and stores the return addresses of the remaining
+// frames in pcs.
+func callers(fp uintptr, skip int, pcs []uintptr) int {
+	n := 0
+	for fp != 0 && n < len(pcs) {
+		pc, next := callerFrame(fp)
+		if pc == 0 {
+			break
+		}
+		if skip > 0 {
+			skip--
+		} else {
+			pcs[n] = pc
+			n++
+		}
+		if next <= fp {
+			// The stack grows down, so this is either the end of the stack or
+			// a corrupted frame pointer.
+			break
+		}
+		fp = next
+	}
+	return n
+}
+
+var (
+	// panicStack holds the stack at the time of the last panic, so that it
+	// can be printed when the panic is not recovered.
+	panicStack    [16]uintptr
+	panicStackLen int
+)
+
+// savePanicStack stores the current stack in panicStack.
+//go:noinline
+func savePanicStack() {
+	if !hasSymtab() {
+		return
+	}
+	panicStackLen = callers(uintptr(frameAddress(0)), 0, panicStack[:])
+}
+
+// printPanicStack prints the stack saved by savePanicStack, in a format
+// similar to the one used by the Go toolchain. Frames in the runtime at the
+// top of the stack (such as the panic functions themselves) are left out.
+func printPanicStack() {
+	if !hasSymtab() || panicStackLen == 0 {
+		return
+	}
+	printstring("\ngoroutine [running]:\n")
+	top := true
+	for _, pc := range panicStack[:panicStackLen] {
+		f := FuncForPC(pc - 1)
+		if f == nil {
+			continue
+		}
+		if top && len(f.name) > 8 && f.name[:8] == "runtime." {
+			continue
+		}
+		top = false
+		file, line := f.FileLine(pc - 1)
+		printstring(f.name)
+		printstring("()\n\t")
+		printstring(file)
+		printstring(":")
+		printint32(int32(line))
+		printnl()
+	}
 }
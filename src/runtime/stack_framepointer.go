@@ -0,0 +1,21 @@
+// +build !avr,!wasm,!tinygo.riscv
+
+package runtime
+
+import (
+	"unsafe"
+)
+
+// lineTableAbsolute is false, as the addresses in the line table are relative
+// to the line table entry (see compiler/symtab.go).
+const lineTableAbsolute = false
+
+// callerFrame returns the return address and the frame pointer of the caller
+// of the function with the given frame pointer. On x86, ARM and AArch64, the
+// frame pointer points to the saved frame pointer of the caller, which is
+// directly followed by the return address.
+func callerFrame(fp uintptr) (pc, next uintptr) {
+	pc = *(*uintptr)(unsafe.Pointer(fp + unsafe.Sizeof(uintptr(0))))
+	next = *(*uintptr)(unsafe.Pointer(fp))
+	return
+}
@@ -0,0 +1,13 @@
+// +build avr wasm
+
+package runtime
+
+// lineTableAbsolute is unused, as there is no line table on this architecture.
+const lineTableAbsolute = false
+
+// callerFrame is not supported on this architecture, as there are no frame
+// pointers to walk the stack. The compiler never creates a symbol table for
+// it, so this function is never called.
+func callerFrame(fp uintptr) (pc, next uintptr) {
+	return 0, 0
+}
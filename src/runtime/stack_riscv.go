@@ -0,0 +1,21 @@
+// +build tinygo.riscv
+
+package runtime
+
+import (
+	"unsafe"
+)
+
+// lineTableAbsolute is true, as the line table contains absolute addresses on
+// RISC-V (see compiler/symtab.go).
+const lineTableAbsolute = true
+
+// callerFrame returns the return address and the frame pointer of the caller
+// of the function with the given frame pointer. On RISC-V, the frame pointer
+// points just past the saved return address, which is preceded by the saved
+// frame pointer of the caller.
+func callerFrame(fp uintptr) (pc, next uintptr) {
+	pc = *(*uintptr)(unsafe.Pointer(fp - unsafe.Sizeof(uintptr(0))))
+	next = *(*uintptr)(unsafe.Pointer(fp - 2*unsafe.Sizeof(uintptr(0))))
+	return
+}
@@ -1,13 +1,109 @@
 package runtime
 
-// This file contains stub implementations for internal/poll.
+// This file implements semaphores for the sync and internal/poll packages. A
+// task that blocks on a semaphore is parked until the semaphore is released by
+// another task.
+
+import (
+	"unsafe"
+)
+
+// Tasks blocked in semacquire, in FIFO order. They are linked through the next
+// field of their promise, and the ptr field of the promise contains the
+// semaphore they are waiting on.
+var semaWaitersFront, semaWaitersBack *coroutine
+
+// parkTask is a compiler intrinsic that suspends the current task until it is
+// activated again by another task (using activateTask). Unlike a sleeping task,
+// the task is not put in any queue: the caller must do so beforehand.
+func parkTask()
+
+// deadlock is called when a task is about to be parked while there is no
+// scheduler. In that case there are no other goroutines that could wake it up.
+func deadlock() {
+	runtimeFatal("all goroutines are asleep - deadlock!")
+}
 
 //go:linkname semacquire internal/poll.runtime_Semacquire
 func semacquire(sema *uint32) {
-	panic("todo: semacquire")
+	semaAcquire(sema)
 }
 
 //go:linkname semrelease internal/poll.runtime_Semrelease
 func semrelease(sema *uint32) {
-	panic("todo: semrelease")
+	semaRelease(sema)
+}
+
+//go:linkname syncSemacquire sync.runtime_Semacquire
+func syncSemacquire(sema *uint32) {
+	semaAcquire(sema)
+}
+
+//go:linkname syncSemrelease sync.runtime_Semrelease
+func syncSemrelease(sema *uint32) {
+	semaRelease(sema)
+}
+
+// semaAcquire waits until *sema is greater than zero and then decrements it.
+func semaAcquire(sema *uint32) {
+	if *sema != 0 {
+		*sema--
+		return
+	}
+
+	// Wait until semaRelease hands the semaphore over to this task.
+	t := getCoroutine()
+	if t == nil {
+		// There is no scheduler (see lowerParkTaskWithoutScheduler in the
+		// compiler), so no other task can release the semaphore.
+		deadlock()
+	}
+	promise := t.promise()
+	promise.ptr = unsafe.Pointer(sema)
+	promise.next = nil
+	if semaWaitersBack == nil {
+		semaWaitersFront = t
+	} else {
+		semaWaitersBack.promise().next = t
+	}
+	semaWaitersBack = t
+	scheduleLogTask("  park on semaphore:", t)
+	parkTask()
+}
+
+// semaRelease increments *sema. If a task is waiting on this semaphore, it is
+// woken up and the semaphore is handed over to it directly, so that no other
+// task can acquire it in the meantime.
+func semaRelease(sema *uint32) {
+	var prev *coroutine
+	for t := semaWaitersFront; t != nil; t = t.promise().next {
+		promise := t.promise()
+		if promise.ptr != unsafe.Pointer(sema) {
+			prev = t
+			continue
+		}
+
+		// Remove this task from the list of waiters.
+		if prev == nil {
+			semaWaitersFront = promise.next
+		} else {
+			prev.promise().next = promise.next
+		}
+		if semaWaitersBack == t {
+			semaWaitersBack = prev
+		}
+		promise.next = nil
+		activateTask(t)
+		return
+	}
+	*sema++
+}
+
+// poolCleanup clears all sync.Pool instances. It is called by the garbage
+// collector at the start of a collection cycle, if set.
+var poolCleanup func()
+
+//go:linkname registerPoolCleanup sync.runtime_registerPoolCleanup
+func registerPoolCleanup(cleanup func()) {
+	poolCleanup = cleanup
 }
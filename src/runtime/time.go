@@ -0,0 +1,100 @@
+package runtime
+
+// This file implements the timers used by time.Timer, time.Ticker, time.After
+// and time.AfterFunc. Active timers are kept in a queue sorted by expiry time,
+// next to the sleep queue of the scheduler. The scheduler runs expired timers
+// and takes the next timer into account when deciding how long to sleep.
+//
+// When a timer expires, its callback is called from the scheduler. For the
+// timers of the time package, this callback either sends the current time on
+// the (buffered) timer channel without blocking, or starts a new goroutine.
+// Like sleeping goroutines, active timers keep the scheduler running.
+
+// timer is the runtime representation of a timer. The layout must match
+// runtimeTimer in the time package.
+type timer struct {
+	tb uintptr
+	i  int
+
+	when   int64 // expiry time, in nanoseconds (see nanotime)
+	period int64 // interval of a ticker, or 0 for a one-shot timer
+	f      func(interface{}, uintptr)
+	arg    interface{}
+	seq    uintptr
+}
+
+// timerNode is an element in the timer queue. The timer itself is owned by the
+// time package, so it is not used to link the queue.
+type timerNode struct {
+	next  *timerNode
+	timer *timer
+}
+
+// Active timers, sorted by expiry time.
+var timerQueue *timerNode
+
+//go:linkname time_runtimeNano time.runtimeNano
+func time_runtimeNano() int64 {
+	return nanotime()
+}
+
+// startTimer adds the timer to the timer queue.
+//go:linkname startTimer time.startTimer
+func startTimer(t *timer) {
+	addTimer(&timerNode{timer: t})
+}
+
+// stopTimer removes the timer from the timer queue. It returns whether the
+// timer was still active.
+//go:linkname stopTimer time.stopTimer
+func stopTimer(t *timer) bool {
+	for q := &timerQueue; *q != nil; q = &(*q).next {
+		if (*q).timer == t {
+			*q = (*q).next
+			return true
+		}
+	}
+	return false
+}
+
+// addTimer inserts the node in the timer queue, after all timers that expire
+// at the same time or earlier.
+func addTimer(node *timerNode) {
+	q := &timerQueue
+	for *q != nil && (*q).timer.when <= node.timer.when {
+		q = &(*q).next
+	}
+	node.next = *q
+	*q = node
+}
+
+// runTimers calls the callbacks of all timers that have expired at the given
+// time. Tickers are added back to the queue for their next expiry time.
+func runTimers(now timeUnit) {
+	nowNanos := int64(now) * tickMicros
+	for timerQueue != nil && timerQueue.timer.when <= nowNanos {
+		node := timerQueue
+		timerQueue = node.next
+		node.next = nil
+		t := node.timer
+		if t.period > 0 {
+			// Skip ticks that were missed entirely, like the Go runtime does.
+			t.when += t.period * (1 + (nowNanos-t.when)/t.period)
+			addTimer(node)
+		}
+		if schedulerDebug {
+			println("  run timer:", t)
+		}
+		t.f(t.arg, t.seq)
+	}
+}
+
+// timerTicksLeft returns the number of ticks until the first timer in the
+// queue expires. The timer queue must not be empty.
+func timerTicksLeft(now timeUnit) timeUnit {
+	when := timeUnit((timerQueue.timer.when + tickMicros - 1) / tickMicros)
+	if when < now {
+		return 0
+	}
+	return when - now
+}
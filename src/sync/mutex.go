@@ -1,15 +1,30 @@
 package sync
 
-// These mutexes assume there is only one thread of operation: no goroutines,
-// interrupts or anything else.
+// These mutexes are implemented on top of the semaphores in the runtime. A
+// goroutine that tries to lock a mutex that is already locked is parked by the
+// scheduler until the mutex is handed over to it on unlock.
+//
+// There is no preemption, so no atomic operations are needed: a goroutine can
+// only be switched out while it is blocked.
+
+// A Locker represents an object that can be locked and unlocked.
+type Locker interface {
+	Lock()
+	Unlock()
+}
 
 type Mutex struct {
-	locked bool
+	locked  bool
+	waiters uint32 // number of goroutines waiting in Lock
+	sema    uint32
 }
 
 func (m *Mutex) Lock() {
 	if m.locked {
-		panic("todo: block on locked mutex")
+		// Wait until Unlock hands over the lock.
+		m.waiters++
+		runtime_Semacquire(&m.sema)
+		return
 	}
 	m.locked = true
 }
@@ -18,35 +33,94 @@ func (m *Mutex) Unlock() {
 	if !m.locked {
 		panic("sync: unlock of unlocked Mutex")
 	}
+	if m.waiters != 0 {
+		// Hand over the lock to the next goroutine. The mutex stays locked.
+		m.waiters--
+		runtime_Semrelease(&m.sema)
+		return
+	}
 	m.locked = false
 }
 
+// An RWMutex can be held by an arbitrary number of readers or a single writer.
+// Waiting writers are preferred over new readers, so that writers cannot be
+// starved.
 type RWMutex struct {
-	m       Mutex
-	readers uint32
+	writer        bool  // locked by a writer
+	readers       int32 // number of active readers
+	writerWaiters uint32
+	readerWaiters uint32
+	writerSema    uint32
+	readerSema    uint32
 }
 
 func (rw *RWMutex) Lock() {
-	rw.m.Lock()
+	if rw.writer || rw.readers != 0 {
+		// Wait until the lock is handed over.
+		rw.writerWaiters++
+		runtime_Semacquire(&rw.writerSema)
+		return
+	}
+	rw.writer = true
 }
 
 func (rw *RWMutex) Unlock() {
-	rw.m.Unlock()
+	if !rw.writer {
+		panic("sync: unlock of unlocked RWMutex")
+	}
+	if rw.readerWaiters != 0 {
+		// Let all waiting readers in at once.
+		rw.writer = false
+		rw.readers += int32(rw.readerWaiters)
+		for ; rw.readerWaiters != 0; rw.readerWaiters-- {
+			runtime_Semrelease(&rw.readerSema)
+		}
+		return
+	}
+	if rw.writerWaiters != 0 {
+		// Hand over the lock to the next writer.
+		rw.writerWaiters--
+		runtime_Semrelease(&rw.writerSema)
+		return
+	}
+	rw.writer = false
 }
 
 func (rw *RWMutex) RLock() {
-	if rw.readers == 0 {
-		rw.m.Lock()
+	if rw.writer || rw.writerWaiters != 0 {
+		// Wait until the writer unlocks. The reader count is incremented by
+		// Unlock.
+		rw.readerWaiters++
+		runtime_Semacquire(&rw.readerSema)
+		return
 	}
 	rw.readers++
 }
 
 func (rw *RWMutex) RUnlock() {
-	if rw.readers == 0 {
+	if rw.readers <= 0 {
 		panic("sync: unlock of unlocked RWMutex")
 	}
 	rw.readers--
-	if rw.readers == 0 {
-		rw.m.Unlock()
+	if rw.readers == 0 && rw.writerWaiters != 0 {
+		// The last reader is gone, hand over the lock to a writer.
+		rw.writer = true
+		rw.writerWaiters--
+		runtime_Semrelease(&rw.writerSema)
 	}
 }
+
+// RLocker returns a Locker interface that implements the Lock and Unlock
+// methods by calling rw.RLock and rw.RUnlock.
+func (rw *RWMutex) RLocker() Locker {
+	return (*rlocker)(rw)
+}
+
+type rlocker RWMutex
+
+func (r *rlocker) Lock()   { (*RWMutex)(r).RLock() }
+func (r *rlocker) Unlock() { (*RWMutex)(r).RUnlock() }
+
+// Implemented in the runtime, see src/runtime/sync.go.
+func runtime_Semacquire(sema *uint32)
+func runtime_Semrelease(sema *uint32)
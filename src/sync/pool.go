@@ -1,16 +1,68 @@
 package sync
 
-// Pool is a very simple implementation of sync.Pool. It does not actually
-// implement a pool.
+// Pool is a set of temporary objects that can be reused to avoid allocations.
+//
+// Pooled objects are only kept until the next garbage collection cycle: the
+// garbage collector clears all pools before it starts marking, so that a pool
+// never keeps memory alive that would otherwise be freed.
 type Pool struct {
 	New func() interface{}
+
+	items      []interface{}
+	registered bool // whether the pool is in allPools
+}
+
+// Pools that may contain items, so that they can be cleared on the next GC.
+var allPools []*Pool
+
+func init() {
+	runtime_registerPoolCleanup(poolCleanup)
 }
 
-// Get returns the value of calling Pool.New().
+// Get returns an arbitrary item from the pool and removes it from the pool. If
+// the pool is empty, it returns the result of calling p.New, or nil if p.New is
+// not set.
 func (p *Pool) Get() interface{} {
-	return p.New()
+	if len(p.items) == 0 {
+		if p.New == nil {
+			return nil
+		}
+		return p.New()
+	}
+	x := p.items[len(p.items)-1]
+	p.items[len(p.items)-1] = nil
+	p.items = p.items[:len(p.items)-1]
+	return x
 }
 
-// Put drops the value put into the pool.
+// Put adds x to the pool.
 func (p *Pool) Put(x interface{}) {
+	if x == nil {
+		return
+	}
+	p.items = append(p.items, x)
+	// The pool is registered after adding the item, as a GC triggered by
+	// either allocation clears the pool and resets its registration.
+	if !p.registered {
+		p.registered = true
+		allPools = append(allPools, p)
+	}
 }
+
+// poolCleanup is called by the garbage collector at the start of a collection
+// cycle. It must not allocate.
+func poolCleanup() {
+	for i, p := range allPools {
+		// Entries are nil when a GC during the append in Put cleared the
+		// pools that were copied to the new slice.
+		if p != nil {
+			p.items = nil
+			p.registered = false
+		}
+		allPools[i] = nil
+	}
+	allPools = nil
+}
+
+// Implemented in the runtime.
+func runtime_registerPoolCleanup(cleanup func())
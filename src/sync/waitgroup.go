@@ -0,0 +1,33 @@
+package sync
+
+// A WaitGroup waits for a collection of goroutines to finish.
+type WaitGroup struct {
+	counter int32
+	waiters uint32 // number of goroutines waiting in Wait
+	sema    uint32
+}
+
+func (wg *WaitGroup) Add(delta int) {
+	wg.counter += int32(delta)
+	if wg.counter < 0 {
+		panic("sync: negative WaitGroup counter")
+	}
+	if wg.counter == 0 {
+		// Wake up all waiting goroutines.
+		for ; wg.waiters != 0; wg.waiters-- {
+			runtime_Semrelease(&wg.sema)
+		}
+	}
+}
+
+func (wg *WaitGroup) Done() {
+	wg.Add(-1)
+}
+
+func (wg *WaitGroup) Wait() {
+	if wg.counter == 0 {
+		return
+	}
+	wg.waiters++
+	runtime_Semacquire(&wg.sema)
+}
@@ -0,0 +1,407 @@
+package testing
+
+// This file implements benchmarks, which are run with `tinygo test -bench`. It
+// is based on benchmark.go of the upstream testing package, with a few
+// differences:
+//   * Benchmarks always run sequentially, there is no RunParallel.
+//   * Allocations are counted with the counters of the TinyGo memory
+//     allocator (see runtime.ReadMemStats), so they include all allocations
+//     that are done while the timer runs.
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BenchmarkToCall is a reference to a benchmark that should be called during a
+// test suite run.
+type BenchmarkToCall struct {
+	// Name of the benchmark to call.
+	Name string
+	// Function reference to the benchmark.
+	Func func(*B)
+}
+
+// benchTimeFlag is the parsed value of the -benchtime flag: either a duration
+// or a fixed number of iterations.
+type benchTimeFlag struct {
+	d time.Duration
+	n int
+}
+
+// benchTime is the time (or number of iterations) each benchmark runs.
+var benchTime = benchTimeFlag{d: 1 * time.Second}
+
+// parseBenchTime parses the value of the -benchtime flag, like "2s" or "100x".
+func parseBenchTime(s string) (benchTimeFlag, error) {
+	if strings.HasSuffix(s, "x") {
+		n, err := strconv.ParseInt(s[:len(s)-1], 10, 0)
+		if err != nil || n <= 0 {
+			return benchTimeFlag{}, fmt.Errorf("invalid count")
+		}
+		return benchTimeFlag{n: int(n)}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return benchTimeFlag{}, fmt.Errorf("invalid duration")
+	}
+	return benchTimeFlag{d: d}, nil
+}
+
+// memStats is used to read the allocation counters without allocating.
+var memStats runtime.MemStats
+
+// B is a type passed to Benchmark functions to manage benchmark timing and to
+// specify the number of iterations to run.
+type B struct {
+	common
+	context   *benchContext
+	N         int
+	benchFunc func(b *B)
+	benchTime benchTimeFlag
+	hasSub    bool // the benchmark called Run
+	timerOn   bool
+	result    BenchmarkResult
+
+	showAllocResult bool
+
+	// The allocation counters when the timer was started.
+	startAllocs uint64
+	startBytes  uint64
+
+	// The allocations done while the timer was running.
+	netAllocs uint64
+	netBytes  uint64
+}
+
+// benchContext holds all fields that are common to all benchmarks.
+type benchContext struct {
+	match  *matcher
+	maxLen int // the length of the longest benchmark name, to align the results
+}
+
+// StartTimer starts timing a test. This function is called automatically
+// before a benchmark starts, but it can also be used to resume timing after a
+// call to StopTimer.
+func (b *B) StartTimer() {
+	if !b.timerOn {
+		runtime.ReadMemStats(&memStats)
+		b.startAllocs = memStats.Mallocs
+		b.startBytes = memStats.TotalAlloc
+		b.start = time.Now()
+		b.timerOn = true
+	}
+}
+
+// StopTimer stops timing a test. This can be used to pause the timer while
+// performing complex initialization that you don't want to measure.
+func (b *B) StopTimer() {
+	if b.timerOn {
+		b.duration += time.Since(b.start)
+		runtime.ReadMemStats(&memStats)
+		b.netAllocs += memStats.Mallocs - b.startAllocs
+		b.netBytes += memStats.TotalAlloc - b.startBytes
+		b.timerOn = false
+	}
+}
+
+// ResetTimer zeroes the elapsed benchmark time and memory allocation counters
+// and deletes user-reported metrics. It does not affect whether the timer is
+// running.
+func (b *B) ResetTimer() {
+	if b.timerOn {
+		runtime.ReadMemStats(&memStats)
+		b.startAllocs = memStats.Mallocs
+		b.startBytes = memStats.TotalAlloc
+		b.start = time.Now()
+	}
+	b.duration = 0
+	b.netAllocs = 0
+	b.netBytes = 0
+}
+
+// ReportAllocs enables malloc statistics for this benchmark.
+func (b *B) ReportAllocs() {
+	b.showAllocResult = true
+}
+
+// runN runs a single benchmark for the specified number of iterations.
+func (b *B) runN(n int) {
+	defer func() {
+		b.StopTimer()
+		if r := recover(); r != nil {
+			if _, ok := r.(testExit); !ok {
+				if !b.finished {
+					b.reportPanic()
+				}
+				panic(r)
+			}
+		}
+	}()
+	b.N = n
+	b.output = b.output[:0]
+	b.ResetTimer()
+	b.StartTimer()
+	b.benchFunc(b)
+}
+
+// run1 runs the first iteration of the benchmark. It reports whether the
+// benchmark should be run for real: not when it failed, stopped early or only
+// runs sub-benchmarks.
+func (b *B) run1() bool {
+	b.runN(1)
+	if b.failed {
+		b.report()
+		return false
+	}
+	if b.hasSub || b.finished {
+		tag := "BENCH"
+		if b.skipped {
+			tag = "SKIP"
+		}
+		if b.chatty && (len(b.output) > 0 || b.finished) {
+			fmt.Printf("--- %s: %s\n%s", tag, b.name, b.output)
+		}
+		return false
+	}
+	return true
+}
+
+// run runs the benchmark and prints the result line. Without a context (when
+// called from Benchmark) the result is only stored.
+func (b *B) run() {
+	if b.context == nil {
+		b.launch()
+		return
+	}
+	fmt.Printf("%-*s\t", b.context.maxLen, b.name)
+	b.launch()
+	if b.failed {
+		b.report()
+		return
+	}
+	results := b.result.String()
+	if b.showAllocResult {
+		results += "\t" + b.result.MemString()
+	}
+	fmt.Println(results)
+	// Unlike with tests, the output is always printed, because printing it
+	// while the benchmark runs would skew the results.
+	if len(b.output) > 0 {
+		fmt.Printf("--- BENCH: %s\n%s", b.name, b.output)
+	}
+}
+
+// launch runs the benchmark with increasing values of b.N until it has run for
+// the requested time, or runs it once with a fixed b.N.
+func (b *B) launch() {
+	if b.benchTime.n > 0 {
+		b.runN(b.benchTime.n)
+	} else {
+		d := b.benchTime.d
+		for n := int64(1); !b.failed && !b.finished && b.duration < d && n < 1e9; {
+			last := n
+			// Predict required iterations.
+			goalns := d.Nanoseconds()
+			prevIters := int64(b.N)
+			prevns := b.duration.Nanoseconds()
+			if prevns <= 0 {
+				// Round up, to avoid div by zero.
+				prevns = 1
+			}
+			// Order of operations matters.
+			// For very fast benchmarks, prevIters ~= prevns.
+			// If you divide first, you get 0 or 1,
+			// which can hide an order of magnitude in execution time.
+			// So multiply first, then divide.
+			n = goalns * prevIters / prevns
+			// Run more iterations than we think we'll need (1.2x).
+			n += n / 5
+			// Don't grow too fast in case we had timing errors previously.
+			if n > 100*last {
+				n = 100 * last
+			}
+			// Be sure to run at least one more than last time.
+			if n <= last {
+				n = last + 1
+			}
+			// Don't run more than 1e9 times. (This also keeps n in int range on 32 bit platforms.)
+			if n > 1e9 {
+				n = 1e9
+			}
+			b.runN(int(n))
+		}
+	}
+	b.result = BenchmarkResult{b.N, b.duration, b.netAllocs, b.netBytes}
+}
+
+// Run benchmarks f as a subbenchmark with the given name. It reports whether
+// there were any failures.
+//
+// A subbenchmark is like any other benchmark. A benchmark that calls Run at
+// least once will not be measured itself and will be called once with N=1.
+func (b *B) Run(name string, f func(b *B)) bool {
+	b.hasSub = true
+	benchName, ok := b.name, true
+	if b.context != nil {
+		benchName, ok = b.context.match.fullName(&b.common, name)
+	}
+	if !ok {
+		return true
+	}
+	sub := &B{
+		common: common{
+			parent: &b.common,
+			level:  b.level + 1,
+			name:   benchName,
+			chatty: b.chatty,
+			bench:  true,
+		},
+		context:   b.context,
+		benchFunc: f,
+		benchTime: b.benchTime,
+	}
+	if b.context != nil && len(benchName) > b.context.maxLen {
+		b.context.maxLen = len(benchName)
+	}
+	if sub.run1() {
+		sub.run()
+	}
+	return !sub.failed
+}
+
+// runBenchmarks runs all benchmarks that match the pattern. It reports whether
+// all of them succeeded.
+func runBenchmarks(matchString func(pat, str string) (bool, error), benchmarks []BenchmarkToCall, pattern string, chatty bool) bool {
+	// If no flag was specified, don't run benchmarks.
+	if pattern == "" {
+		return true
+	}
+	ctx := &benchContext{
+		match: newMatcher(matchString, pattern, "-test.bench"),
+	}
+	var bs []BenchmarkToCall
+	for _, benchmark := range benchmarks {
+		if _, ok := ctx.match.fullName(nil, benchmark.Name); ok {
+			bs = append(bs, benchmark)
+			if len(benchmark.Name) > ctx.maxLen {
+				ctx.maxLen = len(benchmark.Name)
+			}
+		}
+	}
+	main := &B{
+		common: common{
+			chatty: chatty,
+			bench:  true,
+		},
+		context: ctx,
+		benchFunc: func(b *B) {
+			for _, benchmark := range bs {
+				b.Run(benchmark.Name, benchmark.Func)
+			}
+		},
+		benchTime: benchTime,
+	}
+	main.runN(1)
+	return !main.failed
+}
+
+// Benchmark benchmarks a single function. It is useful for creating custom
+// benchmarks that do not use the "tinygo test" command.
+//
+// If f calls Run, the result will be an estimate of running all its
+// subbenchmarks that don't call Run in sequence in a single benchmark.
+func Benchmark(f func(b *B)) BenchmarkResult {
+	b := &B{
+		common: common{
+			bench: true,
+		},
+		benchFunc: f,
+		benchTime: benchTime,
+	}
+	if b.run1() {
+		b.run()
+	}
+	return b.result
+}
+
+// BenchmarkResult contains the results of a benchmark run.
+type BenchmarkResult struct {
+	N         int           // The number of iterations.
+	T         time.Duration // The total time taken.
+	MemAllocs uint64        // The total number of memory allocations.
+	MemBytes  uint64        // The total number of bytes allocated.
+}
+
+// NsPerOp returns the "ns/op" metric.
+func (r BenchmarkResult) NsPerOp() int64 {
+	if r.N <= 0 {
+		return 0
+	}
+	return r.T.Nanoseconds() / int64(r.N)
+}
+
+// AllocsPerOp returns the "allocs/op" metric, which is calculated as
+// r.MemAllocs / r.N.
+func (r BenchmarkResult) AllocsPerOp() int64 {
+	if r.N <= 0 {
+		return 0
+	}
+	return int64(r.MemAllocs) / int64(r.N)
+}
+
+// AllocedBytesPerOp returns the "B/op" metric, which is calculated as
+// r.MemBytes / r.N.
+func (r BenchmarkResult) AllocedBytesPerOp() int64 {
+	if r.N <= 0 {
+		return 0
+	}
+	return int64(r.MemBytes) / int64(r.N)
+}
+
+// String returns a summary of the benchmark results, in the format used by
+// `go test -bench`:
+//
+//   100000	     12345 ns/op
+func (r BenchmarkResult) String() string {
+	ns := 0.0
+	if r.N > 0 {
+		ns = float64(r.T.Nanoseconds()) / float64(r.N)
+	}
+	return fmt.Sprintf("%8d\t", r.N) + prettyPrint(ns, "ns/op")
+}
+
+// MemString returns r.AllocedBytesPerOp and r.AllocsPerOp in the same format
+// as `go test -bench -benchmem`.
+func (r BenchmarkResult) MemString() string {
+	return fmt.Sprintf("%8d B/op\t%8d allocs/op", r.AllocedBytesPerOp(), r.AllocsPerOp())
+}
+
+// prettyPrint formats the value with a number of significant digits that
+// depends on its size, like the upstream testing package.
+func prettyPrint(x float64, unit string) string {
+	var format string
+	switch y := math.Abs(x); {
+	case y == 0 || y >= 999.95:
+		format = "%10.0f %s"
+	case y >= 99.995:
+		format = "%12.1f %s"
+	case y >= 9.9995:
+		format = "%13.2f %s"
+	case y >= 0.99995:
+		format = "%14.3f %s"
+	case y >= 0.099995:
+		format = "%15.4f %s"
+	case y >= 0.0099995:
+		format = "%16.5f %s"
+	case y >= 0.00099995:
+		format = "%17.6f %s"
+	default:
+		format = "%18.7f %s"
+	}
+	return fmt.Sprintf(format, x, unit)
+}
@@ -0,0 +1,104 @@
+package testing
+
+// This file implements examples: functions with an "Output:" comment, which
+// are run by `tinygo test` to compare their output with the comment. Only the
+// output written to os.Stdout is captured. The output of the builtin print and
+// println functions is not, just like with the upstream testing package (where
+// they write to stderr).
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExampleToCall is a reference to an example that should be called during a
+// test suite run.
+type ExampleToCall struct {
+	// Name of the example to call.
+	Name string
+	// Function reference to the example.
+	Func func()
+	// The expected output of the example.
+	Output string
+	// Unordered is true if the order of the output lines doesn't matter.
+	Unordered bool
+}
+
+// captureStdout redirects everything written to os.Stdout to the given buffer,
+// or stops capturing when the buffer is nil. It is implemented in the runtime.
+func captureStdout(buf *[]byte)
+
+// runExamples runs all examples that match the -run flag. It reports whether
+// any example was run and whether all of them passed.
+func runExamples(match *matcher, examples []ExampleToCall, chatty bool) (ran, ok bool) {
+	ok = true
+	for _, eg := range examples {
+		if _, matched := match.fullName(nil, eg.Name); !matched {
+			continue
+		}
+		ran = true
+		if !eg.run(chatty) {
+			ok = false
+		}
+	}
+	return ran, ok
+}
+
+// run runs the example while capturing its output, and reports whether it
+// passed.
+func (eg *ExampleToCall) run(chatty bool) bool {
+	if chatty {
+		fmt.Printf("=== RUN   %s\n", eg.Name)
+	}
+	var output []byte
+	captureStdout(&output)
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			captureStdout(nil)
+			eg.processRunResult(string(output), time.Since(start), chatty, r)
+		}
+	}()
+	eg.Func()
+	captureStdout(nil)
+	return eg.processRunResult(string(output), time.Since(start), chatty, nil)
+}
+
+// processRunResult compares the output of the example with the expected output
+// and prints the result. If the example panicked, the panic is continued after
+// reporting the failure.
+func (eg *ExampleToCall) processRunResult(stdout string, duration time.Duration, chatty bool, recovered interface{}) bool {
+	passed := true
+	var fail string
+	got := strings.TrimSpace(stdout)
+	want := strings.TrimSpace(eg.Output)
+	if eg.Unordered {
+		if sortLines(got) != sortLines(want) && recovered == nil {
+			fail = fmt.Sprintf("got:\n%s\nwant (unordered):\n%s\n", stdout, eg.Output)
+		}
+	} else {
+		if got != want && recovered == nil {
+			fail = fmt.Sprintf("got:\n%s\nwant:\n%s\n", got, want)
+		}
+	}
+	if fail != "" || recovered != nil {
+		fmt.Printf("--- FAIL: %s (%.2fs)\n%s", eg.Name, duration.Seconds(), fail)
+		passed = false
+	} else if chatty {
+		fmt.Printf("--- PASS: %s (%.2fs)\n", eg.Name, duration.Seconds())
+	}
+	if recovered != nil {
+		// Propagate the previously recovered result, by panicking.
+		panic(recovered)
+	}
+	return passed
+}
+
+// sortLines sorts the lines of the output, to compare unordered output.
+func sortLines(output string) string {
+	lines := strings.Split(output, "\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
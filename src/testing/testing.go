@@ -1,19 +1,57 @@
 package testing
 
+// This file implements the parts of the upstream testing package that are
+// needed to run tests: test functions with subtests, logging, failing and
+// skipping. Benchmarks and examples are implemented in benchmark.go and
+// example.go. The output follows the format of `go test`, so that tools that
+// parse test output keep working.
+//
+// There are a few differences with the upstream testing package:
+//   * FailNow and SkipNow (and thus Fatal and Skip) stop the test with a panic
+//     instead of runtime.Goexit, so a recover() in the test itself will stop
+//     them.
+//   * Log messages are prefixed with the file name and line of the call from
+//     the symbol table. Without a symbol table, the prefix is ???:1 like when
+//     the upstream testing package can't find the caller.
+//   * Tests always run sequentially.
+
 import (
-	"bytes"
 	"fmt"
-	"io"
 	"os"
+	"runtime"
+	"strings"
+	"time"
 )
 
-// T is a test helper.
+// common holds the elements common between T and B.
+type common struct {
+	output   []byte              // output generated by the test, flushed to the parent when done
+	parent   *common             // the parent test, or nil for the root of all tests
+	level    int                 // nesting depth of the test (0 for the root)
+	name     string              // full name of the test, including the names of its parents
+	chatty   bool                // print output immediately (the -v flag)
+	bench    bool                // this is a benchmark
+	failed   bool                // test has failed
+	skipped  bool                // test has been skipped
+	finished bool                // test function has completed
+	helpers  map[string]struct{} // functions that are skipped when printing the caller
+	start    time.Time
+	duration time.Duration
+}
+
+// testExit is used as panic value by FailNow and SkipNow to stop the test.
+type testExit struct{}
+
+// T is a type passed to Test functions to manage test state and support
+// formatted test logs.
 type T struct {
-	name   string
-	output io.Writer
+	common
+	context *testContext
+}
 
-	// flags the test as having failed when non-zero
-	failed int
+// testContext holds all fields that are common to all tests.
+type testContext struct {
+	match *matcher
 }
 
 // TestToCall is a reference to a test that should be called during a test suite run.
@@ -28,50 +66,350 @@ type TestToCall struct {
 type M struct {
 	// tests is a list of the test names to execute
 	Tests []TestToCall
+
+	// Benchmarks and examples in the test suite. Benchmarks only run when
+	// Bench is set.
+	Benchmarks []BenchmarkToCall
+	Examples   []ExampleToCall
+
+	// Verbose prints the name and result of every test, and all log messages
+	// as they are logged.
+	Verbose bool
+
+	// Filter is a regular expression that selects the tests to run (the -run
+	// flag). MatchString is used to match it and must be set when Filter is
+	// set.
+	Filter      string
+	MatchString func(pat, str string) (bool, error)
+
+	// Bench is a regular expression that selects the benchmarks to run (the
+	// -bench flag). BenchTime is the time each benchmark runs, or the number
+	// of iterations with an "x" suffix (the -benchtime flag).
+	Bench     string
+	BenchTime string
+}
+
+var verbose bool
+
+// Verbose reports whether the -v flag is set.
+func Verbose() bool {
+	return verbose
 }
 
-// Run the test suite.
+// Run the test suite. It returns the exit code for the test binary.
 func (m *M) Run() int {
-	failures := 0
+	verbose = m.Verbose
+	if m.BenchTime != "" {
+		t, err := parseBenchTime(m.BenchTime)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "testing: invalid value %q for -test.benchtime: %s\n", m.BenchTime, err)
+			return 2
+		}
+		benchTime = t
+	}
+	ctx := &testContext{
+		match: newMatcher(m.MatchString, m.Filter, "-test.run"),
+	}
+	root := &common{
+		chatty: m.Verbose,
+	}
+
+	ran := false
 	for _, test := range m.Tests {
+		name, ok := ctx.match.fullName(nil, test.Name)
+		if !ok {
+			continue
+		}
+		ran = true
 		t := &T{
-			name:   test.Name,
-			output: &bytes.Buffer{},
+			common: common{
+				parent: root,
+				level:  1,
+				name:   name,
+				chatty: root.chatty,
+			},
+			context: ctx,
 		}
+		t.runTest(test.Func)
+	}
 
-		fmt.Printf("=== RUN   %s\n", test.Name)
-		test.Func(t)
+	examplesRan, examplesOK := runExamples(ctx.match, m.Examples, m.Verbose)
+	if !ran && !examplesRan && m.Bench == "" {
+		fmt.Fprintln(os.Stderr, "testing: warning: no tests to run")
+	}
+	// Like the upstream testing package, benchmarks are not run when a test
+	// fails.
+	if root.failed || !examplesOK || !runBenchmarks(m.MatchString, m.Benchmarks, m.Bench, m.Verbose) {
+		fmt.Println("FAIL")
+		return 1
+	}
+	fmt.Println("PASS")
+	return 0
+}
 
-		if t.failed == 0 {
-			fmt.Printf("--- PASS: %s\n", test.Name)
+// TestMain runs the test suite and exits with the resulting exit code.
+func TestMain(m *M) {
+	os.Exit(m.Run())
+}
+
+// Run runs f as a subtest of t called name. It reports whether f succeeded.
+// Subtests run sequentially, so Run only returns after f has completed.
+func (t *T) Run(name string, f func(t *T)) bool {
+	testName, ok := t.context.match.fullName(&t.common, name)
+	if !ok {
+		return true
+	}
+	sub := &T{
+		common: common{
+			parent: &t.common,
+			level:  t.level + 1,
+			name:   testName,
+			chatty: t.chatty,
+		},
+		context: t.context,
+	}
+	sub.runTest(f)
+	return !sub.failed
+}
+
+// runTest runs the test function and reports the result.
+func (t *T) runTest(fn func(t *T)) {
+	if t.chatty {
+		fmt.Printf("=== RUN   %s\n", t.name)
+	}
+	defer func() {
+		t.duration = time.Since(t.start)
+		if r := recover(); r != nil {
+			if _, ok := r.(testExit); !ok {
+				if !t.finished {
+					t.reportPanic()
+				}
+				panic(r)
+			}
+		}
+		t.finished = true
+		t.report()
+	}()
+	t.start = time.Now()
+	fn(t)
+}
+
+// reportPanic reports the failure of this test and all its parents after a
+// real panic, before crashing like the upstream testing package. The parents
+// are marked as finished, so that they don't report the failure again while
+// the panic passes through them.
+func (c *common) reportPanic() {
+	c.Fail()
+	for p := c; p.parent != nil; p = p.parent {
+		if !p.bench {
+			p.duration = time.Since(p.start)
+		}
+		p.finished = true
+		p.report()
+	}
+}
+
+// report writes the result of the test with its output to the parent test.
+// Passed and skipped tests are only reported in verbose mode. Benchmarks only
+// report failures here, their results are printed by the benchmark runner.
+func (c *common) report() {
+	if c.bench {
+		if c.failed {
+			fmt.Printf("--- FAIL: %s\n%s", c.name, c.output)
+		}
+		return
+	}
+	format := "--- %s: %s (%.2fs)\n"
+	seconds := c.duration.Seconds()
+	if c.failed {
+		c.flushToParent(fmt.Sprintf(format, "FAIL", c.name, seconds))
+	} else if c.chatty {
+		if c.skipped {
+			c.flushToParent(fmt.Sprintf(format, "SKIP", c.name, seconds))
 		} else {
-			fmt.Printf("--- FAIL: %s\n", test.Name)
+			c.flushToParent(fmt.Sprintf(format, "PASS", c.name, seconds))
+		}
+	}
+}
+
+// flushToParent writes the header and the output of this test to the parent.
+// Top-level tests print directly, subtests add it (indented) to the output of
+// their parent.
+func (c *common) flushToParent(header string) {
+	s := header + string(c.output)
+	c.output = c.output[:0]
+	p := c.parent
+	if p.parent == nil {
+		fmt.Print(s)
+		return
+	}
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if line != "" {
+			p.output = append(p.output, "    "+line...)
 		}
-		fmt.Println(t.output)
+	}
+}
 
-		failures += t.failed
+// Name returns the name of the running test.
+func (c *common) Name() string {
+	return c.name
+}
+
+// Fail marks the function as having failed but continues execution.
+func (c *common) Fail() {
+	if c.parent != nil {
+		c.parent.Fail()
+	}
+	if c.finished {
+		panic("Fail in goroutine after " + c.name + " has completed")
 	}
+	c.failed = true
+}
 
-	if failures > 0 {
-		fmt.Printf("exit status %d\n", failures)
-		fmt.Println("FAIL")
+// Failed reports whether the function has failed.
+func (c *common) Failed() bool {
+	return c.failed
+}
+
+// FailNow marks the function as having failed and stops its execution.
+// Execution will continue at the next test.
+func (c *common) FailNow() {
+	c.Fail()
+	c.finished = true
+	panic(testExit{})
+}
+
+// log adds the message to the output of the test, or prints it immediately in
+// verbose mode. The output of benchmarks is always printed afterwards, so that
+// it doesn't end up in the middle of the result line.
+func (c *common) log(s string) {
+	s = c.decorate(s)
+	if c.chatty && !c.bench {
+		fmt.Print(s)
+		return
 	}
-	return failures
+	c.output = append(c.output, s...)
 }
 
-func TestMain(m *M) {
-	os.Exit(m.Run())
+// decorate prefixes the string with the file and line of the call site and
+// indents it. A final newline is added if needed.
+func (c *common) decorate(s string) string {
+	file, line, ok := c.caller()
+	if ok {
+		if index := strings.LastIndex(file, "/"); index >= 0 {
+			file = file[index+1:]
+		}
+	} else {
+		file = "???"
+		line = 1
+	}
+	buf := fmt.Sprintf("    %s:%d: ", file, line)
+	lines := strings.Split(s, "\n")
+	if l := len(lines); l > 1 && lines[l-1] == "" {
+		lines = lines[:l-1]
+	}
+	for i, line := range lines {
+		if i > 0 {
+			// Second and subsequent lines are indented an additional 4 spaces.
+			buf += "\n        "
+		}
+		buf += line
+	}
+	return buf + "\n"
+}
+
+// caller returns the file and line of the first function on the stack that is
+// neither part of the testing package nor marked as helper.
+func (c *common) caller() (file string, line int, ok bool) {
+	for skip := 1; skip < 32; skip++ {
+		pc, file, line, ok := runtime.Caller(skip)
+		if !ok {
+			return "", 0, false
+		}
+		name := runtime.FuncForPC(pc - 1).Name()
+		if strings.HasPrefix(name, "testing.") {
+			continue
+		}
+		if _, isHelper := c.helpers[name]; isHelper {
+			continue
+		}
+		return file, line, true
+	}
+	return "", 0, false
+}
+
+// Helper marks the calling function as a test helper function. When printing
+// file and line information, that function will be skipped.
+func (c *common) Helper() {
+	pc, _, _, ok := runtime.Caller(1)
+	if !ok {
+		return
+	}
+	if c.helpers == nil {
+		c.helpers = make(map[string]struct{})
+	}
+	c.helpers[runtime.FuncForPC(pc-1).Name()] = struct{}{}
+}
+
+// Log formats its arguments using default formatting, analogous to Println,
+// and records the text in the error log. The text is printed if the test fails
+// or the -v flag is set.
+func (c *common) Log(args ...interface{}) {
+	c.log(fmt.Sprintln(args...))
+}
+
+// Logf formats its arguments according to the format, analogous to Printf, and
+// records the text in the error log.
+func (c *common) Logf(format string, args ...interface{}) {
+	c.log(fmt.Sprintf(format, args...))
+}
+
+// Error is equivalent to Log followed by Fail.
+func (c *common) Error(args ...interface{}) {
+	c.log(fmt.Sprintln(args...))
+	c.Fail()
+}
+
+// Errorf is equivalent to Logf followed by Fail.
+func (c *common) Errorf(format string, args ...interface{}) {
+	c.log(fmt.Sprintf(format, args...))
+	c.Fail()
+}
+
+// Fatal is equivalent to Log followed by FailNow.
+func (c *common) Fatal(args ...interface{}) {
+	c.log(fmt.Sprintln(args...))
+	c.FailNow()
+}
+
+// Fatalf is equivalent to Logf followed by FailNow.
+func (c *common) Fatalf(format string, args ...interface{}) {
+	c.log(fmt.Sprintf(format, args...))
+	c.FailNow()
+}
+
+// Skip is equivalent to Log followed by SkipNow.
+func (c *common) Skip(args ...interface{}) {
+	c.log(fmt.Sprintln(args...))
+	c.SkipNow()
+}
+
+// Skipf is equivalent to Logf followed by SkipNow.
+func (c *common) Skipf(format string, args ...interface{}) {
+	c.log(fmt.Sprintf(format, args...))
+	c.SkipNow()
 }
 
-// Error is equivalent to Log followed by Fail
-func (t *T) Error(args ...interface{}) {
-	// This doesn't print the same as in upstream go, but works good enough
-	// TODO: buffer test output like go does
-	fmt.Fprintf(t.output, "\t")
-	fmt.Fprintln(t.output, args...)
-	t.Fail()
+// SkipNow marks the test as having been skipped and stops its execution.
+// Execution will continue at the next test. If a test fails and is then
+// skipped, it is still considered to have failed.
+func (c *common) SkipNow() {
+	c.skipped = true
+	c.finished = true
+	panic(testExit{})
 }
 
-func (t *T) Fail() {
-	t.failed = 1
+// Skipped reports whether the test was skipped.
+func (c *common) Skipped() bool {
+	return c.skipped
 }
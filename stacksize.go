@@ -0,0 +1,201 @@
+package main
+
+// This file implements the static stack usage analysis of -print-stacks. The
+// stack frame size of each function is read from the .stack_sizes section
+// that LLVM emits, and the worst case stack depth of each root function is
+// calculated by following the call graph of the program.
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// stackUsage is the worst case stack usage of a function, including the
+// functions it calls.
+type stackUsage struct {
+	size   uint64
+	reason string // why the stack usage is unknown (empty if it is known)
+}
+
+// stackAnalysis calculates the stack usage of functions from their frame sizes
+// and the call graph.
+type stackAnalysis struct {
+	callGraph  map[string][]string // called functions, "" for an indirect call
+	frameSizes map[string]uint64   // stack frame size of each function
+	results    map[string]*stackUsage
+	visiting   map[string]bool
+}
+
+// usage returns the worst case stack usage of the given function. It is
+// unknown when there is recursion or an indirect call, or when a function is
+// called of which the frame size or the functions it calls are not known. The
+// latter includes all functions that are not part of the call graph, like C
+// functions and functions from compiler-rt (like __aeabi_memclr).
+func (a *stackAnalysis) usage(name string) *stackUsage {
+	if result, ok := a.results[name]; ok {
+		return result
+	}
+	if a.visiting[name] {
+		return &stackUsage{reason: "recursion in " + name}
+	}
+	frameSize, ok := a.frameSizes[name]
+	if !ok {
+		result := &stackUsage{reason: "unknown stack size of " + name}
+		a.results[name] = result
+		return result
+	}
+
+	callees, ok := a.callGraph[name]
+	if !ok {
+		result := &stackUsage{size: frameSize, reason: "unknown calls in " + name}
+		a.results[name] = result
+		return result
+	}
+
+	a.visiting[name] = true
+	result := &stackUsage{}
+	var maxCallee uint64
+	for _, callee := range callees {
+		if callee == "" {
+			if result.reason == "" {
+				result.reason = "indirect call in " + name
+			}
+			continue
+		}
+		calleeUsage := a.usage(callee)
+		if calleeUsage.reason != "" && result.reason == "" {
+			result.reason = calleeUsage.reason
+		}
+		if calleeUsage.size > maxCallee {
+			maxCallee = calleeUsage.size
+		}
+	}
+	delete(a.visiting, name)
+	result.size = frameSize + maxCallee
+	a.results[name] = result
+	return result
+}
+
+// printStacks prints the worst case stack usage of the functions that start
+// with an empty stack: exported functions (like the program entry point and
+// interrupt handlers) and goroutines.
+func printStacks(path string, callGraph map[string][]string, exported, goroutines []string) error {
+	frameSizes, err := readStackSizes(path)
+	if err != nil {
+		return err
+	}
+	a := &stackAnalysis{
+		callGraph:  callGraph,
+		frameSizes: frameSizes,
+		results:    make(map[string]*stackUsage),
+		visiting:   make(map[string]bool),
+	}
+
+	type root struct {
+		name  string
+		label string
+	}
+	var roots []root
+	sort.Strings(exported)
+	for _, name := range exported {
+		if _, ok := frameSizes[name]; ok {
+			roots = append(roots, root{name, name})
+		}
+	}
+	sort.Strings(goroutines)
+	for _, name := range goroutines {
+		if _, ok := frameSizes[name]; ok {
+			roots = append(roots, root{name, name + " (goroutine)"})
+		}
+	}
+
+	width := len("function")
+	for _, r := range roots {
+		if len(r.label) > width {
+			width = len(r.label)
+		}
+	}
+	fmt.Printf("%-*s  stack usage (in bytes)\n", width, "function")
+	for _, r := range roots {
+		usage := a.usage(r.name)
+		if usage.reason != "" {
+			fmt.Printf("%-*s  unknown (%s)\n", width, r.label, usage.reason)
+		} else {
+			fmt.Printf("%-*s  %d\n", width, r.label, usage.size)
+		}
+	}
+	return nil
+}
+
+// readStackSizes reads the .stack_sizes section emitted by LLVM from the given
+// ELF file, and returns the stack frame size of each function by name.
+func readStackSizes(path string) (map[string]uint64, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	section := file.Section(".stack_sizes")
+	if section == nil {
+		return nil, errors.New("no .stack_sizes section found in " + path)
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	// Map function addresses to names. The lowest bit of the address of a
+	// Thumb function is set, but not always in the same way in the symbol
+	// table and in the stack size section, so ignore it on ARM.
+	addressMask := ^uint64(0)
+	if file.Machine == elf.EM_ARM {
+		addressMask = ^uint64(1)
+	}
+	symbols, err := file.Symbols()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[uint64][]string)
+	for _, symbol := range symbols {
+		if elf.ST_TYPE(symbol.Info) != elf.STT_FUNC {
+			continue
+		}
+		address := symbol.Value & addressMask
+		names[address] = append(names[address], symbol.Name)
+	}
+
+	// Every entry is a function address (of pointer size) followed by the
+	// stack frame size as an unsigned LEB128 number.
+	addressSize := 4
+	if file.Class == elf.ELFCLASS64 {
+		addressSize = 8
+	}
+	sizes := make(map[string]uint64)
+	r := bytes.NewReader(data)
+	for r.Len() != 0 {
+		var address uint64
+		if addressSize == 8 {
+			err = binary.Read(r, file.ByteOrder, &address)
+		} else {
+			var address32 uint32
+			err = binary.Read(r, file.ByteOrder, &address32)
+			address = uint64(address32)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read .stack_sizes section: %v", err)
+		}
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("could not read .stack_sizes section: %v", err)
+		}
+		for _, name := range names[address&addressMask] {
+			sizes[name] = size
+		}
+	}
+	return sizes, nil
+}
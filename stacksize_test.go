@@ -0,0 +1,84 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReadStackSizes(t *testing.T) {
+	// The ELF file is built from stacks.s in the same directory.
+	sizes, err := readStackSizes(filepath.Join(TESTDATA, "stacksize", "stacks.elf"))
+	if err != nil {
+		t.Fatal("could not read stack sizes:", err)
+	}
+	expected := map[string]uint64{
+		"_start":         16,
+		"main.foo":       32,
+		"main.bar":       8,
+		"main.barAlias":  8, // same address as main.bar
+		"main.recursive": 24,
+		"main.indirect":  48,
+		"cfunc":          40,
+	}
+	if len(sizes) != len(expected) {
+		t.Errorf("expected stack sizes %v, got %v", expected, sizes)
+	}
+	for name, size := range expected {
+		if actual, ok := sizes[name]; !ok || actual != size {
+			t.Errorf("%s: expected stack size %d, got %d", name, size, actual)
+		}
+	}
+
+	_, err = readStackSizes(filepath.Join(TESTDATA, "sizes", "old.elf"))
+	if err == nil {
+		t.Error("expected an error for a file without .stack_sizes section")
+	}
+}
+
+func TestStackUsage(t *testing.T) {
+	a := &stackAnalysis{
+		callGraph: map[string][]string{
+			"main":        {"foo", "bar"},
+			"foo":         {"bar"},
+			"bar":         {},
+			"recursive":   {"bar", "recursive"},
+			"indirect":    {"bar", ""},
+			"callsC":      {"foo", "cfunc"},
+			"callsNoSize": {"nosize"},
+			"nosize":      {},
+			"deep":        {"main", "callsC"},
+		},
+		frameSizes: map[string]uint64{
+			"main":        16,
+			"foo":         32,
+			"bar":         8,
+			"recursive":   24,
+			"indirect":    48,
+			"callsC":      16,
+			"callsNoSize": 16,
+			"cfunc":       40, // not in the call graph
+			"deep":        8,
+		},
+		results:  make(map[string]*stackUsage),
+		visiting: make(map[string]bool),
+	}
+	tests := []struct {
+		name   string
+		size   uint64
+		reason string
+	}{
+		{"bar", 8, ""},
+		{"main", 56, ""}, // main -> foo -> bar
+		{"recursive", 32, "recursion in recursive"},
+		{"indirect", 56, "indirect call in indirect"},
+		{"callsC", 56, "unknown calls in cfunc"},
+		{"callsNoSize", 16, "unknown stack size of nosize"},
+		{"deep", 64, "unknown calls in cfunc"}, // deep -> callsC -> cfunc
+	}
+	for _, tc := range tests {
+		usage := a.usage(tc.name)
+		if usage.size != tc.size || usage.reason != tc.reason {
+			t.Errorf("%s: expected stack usage %d (%q), got %d (%q)", tc.name, tc.size, tc.reason, usage.size, usage.reason)
+		}
+	}
+}
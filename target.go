@@ -22,25 +22,34 @@ import (
 // https://doc.rust-lang.org/nightly/nightly-rustc/rustc_target/spec/struct.TargetOptions.html
 // https://github.com/shepmaster/rust-arduino-blink-led-no-core-with-cargo/blob/master/blink/arduino.json
 type TargetSpec struct {
-	Inherits   []string `json:"inherits"`
-	Triple     string   `json:"llvm-target"`
-	CPU        string   `json:"cpu"`
-	Features   []string `json:"features"`
-	GOOS       string   `json:"goos"`
-	GOARCH     string   `json:"goarch"`
-	BuildTags  []string `json:"build-tags"`
-	GC         string   `json:"gc"`
-	Compiler   string   `json:"compiler"`
-	Linker     string   `json:"linker"`
-	RTLib      string   `json:"rtlib"` // compiler runtime library (libgcc, compiler-rt)
-	CFlags     []string `json:"cflags"`
-	LDFlags    []string `json:"ldflags"`
-	ExtraFiles []string `json:"extra-files"`
-	Emulator   []string `json:"emulator"`
-	Flasher    string   `json:"flash"`
-	OCDDaemon  []string `json:"ocd-daemon"`
-	GDB        string   `json:"gdb"`
-	GDBCmds    []string `json:"gdb-initial-cmds"`
+	Name       string   `json:"-"` // target name as passed to LoadTarget, or the LLVM triple
+	Inherits   []string `json:"inherits,omitempty"`
+	Triple     string   `json:"llvm-target,omitempty"`
+	CPU        string   `json:"cpu,omitempty"`
+	Features   []string `json:"features,omitempty"`
+	GOOS       string   `json:"goos,omitempty"`
+	GOARCH     string   `json:"goarch,omitempty"`
+	BuildTags  []string `json:"build-tags,omitempty"`
+	GC         string   `json:"gc,omitempty"`
+	Compiler   string   `json:"compiler,omitempty"`
+	Linker     string   `json:"linker,omitempty"`
+	RTLib      string   `json:"rtlib,omitempty"` // compiler runtime library (libgcc, compiler-rt)
+	CFlags     []string `json:"cflags,omitempty"`
+	LDFlags    []string `json:"ldflags,omitempty"`
+	ExtraFiles []string `json:"extra-files,omitempty"`
+	Emulator   []string `json:"emulator,omitempty"`
+	Flasher    string   `json:"flash,omitempty"`
+	OCDDaemon  []string `json:"ocd-daemon,omitempty"`
+	GDB        string   `json:"gdb,omitempty"`
+	GDBCmds    []string `json:"gdb-initial-cmds,omitempty"`
+
+	// Memory limits of the chip, checked after linking. They are in bytes and
+	// zero when unknown. The flash size is the flash available to the program
+	// (excluding a bootloader). The minimum stack size is the smallest stack
+	// the linker script may reserve.
+	FlashSize    uint64 `json:"flash-size,omitempty"`
+	RAMSize      uint64 `json:"ram-size,omitempty"`
+	MinStackSize uint64 `json:"min-stack-size,omitempty"`
 }
 
 // copyProperties copies all properties that are set in spec2 into itself.
@@ -92,12 +101,26 @@ func (spec *TargetSpec) copyProperties(spec2 *TargetSpec) {
 	if len(spec2.GDBCmds) != 0 {
 		spec.GDBCmds = spec2.GDBCmds
 	}
+	if spec2.FlashSize != 0 {
+		spec.FlashSize = spec2.FlashSize
+	}
+	if spec2.RAMSize != 0 {
+		spec.RAMSize = spec2.RAMSize
+	}
+	if spec2.MinStackSize != 0 {
+		spec.MinStackSize = spec2.MinStackSize
+	}
 }
 
 // load reads a target specification from the JSON in the given io.Reader. It
-// may load more targets specified using the "inherits" property.
-func (spec *TargetSpec) load(r io.Reader) error {
-	err := json.NewDecoder(r).Decode(spec)
+// may load more targets specified using the "inherits" property. When strict is
+// set, keys that are not part of a target specification are an error.
+func (spec *TargetSpec) load(r io.Reader, strict bool) error {
+	dec := json.NewDecoder(r)
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	err := dec.Decode(spec)
 	if err != nil {
 		return err
 	}
@@ -105,37 +128,54 @@ func (spec *TargetSpec) load(r io.Reader) error {
 	return nil
 }
 
+// targetPath returns the path to the .json file of the target given as a string
+// (see loadFromGivenStr).
+func targetPath(str string) string {
+	if strings.HasSuffix(str, ".json") {
+		path, _ := filepath.Abs(str)
+		return path
+	}
+	return filepath.Join(sourceDir(), "targets", strings.ToLower(str)+".json")
+}
+
 // loadFromGivenStr loads the TargetSpec from the given string that could be:
 // - targets/ directory inside the compiler sources
 // - a relative or absolute path to custom (project specific) target specification .json file;
 //   the Inherits[] could contain the files from target folder (ex. stm32f4disco)
 //   as well as path to custom files (ex. myAwesomeProject.json)
-func (spec *TargetSpec) loadFromGivenStr(str string) error {
-	path := ""
-	if strings.HasSuffix(str, ".json") {
-		path, _ = filepath.Abs(str)
-	} else {
-		path = filepath.Join(sourceDir(), "targets", strings.ToLower(str)+".json")
-	}
+func (spec *TargetSpec) loadFromGivenStr(str string, strict bool) error {
+	path := targetPath(str)
 	fp, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer fp.Close()
-	return spec.load(fp)
+	err = spec.load(fp, strict)
+	if err != nil {
+		return fmt.Errorf("could not load target %s: %v", path, err)
+	}
+	return nil
 }
 
-// resolveInherits loads inherited targets, recursively.
-func (spec *TargetSpec) resolveInherits() error {
+// resolveInherits loads inherited targets, recursively. The chain contains the
+// targets that (indirectly) inherit from this target, starting with this target
+// itself, to detect inheritance cycles.
+func (spec *TargetSpec) resolveInherits(chain []string, strict bool) error {
 	// First create a new spec with all the inherited properties.
 	newSpec := &TargetSpec{}
 	for _, name := range spec.Inherits {
+		for i, parent := range chain {
+			if targetPath(parent) == targetPath(name) {
+				cycle := append(append([]string{}, chain[i:]...), name)
+				return errors.New("inheritance cycle in targets: " + strings.Join(cycle, " -> "))
+			}
+		}
 		subtarget := &TargetSpec{}
-		err := subtarget.loadFromGivenStr(name)
+		err := subtarget.loadFromGivenStr(name, strict)
 		if err != nil {
 			return err
 		}
-		err = subtarget.resolveInherits()
+		err = subtarget.resolveInherits(append(chain[:len(chain):len(chain)], name), strict)
 		if err != nil {
 			return err
 		}
@@ -149,6 +189,17 @@ func (spec *TargetSpec) resolveInherits() error {
 	return nil
 }
 
+// isBareMetal returns whether the target runs without an operating system,
+// which is indicated by "none" in the LLVM triple (like armv7m-none-eabi).
+func (spec *TargetSpec) isBareMetal() bool {
+	for _, part := range strings.Split(spec.Triple, "-") {
+		if part == "none" {
+			return true
+		}
+	}
+	return false
+}
+
 // Load a target specification.
 func LoadTarget(target string) (*TargetSpec, error) {
 	if target == "" {
@@ -181,14 +232,15 @@ func LoadTarget(target string) (*TargetSpec, error) {
 	// See whether there is a target specification for this target (e.g.
 	// Arduino).
 	spec := &TargetSpec{}
-	err := spec.loadFromGivenStr(target)
+	err := spec.loadFromGivenStr(target, false)
 	if err == nil {
 		// Successfully loaded this target from a built-in .json file. Make sure
 		// it includes all parents as specified in the "inherits" key.
-		err = spec.resolveInherits()
+		err = spec.resolveInherits([]string{target}, false)
 		if err != nil {
 			return nil, err
 		}
+		spec.Name = target
 		return spec, nil
 	} else if !os.IsNotExist(err) {
 		// Expected a 'file not found' error, got something else. Report it as
@@ -221,6 +273,7 @@ func defaultTarget(goos, goarch, triple string) (*TargetSpec, error) {
 	// No target spec available. Use the default one, useful on most systems
 	// with a regular OS.
 	spec := TargetSpec{
+		Name:      triple,
 		Triple:    triple,
 		GOOS:      goos,
 		GOARCH:    goarch,
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestTargetMemorySizes checks that the memory sizes in the target
+// specifications, which are used to check the size of a program after
+// linking, match the memory regions and stack size of their linker scripts.
+func TestTargetMemorySizes(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(sourceDir(), "targets", "*.json"))
+	if err != nil {
+		t.Fatal("could not list targets:", err)
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			spec := &TargetSpec{}
+			err := spec.loadFromGivenStr(name, false)
+			if err != nil {
+				t.Fatal("could not load target:", err)
+			}
+			err = spec.resolveInherits([]string{name}, false)
+			if err != nil {
+				t.Fatal("could not load target:", err)
+			}
+			if spec.FlashSize == 0 && spec.RAMSize == 0 && spec.MinStackSize == 0 {
+				return // no memory sizes to check
+			}
+
+			script := &linkerScript{
+				symbols: map[string]uint64{},
+				lengths: map[string]string{},
+			}
+			var scriptPaths []string
+			for i, flag := range spec.LDFlags {
+				if flag == "-T" && i+1 < len(spec.LDFlags) {
+					scriptPaths = append(scriptPaths, spec.LDFlags[i+1])
+				} else if strings.HasPrefix(flag, "-Wl,--defsym=") {
+					fields := strings.SplitN(flag[len("-Wl,--defsym="):], "=", 2)
+					value, err := script.eval(fields[1])
+					if err != nil {
+						t.Fatalf("could not parse linker flag %s: %v", flag, err)
+					}
+					script.symbols[fields[0]] = value
+				}
+			}
+			if len(scriptPaths) == 0 {
+				t.Fatal("target has memory sizes but no linker script")
+			}
+			for _, path := range scriptPaths {
+				if _, err := os.Stat(filepath.Join(sourceDir(), path)); os.IsNotExist(err) {
+					t.Skip("linker script is generated:", path)
+				}
+				err = script.read(path)
+				if err != nil {
+					t.Fatal("could not read linker script:", err)
+				}
+			}
+			scriptNames := strings.Join(scriptPaths, ", ")
+
+			check := func(what string, size uint64, expr string) {
+				value, err := script.eval(expr)
+				if err != nil {
+					t.Errorf("could not find %s size in %s: %v", what, scriptNames, err)
+				} else if size != value {
+					t.Errorf("%s size is %d, but %d in %s", what, size, value, scriptNames)
+				}
+			}
+			check("flash", spec.FlashSize, script.lengths["FLASH_TEXT"])
+			check("ram", spec.RAMSize, script.lengths["RAM"])
+			check("stack", spec.MinStackSize, "_stack_size")
+		})
+	}
+}
+
+// linkerScript is the part of a linker script that is needed to find the size
+// of memory regions: the symbols that are assigned a constant value and the
+// length of each memory region.
+type linkerScript struct {
+	symbols map[string]uint64
+	lengths map[string]string // expression of the length of each memory region
+}
+
+var (
+	linkerCommentRegexp    = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	linkerIncludeRegexp    = regexp.MustCompile(`^INCLUDE\s+"?([^"]+)"?$`)
+	linkerAssignmentRegexp = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=\s*([^;]+);$`)
+	linkerMemoryRegexp     = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*(\([a-z]*\))?\s*:\s*ORIGIN\s*=\s*[^,]+,\s*LENGTH\s*=\s*(.+)$`)
+	linkerTokenRegexp      = regexp.MustCompile(`^\s*(0[xX][0-9a-fA-F]+|[0-9]+[KM]?|[A-Za-z_][A-Za-z0-9_]*|[-+])`)
+)
+
+// read reads a linker script (relative to the TinyGo root, like the linker
+// does) and all scripts it includes.
+func (s *linkerScript) read(path string) error {
+	data, err := ioutil.ReadFile(filepath.Join(sourceDir(), path))
+	if err != nil {
+		return err
+	}
+	text := linkerCommentRegexp.ReplaceAllString(string(data), "")
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if match := linkerIncludeRegexp.FindStringSubmatch(line); match != nil {
+			err := s.read(match[1])
+			if err != nil {
+				return err
+			}
+		} else if match := linkerAssignmentRegexp.FindStringSubmatch(line); match != nil {
+			// Assignments that are not constant (like _stack_top = .) are
+			// not needed.
+			if value, err := s.eval(match[2]); err == nil {
+				s.symbols[match[1]] = value
+			}
+		} else if match := linkerMemoryRegexp.FindStringSubmatch(line); match != nil {
+			s.lengths[match[1]] = match[3]
+		}
+	}
+	return nil
+}
+
+// eval evaluates a constant expression of numbers (with an optional K or M
+// suffix) and known symbols, added or subtracted.
+func (s *linkerScript) eval(expr string) (uint64, error) {
+	var result uint64
+	operator := "+"
+	for expectOperand := true; ; expectOperand = !expectOperand {
+		if strings.TrimSpace(expr) == "" {
+			if expectOperand {
+				return 0, errors.New("incomplete expression")
+			}
+			return result, nil
+		}
+		match := linkerTokenRegexp.FindStringSubmatch(expr)
+		if match == nil {
+			return 0, errors.New("cannot parse expression: " + expr)
+		}
+		expr = expr[len(match[0]):]
+		token := match[1]
+		if !expectOperand {
+			if token != "+" && token != "-" {
+				return 0, errors.New("expected operator, got " + token)
+			}
+			operator = token
+			continue
+		}
+		var value uint64
+		if c := token[0]; c >= '0' && c <= '9' {
+			multiplier := uint64(1)
+			if strings.HasSuffix(token, "K") {
+				multiplier = 1024
+			} else if strings.HasSuffix(token, "M") {
+				multiplier = 1024 * 1024
+			}
+			n, err := strconv.ParseUint(strings.TrimRight(token, "KM"), 0, 64)
+			if err != nil {
+				return 0, err
+			}
+			value = n * multiplier
+		} else if v, ok := s.symbols[token]; ok {
+			value = v
+		} else {
+			return 0, errors.New("unknown symbol " + token)
+		}
+		if operator == "+" {
+			result += value
+		} else {
+			result -= value
+		}
+	}
+}
@@ -75,6 +75,11 @@
 		constructor() {
 			this._callbackTimeouts = new Map();
 			this._nextCallbackTimeoutID = 1;
+			this.exit = (code) => {
+				if (code !== 0) {
+					console.warn("exit code:", code);
+				}
+			};
 
 			const mem = () => {
 				// The buffer may change when requesting more memory.
@@ -206,6 +211,12 @@
 						}
 					},
 
+					// func exit(code int32)
+					"runtime.exit": (code) => {
+						this.exited = true;
+						this.exit(code);
+					},
+
 					// func ticks() float64
 					"runtime.ticks": () => {
 						return timeOrigin + performance.now();
@@ -380,6 +391,7 @@
 		}
 
 		const go = new Go();
+		go.exit = process.exit;
 		WebAssembly.instantiate(fs.readFileSync(process.argv[2]), go.importObject).then((result) => {
 			process.on("exit", (code) => { // Node.js exits if no callback is pending
 				if (code === 0 && !go.exited) {
@@ -389,7 +401,8 @@
 			});
 			return go.run(result.instance);
 		}).catch((err) => {
-			throw err;
+			console.error(err);
+			process.exit(1);
 		});
 	}
 })();
@@ -105,6 +105,53 @@ func main() {
 	}
 	close(ch)
 
+	// Test buffered channels.
+	ch = make(chan int, 2)
+	ch <- 1
+	ch <- 2
+	println("len, cap of buffered channel:", len(ch), cap(ch))
+	go func(ch chan int) {
+		ch <- 3 // blocks until there is space in the buffer
+	}(ch)
+	for i := 0; i < 3; i++ {
+		println("buffered recv:", <-ch)
+	}
+	ch <- 4
+	close(ch)
+	n, ok = <-ch
+	println("recv from closed buffered channel:", n, ok)
+	n, ok = <-ch
+	println("recv from closed empty buffered channel:", n, ok)
+
+	// Test select send with a buffered channel.
+	ch = make(chan int, 1)
+	select {
+	case ch <- 5:
+		println("select buffered send")
+	default:
+		println("unreachable")
+	}
+	select {
+	case ch <- 6:
+		println("unreachable")
+	default:
+		println("select buffered send: buffer full")
+	}
+	println("select buffered recv:", <-ch)
+
+	// Test a blocking select that has to wait for another goroutine.
+	ch = make(chan int)
+	go func(ch chan int) {
+		time.Sleep(time.Millisecond)
+		ch <- 7
+	}(ch)
+	select {
+	case n := <-ch:
+		println("blocking select recv:", n)
+	case n := <-make(chan int):
+		println("unreachable:", n)
+	}
+
 	// Allow goroutines to exit.
 	time.Sleep(time.Microsecond)
 }
@@ -28,6 +28,48 @@ func main() {
 	var printer Printer
 	printer = &myPrinter{}
 	printer.Print()
+
+	// Start a goroutine on an interface method.
+	go printer.Print()
+	time.Sleep(2 * time.Millisecond)
+
+	// Start a goroutine on a closure.
+	n := 3
+	go func() {
+		time.Sleep(time.Millisecond)
+		println("closure goroutine:", n)
+	}()
+	time.Sleep(2 * time.Millisecond)
+
+	// Start goroutines on func values.
+	fn := printValue
+	go fn(5)
+	time.Sleep(time.Millisecond)
+	v := &valuePrinter{7}
+	method := v.Print
+	go method()
+	time.Sleep(time.Millisecond)
+	println("done with func value goroutines")
+
+	// Start goroutines on a func passed as a parameter and on a func stored
+	// in a struct field. These may be blocking.
+	startGoroutine(printValue, 9)
+	startGoroutine(sleepValue, 11)
+	time.Sleep(2 * time.Millisecond)
+	holder := &funcHolder{printValue}
+	holder.start(13)
+	time.Sleep(time.Millisecond)
+	holder.fn = sleepValue
+	holder.start(15)
+	time.Sleep(2 * time.Millisecond)
+	println("done with func parameter and field goroutines")
+
+	// Call func values, one of which is blocking. The caller must wait for the
+	// blocking call, while the other calls are regular calls.
+	for i, fn := range []func(int){printValue, sleepValue, printValue} {
+		fn(17 + i*2)
+	}
+	println("done with func value calls")
 }
 
 func sub() {
@@ -62,3 +104,32 @@ func (i *myPrinter) Print() {
 	time.Sleep(time.Millisecond)
 	println("async interface method call")
 }
+
+func printValue(n int) {
+	println("func value goroutine:", n)
+}
+
+type valuePrinter struct {
+	n int
+}
+
+func (p *valuePrinter) Print() {
+	println("bound method goroutine:", p.n)
+}
+
+func sleepValue(n int) {
+	time.Sleep(time.Millisecond)
+	println("blocking func value goroutine:", n)
+}
+
+func startGoroutine(fn func(int), n int) {
+	go fn(n)
+}
+
+type funcHolder struct {
+	fn func(int)
+}
+
+func (h *funcHolder) start(n int) {
+	go h.fn(n)
+}
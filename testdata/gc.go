@@ -17,6 +17,7 @@ func randuint32() uint32 {
 
 func main() {
 	testNonPointerHeap()
+	testPointerHeap()
 }
 
 var scalarSlices [4][]byte
@@ -57,3 +58,41 @@ func testNonPointerHeap() {
 	}
 	println("ok")
 }
+
+type heapNode struct {
+	value uint32
+	next  *heapNode
+	data  []byte
+}
+
+var pointerLists [4]*heapNode
+
+func testPointerHeap() {
+	// Build linked lists of nodes that contain both pointers and scalars. The
+	// nodes are only reachable through pointers in other heap objects, so
+	// these pointers must be found while scanning the heap.
+	for i := 0; i < 1000; i++ {
+		index := randuint32() % 4
+
+		// Check whether all nodes in the list are still intact.
+		for node := pointerLists[index]; node != nil; node = node.next {
+			if len(node.data) != 16 || node.data[15] != byte(node.value) {
+				panic("memory was overwritten!")
+			}
+		}
+
+		// Sometimes drop the list, so that it can be freed.
+		if randuint32()%8 == 0 {
+			pointerLists[index] = nil
+		}
+
+		node := &heapNode{
+			value: randuint32(),
+			next:  pointerLists[index],
+			data:  make([]byte, 16),
+		}
+		node.data[15] = byte(node.value)
+		pointerLists[index] = node
+	}
+	println("ok")
+}
@@ -0,0 +1,71 @@
+package main
+
+// This test keeps much more memory alive than the initial heap (1MB on Unix
+// and even less on WebAssembly), so the heap must grow while the garbage
+// collector finds all live objects. It is not run on baremetal targets, where
+// the heap cannot grow.
+
+type chunk struct {
+	next *chunk
+	data []byte
+	seed uint32
+}
+
+// garbage keeps the last garbage allocation alive, so that it is not
+// optimized away.
+var garbage []byte
+
+func main() {
+	// Keep about 4MB alive in a linked list. All but the first chunk are only
+	// reachable through pointers in other heap objects.
+	var list *chunk
+	for i := 0; i < 4096; i++ {
+		c := &chunk{
+			next: list,
+			data: make([]byte, 1024),
+			seed: uint32(i) + 1,
+		}
+		fill(c.data, c.seed)
+		list = c
+
+		// Allocate some garbage too, so that collection cycles find memory
+		// to free in between.
+		garbage = make([]byte, 512)
+	}
+
+	n := 0
+	for c := list; c != nil; c = c.next {
+		if !check(c.data, c.seed) {
+			panic("memory was overwritten!")
+		}
+		n++
+	}
+	println("live chunks:", n)
+}
+
+func xorshift32(x uint32) uint32 {
+	// Algorithm "xor" from p. 4 of Marsaglia, "Xorshift RNGs"
+	x ^= x << 13
+	x ^= x >> 17
+	x ^= x << 5
+	return x
+}
+
+// fill fills buf with a pattern derived from seed.
+func fill(buf []byte, seed uint32) {
+	for i := range buf {
+		seed = xorshift32(seed)
+		buf[i] = byte(seed)
+	}
+}
+
+// check returns whether buf still contains the pattern written by fill.
+func check(buf []byte, seed uint32) bool {
+	for i := range buf {
+		seed = xorshift32(seed)
+		if buf[i] != byte(seed) {
+			return false
+		}
+	}
+	return true
+}
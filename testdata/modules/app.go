@@ -0,0 +1,18 @@
+package main
+
+// This program is built in module mode by TestModules in main_test.go. It
+// imports a package of the main module (which has a path that looks like a
+// standard library path), a module that is downloaded into the module cache
+// and a module that is replaced with a local directory.
+
+import (
+	"example.com/dep"
+	"example.com/replaced"
+	"modtest/greet"
+)
+
+func main() {
+	println(greet.Hello("main module"))
+	println(greet.Hello(dep.Name()))
+	println(greet.Hello(replaced.Name()))
+}
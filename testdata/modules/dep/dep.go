@@ -0,0 +1,7 @@
+// Package dep is served by a module proxy in TestModules, so that it ends up
+// in the module cache like any other required module.
+package dep
+
+func Name() string {
+	return "module cache"
+}
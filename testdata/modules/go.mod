@@ -0,0 +1,10 @@
+module modtest
+
+go 1.11
+
+require (
+	example.com/dep v1.0.0
+	example.com/replaced v1.0.0
+)
+
+replace example.com/replaced => ./replaced
@@ -0,0 +1,5 @@
+package replaced
+
+func Name() string {
+	return "replaced module"
+}
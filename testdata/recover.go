@@ -0,0 +1,173 @@
+package main
+
+import "time"
+
+func main() {
+	println("recover without panic:", recover() == nil)
+
+	recoverSimple()
+	recoverRuntimeError()
+	println("named result:", recoverNamedResult())
+	recoverNested()
+	recoverRepanic()
+	recoverBlocking()
+	recoverIndirect()
+	recoverMethod()
+	recoverOtherMethod()
+	recoverGoroutines()
+	println("done")
+}
+
+func recoverSimple() {
+	defer func() {
+		r := recover()
+		println("recovered:", r.(string))
+	}()
+	defer deferredPrint("deferred call runs before recover")
+	panicString("simple panic")
+	println("not reached")
+}
+
+func panicString(s string) {
+	defer deferredPrint("deferred call in panicking function")
+	panic(s)
+}
+
+func deferredPrint(msg string) {
+	println(msg)
+}
+
+func recoverRuntimeError() {
+	defer func() {
+		r := recover()
+		if err, ok := r.(error); ok {
+			println("recovered:", err.Error())
+		}
+	}()
+	var s []int
+	index := 5
+	println(s[index])
+}
+
+func recoverNamedResult() (n int) {
+	defer func() {
+		if recover() != nil {
+			n = 42
+		}
+	}()
+	n = 1
+	panic("named result")
+}
+
+func recoverNested() {
+	defer func() {
+		println("outer recovered:", recover().(string))
+	}()
+	func() {
+		defer func() {
+			println("inner deferred, not recovering")
+		}()
+		panic("nested panic")
+	}()
+	println("not reached")
+}
+
+func recoverRepanic() {
+	defer func() {
+		println("recovered second panic:", recover().(string))
+	}()
+	defer func() {
+		panic("second panic")
+	}()
+	panic("first panic")
+}
+
+func recoverBlocking() {
+	defer func() {
+		println("recovered after sleep:", recover().(string))
+	}()
+	time.Sleep(time.Millisecond)
+	panicAfterSleep()
+}
+
+func panicAfterSleep() {
+	time.Sleep(time.Millisecond)
+	panic("blocking panic")
+}
+
+func recoverIndirect() {
+	defer func() {
+		println("recovered by deferred function:", recover().(string))
+	}()
+	defer func() {
+		println("recovered by helper:", recoverHelper() != nil)
+	}()
+	defer func() {
+		func() {
+			defer func() {
+				println("recovered by nested deferred function:", recover() != nil)
+			}()
+		}()
+	}()
+	panic("indirect panic")
+}
+
+func recoverHelper() interface{} {
+	return recover()
+}
+
+type recoverer struct {
+	name string
+}
+
+func (r recoverer) Recover() {
+	println("recovered by method of "+r.name+":", recover().(string))
+}
+
+func recoverMethod() {
+	var r interface{ Recover() } = recoverer{"interface"}
+	defer r.Recover()
+	panic("method panic")
+}
+
+type otherRecoverer struct{}
+
+func (otherRecoverer) Recover() {
+	println("recovered by other method:", recover() != nil)
+}
+
+type callsOtherRecoverer struct{}
+
+func (callsOtherRecoverer) Recover() {
+	// A method with the same signature as the deferred method, which is not
+	// called directly by the deferred call.
+	otherRecoverer{}.Recover()
+}
+
+func recoverOtherMethod() {
+	defer func() {
+		println("recovered after other method:", recover().(string))
+	}()
+	var r interface{ Recover() } = callsOtherRecoverer{}
+	defer r.Recover()
+	panic("other method panic")
+}
+
+func recoverGoroutines() {
+	// The deferred call blocks while another goroutine panics and recovers.
+	done := make(chan bool)
+	go func() {
+		defer func() {
+			println("goroutine recovered:", recover().(string))
+			done <- true
+		}()
+		time.Sleep(time.Millisecond)
+		panic("goroutine panic")
+	}()
+	defer func() {
+		time.Sleep(2 * time.Millisecond)
+		println("recovered after blocking:", recover().(string))
+		<-done
+	}()
+	panic("main panic")
+}
@@ -9,6 +9,17 @@ type (
 	myint    int
 	myslice  []byte
 	myslice2 []myint
+	mystruct struct {
+		n    int `foo:"bar"`
+		some point
+		zero struct{}
+		buf  []byte
+		Buf  []byte
+	}
+	point struct {
+		X int16
+		Y int16
+	}
 )
 
 func main() {
@@ -72,15 +83,21 @@ func main() {
 		[]complex128{1, 1.128 + 0.4i},
 		// array
 		[4]int{1, 2, 3, 4},
+		[3]byte{5, 6, 7},
+		[2]string{"a", "bc"},
 		// functions
 		zeroFunc,
 		emptyFunc,
 		// maps
 		zeroMap,
 		map[string]int{},
+		map[string]int{"foo": 3},
+		map[point]byte{{1, 2}: 3},
 		// structs
 		struct{}{},
 		struct{ error }{},
+		point{3, -5},
+		mystruct{n: 5, some: point{-2, 7}, buf: []byte{1}},
 	} {
 		showValue(reflect.ValueOf(v), "")
 	}
@@ -217,6 +234,39 @@ func main() {
 	if rv.Len() != 2 || rv.Index(0).Int() != 3 {
 		panic("slice was changed while setting part of it")
 	}
+
+	// New and Zero
+	println("\nnew and zero values:")
+	rv = reflect.New(reflect.TypeOf(point{}))
+	rv.Elem().Field(1).SetInt(12)
+	showValue(rv, "")
+	showValue(reflect.Zero(reflect.TypeOf(point{})), "")
+	showValue(reflect.Zero(reflect.TypeOf("")), "")
+	showValue(reflect.MakeSlice(reflect.TypeOf([]int16{}), 2, 3), "")
+
+	// Slicing
+	println("\nslicing:")
+	showValue(reflect.ValueOf([]int16{1, 2, 3, 4}).Slice(1, 3), "")
+	showValue(reflect.ValueOf("foobar").Slice(2, 5), "")
+	showValue(reflect.ValueOf(&[3]byte{7, 8, 9}).Elem().Slice(0, 2), "")
+	println(string(reflect.ValueOf([]byte("bytes")).Bytes()))
+
+	// Maps
+	println("\nmaps:")
+	m := map[string]int{"foo": 1, "bar": 2}
+	rv = reflect.ValueOf(m)
+	println(rv.Len(), len(rv.MapKeys()))
+	println(rv.MapIndex(reflect.ValueOf("bar")).Int())
+	println(rv.MapIndex(reflect.ValueOf("baz")).IsValid())
+	points := map[point]string{{1, 2}: "a", {3, 4}: "b"}
+	rv = reflect.ValueOf(points)
+	println(rv.MapIndex(reflect.ValueOf(point{3, 4})).String())
+	println(rv.MapIndex(reflect.ValueOf(point{4, 3})).IsValid())
+	sum := 0
+	for it := reflect.ValueOf(m).MapRange(); it.Next(); {
+		sum += int(it.Value().Int()) * len(it.Key().String())
+	}
+	println("map range sum:", sum)
 }
 
 func emptyFunc() {
@@ -251,7 +301,10 @@ func showValue(rv reflect.Value, indent string) {
 	case reflect.UnsafePointer:
 		println(indent+"  pointer:", rv.Pointer() != 0)
 	case reflect.Array:
-		println(indent + "  array")
+		println(indent+"  array:", rt.Len(), rt.Elem().Kind().String())
+		for i := 0; i < rv.Len(); i++ {
+			showValue(rv.Index(i), indent+"  ")
+		}
 	case reflect.Chan:
 		println(indent+"  chan:", rt.Elem().Kind().String())
 		println(indent+"  nil:", rv.IsNil())
@@ -262,8 +315,12 @@ func showValue(rv reflect.Value, indent string) {
 		println(indent + "  interface")
 		println(indent+"  nil:", rv.IsNil())
 	case reflect.Map:
-		println(indent + "  map")
+		println(indent+"  map:", rt.Key().Kind().String(), rt.Elem().Kind().String(), rv.Len())
 		println(indent+"  nil:", rv.IsNil())
+		for _, key := range rv.MapKeys() {
+			showValue(key, indent+"  ")
+			showValue(rv.MapIndex(key), indent+"  ")
+		}
 	case reflect.Ptr:
 		println(indent+"  pointer:", rv.Pointer() != 0, rt.Elem().Kind().String())
 		println(indent+"  nil:", rv.IsNil())
@@ -279,7 +336,14 @@ func showValue(rv reflect.Value, indent string) {
 			showValue(rv.Index(i), indent+"  ")
 		}
 	case reflect.Struct:
-		println(indent + "  struct")
+		println(indent+"  struct:", rt.NumField())
+		for i := 0; i < rv.NumField(); i++ {
+			field := rt.Field(i)
+			println(indent+"  field:", i, field.Name)
+			println(indent+"  tag:", field.Tag)
+			println(indent+"  embedded:", field.Anonymous)
+			showValue(rv.Field(i), indent+"  ")
+		}
 	default:
 		println(indent + "  unknown type kind!")
 	}
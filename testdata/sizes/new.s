@@ -0,0 +1,47 @@
+# Source of new.elf, which is compared with old.elf in sizes_test.go. All
+# symbols have a fixed size, so that the size differences are known. Build
+# with:
+#   as -o new.o new.s && ld -N --build-id=none -o new.elf new.o
+
+	.text
+	.globl _start
+	.type _start, @function
+_start:
+	.fill 8, 1, 0x90
+	.size _start, 8
+	.type "main.grow", @function
+"main.grow":
+	.fill 48, 1, 0x90
+	.size "main.grow", 48
+	.type "main.same", @function
+"main.same":
+	.fill 8, 1, 0x90
+	.size "main.same", 8
+	.type "runtime.alloc", @function
+"runtime.alloc":
+	.fill 24, 1, 0x90
+	.size "runtime.alloc", 24
+	.type "main.added", @function
+"main.added":
+	.fill 4, 1, 0x90
+	.size "main.added", 4
+
+	.section .rodata
+	.type "main.message", @object
+"main.message":
+	.fill 8, 1, 0x01
+	.size "main.message", 8
+
+	.data
+	.p2align 3
+	.type "main.table", @object
+"main.table":
+	.fill 32, 1, 0x01
+	.size "main.table", 32
+
+	.bss
+	.p2align 3
+	.type "runtime.heap", @object
+"runtime.heap":
+	.zero 128
+	.size "runtime.heap", 128
@@ -0,0 +1,47 @@
+# Source of old.elf, which is compared with new.elf in sizes_test.go. All
+# symbols have a fixed size, so that the size differences are known. Build
+# with:
+#   as -o old.o old.s && ld -N --build-id=none -o old.elf old.o
+
+	.text
+	.globl _start
+	.type _start, @function
+_start:
+	.fill 8, 1, 0x90
+	.size _start, 8
+	.type "main.grow", @function
+"main.grow":
+	.fill 16, 1, 0x90
+	.size "main.grow", 16
+	.type "main.same", @function
+"main.same":
+	.fill 8, 1, 0x90
+	.size "main.same", 8
+	.type "runtime.alloc", @function
+"runtime.alloc":
+	.fill 32, 1, 0x90
+	.size "runtime.alloc", 32
+	.type "runtime.removed", @function
+"runtime.removed":
+	.fill 8, 1, 0x90
+	.size "runtime.removed", 8
+
+	.section .rodata
+	.type "main.message", @object
+"main.message":
+	.fill 8, 1, 0x01
+	.size "main.message", 8
+
+	.data
+	.p2align 3
+	.type "main.table", @object
+"main.table":
+	.fill 16, 1, 0x01
+	.size "main.table", 16
+
+	.bss
+	.p2align 3
+	.type "runtime.heap", @object
+"runtime.heap":
+	.zero 64
+	.size "runtime.heap", 64
@@ -0,0 +1,52 @@
+# Source of stacks.elf, which is used by the tests in stacksize_test.go. It
+# contains a .stack_sizes section like the one LLVM emits, for all functions
+# except main.nosize. Build with:
+#   as -o stacks.o stacks.s && ld -N --build-id=none -o stacks.elf stacks.o
+
+	.text
+	.globl _start
+	.type _start, @function
+_start:
+	.fill 8, 1, 0x90
+	.size _start, 8
+	.type "main.foo", @function
+"main.foo":
+	.fill 8, 1, 0x90
+	.size "main.foo", 8
+	.type "main.bar", @function
+	.type "main.barAlias", @function
+"main.barAlias":
+"main.bar":
+	.fill 8, 1, 0x90
+	.size "main.bar", 8
+	.size "main.barAlias", 8
+	.type "main.recursive", @function
+"main.recursive":
+	.fill 8, 1, 0x90
+	.size "main.recursive", 8
+	.type "main.indirect", @function
+"main.indirect":
+	.fill 8, 1, 0x90
+	.size "main.indirect", 8
+	.type cfunc, @function
+cfunc:
+	.fill 8, 1, 0x90
+	.size cfunc, 8
+	.type "main.nosize", @function
+"main.nosize":
+	.fill 8, 1, 0x90
+	.size "main.nosize", 8
+
+	.section .stack_sizes, "", @progbits
+	.quad _start
+	.uleb128 16
+	.quad "main.foo"
+	.uleb128 32
+	.quad "main.bar"
+	.uleb128 8
+	.quad "main.recursive"
+	.uleb128 24
+	.quad "main.indirect"
+	.uleb128 48
+	.quad cfunc
+	.uleb128 40
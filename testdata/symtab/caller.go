@@ -0,0 +1,37 @@
+package main
+
+// This program is built with a symbol table by TestSymtab, it is not part of
+// TestCompiler as the symbol table is disabled there.
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+var global int
+
+func main() {
+	_, file, line, ok := runtime.Caller(0)
+	println(base(file), line, ok)
+	callee()
+	callee()
+
+	// Addresses outside of any function do not belong to the last function.
+	println(runtime.FuncForPC(uintptr(unsafe.Pointer(&global))) == nil)
+}
+
+//go:noinline
+func callee() {
+	pc, file, line, _ := runtime.Caller(1)
+	println(runtime.FuncForPC(pc).Name(), base(file), line)
+}
+
+// base returns the last element of a path, like filepath.Base.
+func base(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == '/' || path[i] == '\\' {
+			return path[i+1:]
+		}
+	}
+	return path
+}
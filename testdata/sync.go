@@ -0,0 +1,140 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+func main() {
+	testMutex()
+	testRWMutex()
+	testWaitGroup()
+	testCond()
+	testPool()
+}
+
+func testMutex() {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	counter := 0
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			mu.Lock()
+			value := counter
+			time.Sleep(time.Millisecond) // hold the lock while other goroutines try to get it
+			counter = value + 1
+			println("mutex counter incremented to", counter)
+			mu.Unlock()
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	println("mutex counter:", counter)
+}
+
+func testRWMutex() {
+	var rw sync.RWMutex
+	var wg sync.WaitGroup
+
+	// A waiting writer blocks new readers.
+	rw.RLock()
+	wg.Add(2)
+	go func() {
+		rw.Lock()
+		println("writer got lock")
+		rw.Unlock()
+		wg.Done()
+	}()
+	time.Sleep(time.Millisecond)
+	go func() {
+		rw.RLock()
+		println("reader got lock after writer")
+		rw.RUnlock()
+		wg.Done()
+	}()
+	time.Sleep(time.Millisecond)
+	println("first reader unlocks")
+	rw.RUnlock()
+	wg.Wait()
+
+	rw.Lock()
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(n int) {
+			rw.RLock()
+			println("reader", n, "got lock")
+			rw.RUnlock()
+			wg.Done()
+		}(i)
+	}
+	time.Sleep(time.Millisecond)
+	println("writer unlocks")
+	rw.Unlock()
+	wg.Wait()
+}
+
+func testWaitGroup() {
+	var wg sync.WaitGroup
+	wg.Wait() // must not block
+	for i := 1; i <= 3; i++ {
+		wg.Add(1)
+		go func(n int) {
+			time.Sleep(time.Duration(n) * time.Millisecond)
+			println("waitgroup goroutine", n, "done")
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+	println("waitgroup done")
+}
+
+func testCond() {
+	var mu sync.Mutex
+	cond := sync.NewCond(&mu)
+	ready := false
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(n int) {
+			mu.Lock()
+			for !ready {
+				cond.Wait()
+			}
+			println("cond goroutine", n, "woken")
+			mu.Unlock()
+			wg.Done()
+		}(i)
+	}
+	time.Sleep(time.Millisecond)
+	mu.Lock()
+	ready = true
+	cond.Broadcast()
+	mu.Unlock()
+	wg.Wait()
+	println("cond done")
+}
+
+func testPool() {
+	allocated := 0
+	pool := sync.Pool{
+		New: func() interface{} {
+			allocated++
+			return new([16]byte)
+		},
+	}
+	buf := pool.Get().(*[16]byte)
+	println("pool allocated:", allocated)
+	pool.Put(buf)
+	println("pool reused buffer:", pool.Get().(*[16]byte) == buf)
+	println("pool allocated:", allocated)
+
+	// Pooled objects are dropped by the garbage collector. The standard Go
+	// runtime keeps them for one more cycle, so run it twice.
+	pool.Put(buf)
+	runtime.GC()
+	runtime.GC()
+	pool.Get()
+	println("pool allocated after GC:", allocated)
+}
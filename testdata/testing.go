@@ -0,0 +1,147 @@
+package main
+
+// This tests the testing package by running test suites directly, the same way
+// the generated main function of a test binary does. Only fmt is used for
+// output, as println is not captured in examples and may be buffered
+// differently.
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func main() {
+	tests := []testing.TestToCall{
+		{Name: "TestPass", Func: TestPass},
+		{Name: "TestFail", Func: TestFail},
+		{Name: "TestFatal", Func: TestFatal},
+		{Name: "TestSkip", Func: TestSkip},
+		{Name: "TestSubtests", Func: TestSubtests},
+	}
+
+	fmt.Println("# quiet")
+	m := &testing.M{Tests: tests}
+	fmt.Println("exit code:", m.Run())
+
+	fmt.Println("# verbose")
+	m = &testing.M{Tests: tests, Verbose: true}
+	fmt.Println("exit code:", m.Run())
+
+	fmt.Println("# run filter")
+	m = &testing.M{
+		Tests:       tests,
+		Verbose:     true,
+		Filter:      "Sub/b",
+		MatchString: matchString,
+	}
+	fmt.Println("exit code:", m.Run())
+
+	fmt.Println("# examples")
+	m = &testing.M{
+		Examples: []testing.ExampleToCall{
+			{Name: "ExampleHello", Func: ExampleHello, Output: "hello\n"},
+			{Name: "ExampleUnordered", Func: ExampleUnordered, Output: "a\nb\n", Unordered: true},
+			{Name: "ExampleWrong", Func: ExampleWrong, Output: "bar\n"},
+		},
+		Verbose: true,
+	}
+	fmt.Println("exit code:", m.Run())
+
+	fmt.Println("# benchmarks")
+	m = &testing.M{
+		Benchmarks: []testing.BenchmarkToCall{
+			{Name: "BenchmarkFail", Func: BenchmarkFail},
+			{Name: "BenchmarkSkip", Func: BenchmarkSkip},
+			{Name: "BenchmarkSub", Func: BenchmarkSub},
+		},
+		Verbose:     true,
+		Bench:       "Benchmark",
+		BenchTime:   "10x",
+		MatchString: matchString,
+	}
+	fmt.Println("exit code:", m.Run())
+
+	// The benchmark time of 10 iterations is also used by testing.Benchmark.
+	r := testing.Benchmark(benchmarkAlloc)
+	fmt.Println("iterations:", r.N)
+	fmt.Println("allocations:", r.MemString())
+}
+
+// matchString matches a substring instead of a regular expression, which is
+// good enough for this test.
+func matchString(pat, str string) (bool, error) {
+	return strings.Contains(str, pat), nil
+}
+
+func TestPass(t *testing.T) {
+	t.Log("log in", t.Name())
+}
+
+func TestFail(t *testing.T) {
+	t.Errorf("error %d", 1)
+	t.Log("multiple\nlines")
+}
+
+func TestFatal(t *testing.T) {
+	checkHelper(t)
+	t.Log("not reached")
+}
+
+func checkHelper(t *testing.T) {
+	t.Helper()
+	t.Fatal("fatal in helper")
+}
+
+func TestSkip(t *testing.T) {
+	t.Skip("skipped")
+	t.Error("not reached")
+}
+
+func TestSubtests(t *testing.T) {
+	t.Run("a", func(t *testing.T) {
+		t.Log("in a")
+	})
+	t.Run("b c", func(t *testing.T) {
+		t.Run("nested", func(t *testing.T) {
+			t.Error("nested failure")
+		})
+	})
+	ok := t.Run("b c", func(t *testing.T) {})
+	fmt.Println("subtest passed:", ok)
+}
+
+func ExampleHello() {
+	fmt.Println("hello")
+}
+
+func ExampleUnordered() {
+	fmt.Println("b")
+	fmt.Println("a")
+}
+
+func ExampleWrong() {
+	fmt.Println("foo")
+}
+
+func BenchmarkFail(b *testing.B) {
+	b.Error("benchmark failed")
+}
+
+func BenchmarkSkip(b *testing.B) {
+	b.Skip("skipped")
+}
+
+func BenchmarkSub(b *testing.B) {
+	b.Run("fatal", func(b *testing.B) {
+		b.Fatal("fatal in sub-benchmark")
+	})
+}
+
+var sink []byte
+
+func benchmarkAlloc(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sink = make([]byte, 16)
+	}
+}
@@ -0,0 +1,8 @@
+package main
+
+// This package is tested with `tinygo test` by TestTest and TestTestBench in
+// main_test.go.
+
+func add(a, b int) int {
+	return a + b
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestAdd(t *testing.T) {
+	if sum := add(1, 2); sum != 3 {
+		t.Errorf("add(1, 2) = %d, expected 3", sum)
+	}
+	t.Log("add(1, 2) = 3")
+}
+
+func TestAddNegative(t *testing.T) {
+	t.Run("both", func(t *testing.T) {
+		t.Fatal("this subtest should have been filtered out")
+	})
+	t.Run("one", func(t *testing.T) {
+		if sum := add(-1, 2); sum != 1 {
+			t.Errorf("add(-1, 2) = %d, expected 1", sum)
+		}
+		t.Log("add(-1, 2) = 1")
+	})
+}
+
+func TestFiltered(t *testing.T) {
+	t.Fatal("this test should have been filtered out")
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Example_add() {
+	fmt.Println(add(1, 2))
+	fmt.Println(add(-1, 2))
+	// Output:
+	// 3
+	// 1
+}
+
+func BenchmarkAdd(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		add(i, i)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import "time"
+
+var afterFuncCalled bool
+
+func main() {
+	// Wait for a timer channel.
+	<-time.After(time.Millisecond)
+	println("after")
+
+	// A timer that expires while nobody is receiving from its channel.
+	timer := time.NewTimer(time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	<-timer.C
+	println("timer expired")
+
+	// Stop and reset a timer.
+	timer = time.NewTimer(time.Hour)
+	println("stop active timer:", timer.Stop())
+	println("stop stopped timer:", timer.Stop())
+	timer.Reset(time.Millisecond)
+	<-timer.C
+	println("reset timer expired")
+
+	// Tickers.
+	ticker := time.NewTicker(2 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		<-ticker.C
+		println("tick", i)
+	}
+	ticker.Stop()
+
+	// Run a function after some time.
+	time.AfterFunc(time.Millisecond, func() {
+		afterFuncCalled = true
+	})
+	println("before AfterFunc:", afterFuncCalled)
+	time.Sleep(5 * time.Millisecond)
+	println("after AfterFunc:", afterFuncCalled)
+
+	// Select with a timeout.
+	ch := make(chan int)
+	select {
+	case n := <-ch:
+		println("unreachable:", n)
+	case <-time.After(time.Millisecond):
+		println("select timeout")
+	}
+
+	// Select where the timer is not the first to complete. The timer is still
+	// active when main returns, which must not keep the program running.
+	go func(ch chan int) {
+		ch <- 3
+	}(ch)
+	timer = time.NewTimer(time.Hour)
+	select {
+	case n := <-ch:
+		println("select received:", n)
+	case <-timer.C:
+		println("unreachable")
+	}
+}
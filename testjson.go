@@ -0,0 +1,265 @@
+package main
+
+// This file converts the verbose output of a test binary into the JSON event
+// stream of `go test -json`, so that tools that read it (like test dashboards)
+// also work with TinyGo. It is based on cmd/internal/test2json of the Go
+// toolchain. The output is converted on the host, so it works the same for
+// test binaries that run in an emulator.
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// testEvent is a single event in the output of `go test -json`. See
+// `go doc test2json` for a description of the fields.
+type testEvent struct {
+	Time    *time.Time `json:",omitempty"`
+	Action  string
+	Package string   `json:",omitempty"`
+	Test    string   `json:",omitempty"`
+	Elapsed *float64 `json:",omitempty"`
+	Output  *string  `json:",omitempty"`
+}
+
+var (
+	// Printed by the test binary at the end of the test run.
+	testBigPass = []byte("PASS\n")
+	testBigFail = []byte("FAIL\n")
+
+	// Printed by tinygo test after a package failed to build or its test
+	// binary failed, and for packages without tests.
+	testFailPrefix = []byte("FAIL\t")
+	testSkipPrefix = []byte("?   \t")
+	testSkipSuffix = []byte("\t[no test files]\n")
+
+	// Printed when a test starts.
+	testUpdates = [][]byte{
+		[]byte("=== RUN   "),
+		[]byte("=== PAUSE "),
+		[]byte("=== CONT  "),
+	}
+
+	// Printed when a test finishes, possibly indented for subtests.
+	testReports = [][]byte{
+		[]byte("--- PASS: "),
+		[]byte("--- FAIL: "),
+		[]byte("--- SKIP: "),
+		[]byte("--- BENCH: "),
+	}
+)
+
+// testJSONConverter is an io.Writer that converts the output of a test binary
+// (run with -v) into JSON events, which are written to w.
+type testJSONConverter struct {
+	w        io.Writer
+	pkg      string       // package name for the events
+	start    time.Time    // start of the test run, for the final event
+	testName string       // name of the test that produced the current output
+	report   []*testEvent // pending test result reports, by nesting depth
+	result   string       // overall test result (pass, fail or skip), if known
+	buf      []byte       // incomplete line
+	err      error        // first error while writing events
+}
+
+// newTestJSONConverter returns a converter that writes events for the given
+// package to w.
+func newTestJSONConverter(w io.Writer, pkg string) *testJSONConverter {
+	return &testJSONConverter{
+		w:     w,
+		pkg:   pkg,
+		start: time.Now(),
+	}
+}
+
+// Write converts the output of the test binary. Partial lines are kept until
+// they are complete.
+func (c *testJSONConverter) Write(b []byte) (int, error) {
+	c.buf = append(c.buf, b...)
+	for {
+		i := bytes.IndexByte(c.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := c.buf[:i+1]
+		// println on baremetal systems prints CRLF, convert it to LF.
+		if len(line) >= 2 && line[len(line)-2] == '\r' {
+			line = append(line[:len(line)-2:len(line)-2], '\n')
+		}
+		c.handleLine(line)
+		c.buf = c.buf[i+1:]
+	}
+	return len(b), c.err
+}
+
+// Exited must be called after the test binary has exited. If the binary
+// didn't report a result itself (for example, because it crashed), the test
+// run is reported as failed when err is not nil.
+func (c *testJSONConverter) Exited(err error) {
+	if err != nil && c.result == "" {
+		c.result = "fail"
+	}
+}
+
+// Close flushes the remaining output and writes the final event with the
+// result of the test run.
+func (c *testJSONConverter) Close() error {
+	if len(c.buf) != 0 {
+		c.writeOutput(c.buf)
+		c.buf = nil
+	}
+	c.flushReport(0)
+	if c.result != "" {
+		elapsed := time.Since(c.start).Round(time.Millisecond).Seconds()
+		c.writeEvent(&testEvent{Action: c.result, Elapsed: &elapsed})
+	}
+	return c.err
+}
+
+// handleLine converts a single line of output, including the newline.
+func (c *testJSONConverter) handleLine(line []byte) {
+	// Final PASS or FAIL.
+	if bytes.Equal(line, testBigPass) || bytes.Equal(line, testBigFail) {
+		c.flushReport(0)
+		c.writeOutput(line)
+		if bytes.Equal(line, testBigPass) {
+			c.result = "pass"
+		} else {
+			c.result = "fail"
+		}
+		return
+	}
+	if bytes.HasPrefix(line, testFailPrefix) {
+		c.flushReport(0)
+		c.writeOutput(line)
+		c.result = "fail"
+		return
+	}
+	if bytes.HasPrefix(line, testSkipPrefix) && bytes.HasSuffix(line, testSkipSuffix) {
+		c.writeOutput(line)
+		c.result = "skip"
+		return
+	}
+
+	// "=== RUN   " and friends, or "--- PASS: " and friends (possibly
+	// indented).
+	origLine := line
+	isReport := false
+	ok := false
+	indent := 0
+	for _, magic := range testUpdates {
+		if bytes.HasPrefix(line, magic) {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		for bytes.HasPrefix(line, []byte("    ")) {
+			line = line[4:]
+			indent++
+		}
+		for _, magic := range testReports {
+			if bytes.HasPrefix(line, magic) {
+				isReport = true
+				ok = true
+				break
+			}
+		}
+	}
+
+	if !ok {
+		// Not a special line. Use the indentation to find the (sub)test that
+		// produced this output, if possible.
+		if indent > 0 && indent <= len(c.report) {
+			c.testName = c.report[indent-1].Test
+		}
+		c.writeOutput(origLine)
+		return
+	}
+
+	// Parse the action and test name.
+	i := len(testUpdates[0])
+	if isReport {
+		i = bytes.IndexByte(line, ':') + 1
+	}
+	action := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(string(line[4:i])), ":"))
+	name := strings.TrimSpace(string(line[i:]))
+
+	e := &testEvent{Action: action}
+	if isReport {
+		// Parse the elapsed time, like "(0.01s)".
+		if i := strings.Index(name, " ("); i >= 0 {
+			if strings.HasSuffix(name, "s)") {
+				t, err := strconv.ParseFloat(name[i+2:len(name)-2], 64)
+				if err == nil {
+					e.Elapsed = &t
+				}
+			}
+			name = name[:i]
+		}
+		if len(c.report) < indent {
+			// Nested deeper than expected, treat it as plain output.
+			c.writeOutput(origLine)
+			return
+		}
+		// The result of a subtest is printed after the result of its parent
+		// (but indented), so the event of the parent must wait until all
+		// subtest results have been printed.
+		c.flushReport(indent)
+		e.Test = name
+		c.testName = name
+		c.report = append(c.report, e)
+		c.writeOutput(origLine)
+		return
+	}
+
+	// A test is started (or paused, or continued).
+	c.flushReport(0)
+	c.testName = name
+	if action == "pause" {
+		c.writeOutput(origLine)
+	}
+	c.writeEvent(e)
+	if action != "pause" {
+		c.writeOutput(origLine)
+	}
+}
+
+// flushReport writes the pending result events deeper than the given depth.
+func (c *testJSONConverter) flushReport(depth int) {
+	c.testName = ""
+	for len(c.report) > depth {
+		e := c.report[len(c.report)-1]
+		c.report = c.report[:len(c.report)-1]
+		c.writeEvent(e)
+	}
+}
+
+// writeOutput writes an output event for the current test.
+func (c *testJSONConverter) writeOutput(out []byte) {
+	s := string(out)
+	c.writeEvent(&testEvent{Action: "output", Output: &s})
+}
+
+// writeEvent writes a single event as a line of JSON.
+func (c *testJSONConverter) writeEvent(e *testEvent) {
+	e.Package = c.pkg
+	t := time.Now()
+	e.Time = &t
+	if e.Test == "" {
+		e.Test = c.testName
+	}
+	js, err := json.Marshal(e)
+	if err != nil {
+		// Unreachable: all fields can be marshalled.
+		panic("testjson: " + err.Error())
+	}
+	js = append(js, '\n')
+	if _, err := c.w.Write(js); err != nil && c.err == nil {
+		c.err = err
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTestJSONConverter(t *testing.T) {
+	// Verbose output of a test binary, written in two parts to check that
+	// partial lines are handled. println on baremetal targets prints CRLF.
+	output := "=== RUN   TestA\r\n" +
+		"    log in A\n" +
+		"--- PASS: TestA (0.01s)\n" +
+		"=== RUN   TestB\n" +
+		"=== RUN   TestB/sub\n" +
+		"    sub failed\n" +
+		"--- FAIL: TestB (0.00s)\n" +
+		"    --- FAIL: TestB/sub (0.00s)\n" +
+		"FAIL\n"
+	expected := []string{
+		"run TestA",
+		"output TestA === RUN   TestA\n",
+		"output TestA     log in A\n",
+		"output TestA --- PASS: TestA (0.01s)\n",
+		"pass TestA",
+		"run TestB",
+		"output TestB === RUN   TestB\n",
+		"run TestB/sub",
+		"output TestB/sub === RUN   TestB/sub\n",
+		"output TestB/sub     sub failed\n",
+		"output TestB --- FAIL: TestB (0.00s)\n",
+		"output TestB/sub     --- FAIL: TestB/sub (0.00s)\n",
+		"fail TestB/sub",
+		"fail TestB",
+		"output  FAIL\n",
+		"fail ",
+	}
+
+	buf := &bytes.Buffer{}
+	c := newTestJSONConverter(buf, "example.com/pkg")
+	c.Write([]byte(output[:20]))
+	c.Write([]byte(output[20:]))
+	c.Exited(nil)
+	if err := c.Close(); err != nil {
+		t.Fatal("failed to close converter:", err)
+	}
+
+	var actual []string
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var e testEvent
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal("could not decode event:", err)
+		}
+		if e.Package != "example.com/pkg" || e.Time == nil {
+			t.Errorf("unexpected package or time in event: %#v", e)
+		}
+		s := e.Action + " " + e.Test
+		if e.Output != nil {
+			s += " " + *e.Output
+		}
+		actual = append(actual, s)
+	}
+	if strings.Join(actual, "|") != strings.Join(expected, "|") {
+		t.Errorf("unexpected events:\n%q\nexpected:\n%q", actual, expected)
+	}
+}
+
+func TestTestJSONConverterSummary(t *testing.T) {
+	// Summary lines printed by tinygo test itself.
+	for _, tc := range []struct {
+		output string
+		result string
+	}{
+		{"?   \texample.com/pkg\t[no test files]\n", "skip"},
+		{"# example.com/pkg\nerror\nFAIL\texample.com/pkg [build failed]\n", "fail"},
+		{"PASS\nok  \texample.com/pkg\t0.010s\n", "pass"},
+	} {
+		buf := &bytes.Buffer{}
+		c := newTestJSONConverter(buf, "example.com/pkg")
+		c.Write([]byte(tc.output))
+		if err := c.Close(); err != nil {
+			t.Fatal("failed to close converter:", err)
+		}
+		var e testEvent
+		dec := json.NewDecoder(buf)
+		for dec.More() {
+			if err := dec.Decode(&e); err != nil {
+				t.Fatal("could not decode event:", err)
+			}
+		}
+		if e.Action != tc.result {
+			t.Errorf("unexpected result for %q: %s (expected %s)", tc.output, e.Action, tc.result)
+		}
+	}
+}